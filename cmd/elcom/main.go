@@ -5,16 +5,22 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strings"
+	"syscall"
 
 	"elcom/internal"
 	"elcom/internal/catalog"
 	"elcom/internal/config"
 	"elcom/internal/connectors"
+	fileconnector "elcom/internal/connectors/file"
 	gmailconnector "elcom/internal/connectors/gmail"
+	graphconnector "elcom/internal/connectors/graph"
 	imapconnector "elcom/internal/connectors/imap"
+	pop3connector "elcom/internal/connectors/pop3"
 	"elcom/internal/listener"
+	"elcom/internal/outbound"
 	"elcom/internal/pipeline"
 	"elcom/internal/storage"
 )
@@ -32,11 +38,14 @@ func main() {
 	must(err)
 	defer db.Close()
 
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	cmd := os.Args[1]
 	switch cmd {
 	case "catalog:initial-sync":
 		svc := catalog.NewSyncService(db, cfg)
-		count, err := svc.InitialSync(context.Background())
+		count, err := svc.InitialSync(ctx)
 		must(err)
 		fmt.Printf("initial sync complete: %d products\n", count)
 	case "catalog:incremental-sync":
@@ -47,37 +56,50 @@ func main() {
 			must(fmt.Errorf("--mode is required"))
 		}
 		svc := catalog.NewSyncService(db, cfg)
-		count, err := svc.IncrementalSync(context.Background(), *mode)
+		count, err := svc.IncrementalSync(ctx, *mode)
 		must(err)
 		fmt.Printf("incremental sync complete mode=%s products=%d\n", *mode, count)
 	case "mail:fetch":
 		fs := flag.NewFlagSet(cmd, flag.ExitOnError)
-		provider := fs.String("provider", "gmail", "gmail|imap")
+		provider := fs.String("provider", "gmail", "gmail|imap|graph|pop3|file")
 		label := fs.String("label", "INBOX", "mailbox/label")
 		max := fs.Int("max", 50, "max messages")
+		mailbox := fs.String("mailbox", "", "mailbox name from MAIL_LISTENER_MAILBOXES_FILE")
 		_ = fs.Parse(os.Args[2:])
-		conn, err := makeConnector(cfg, *provider)
+		cfg, *provider = forMailbox(cfg, *mailbox, *provider)
+		conn, err := makeConnector(cfg, db, *provider)
 		must(err)
-		fetch := connectors.NewFetchService(db, cfg.RawMailDir, conn)
-		result, err := fetch.FetchAndStore(*label, *max)
+		fetch := connectors.NewFetchService(db, cfg.RawMailDir, *mailbox, conn)
+		result, err := fetch.FetchAndStore(ctx, *label, *max)
+		if err != nil {
+			fmt.Printf("mail fetch interrupted provider=%s fetched=%d stored=%d\n", *provider, result.Fetched, result.Stored)
+		}
 		must(err)
 		fmt.Printf("mail fetch done provider=%s fetched=%d stored=%d\n", *provider, result.Fetched, result.Stored)
 	case "mail:process":
 		fs := flag.NewFlagSet(cmd, flag.ExitOnError)
-		provider := fs.String("provider", "gmail", "gmail|imap")
+		provider := fs.String("provider", "gmail", "gmail|imap|graph|pop3|file")
 		messageID := fs.String("messageId", "", "specific message-id")
 		batch := fs.Int("batch", 20, "batch size")
+		mailbox := fs.String("mailbox", "", "mailbox name from MAIL_LISTENER_MAILBOXES_FILE")
 		_ = fs.Parse(os.Args[2:])
+		cfg, *provider = forMailbox(cfg, *mailbox, *provider)
 		processor := pipeline.NewProcessingService(db, cfg)
 		if strings.TrimSpace(*messageID) != "" {
 			res, err := processor.ProcessByProviderMessageID(*provider, *messageID)
 			must(err)
 			fmt.Printf("processed email id=%d lines=%d\n", res.EmailID, res.Processed)
-			return
+			for _, f := range res.AttachmentFailures {
+				fmt.Printf("skipped attachment %s: %s\n", f.Attachment, f.Error)
+			}
+		} else {
+			processedEmails, processedLines, err := processor.ProcessPending(*batch, *provider, *mailbox)
+			must(err)
+			fmt.Printf("processed pending emails=%d lines=%d\n", processedEmails, processedLines)
+		}
+		if cfg.OutcomeWriteback {
+			must(writeBack(ctx, cfg, db, *provider, *mailbox))
 		}
-		processedEmails, processedLines, err := processor.ProcessPending(*batch, *provider)
-		must(err)
-		fmt.Printf("processed pending emails=%d lines=%d\n", processedEmails, processedLines)
 	case "export:xlsx":
 		fs := flag.NewFlagSet(cmd, flag.ExitOnError)
 		emailID := fs.Int("emailId", 0, "internal email id")
@@ -93,13 +115,47 @@ func main() {
 		}
 		must(pipeline.ExportRowsToXLSX(rows, *out))
 		fmt.Printf("exported %d rows to %s\n", len(rows), *out)
+	case "mail:reply":
+		fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+		emailID := fs.Int("emailId", 0, "internal email id; omit to list held and failed replies")
+		approve := fs.Bool("approve", false, "send even if the reply policy would hold it")
+		retryFailed := fs.Bool("retry-failed", false, "send every failed reply again")
+		_ = fs.Parse(os.Args[2:])
+		if *emailID == 0 && !*retryFailed {
+			held, err := db.ListRepliesByStatus(outbound.StatusHeld, 200)
+			must(err)
+			for _, r := range held {
+				fmt.Printf("held emailId=%d to=%s subject=%s\n", r.EmailID, r.Recipient, r.Subject)
+			}
+			failed, err := db.ListRepliesByStatus(outbound.StatusFailed, 200)
+			must(err)
+			for _, r := range failed {
+				fmt.Printf("failed emailId=%d to=%s subject=%s error=%s\n", r.EmailID, r.Recipient, r.Subject, r.Error)
+			}
+			fmt.Printf("held replies=%d failed replies=%d\n", len(held), len(failed))
+			return
+		}
+		replies, err := outbound.NewReplyService(cfg, db)
+		must(err)
+		if *retryFailed {
+			results, err := replies.RetryFailed(200)
+			for _, res := range results {
+				fmt.Printf("reply emailId=%d status=%s to=%s\n", res.EmailID, res.Status, res.Recipient)
+			}
+			must(err)
+			return
+		}
+		res, err := replies.Reply(*emailID, *approve)
+		must(err)
+		fmt.Printf("reply emailId=%d status=%s to=%s ok=%d review=%d notFound=%d\n",
+			res.EmailID, res.Status, res.Recipient, res.Summary.OK, res.Summary.Review, res.Summary.NotFound)
 	case "mail:listen":
 		s := listener.NewService(db, cfg)
-		must(s.Run(context.Background()))
+		must(s.Run(ctx))
 	case "run":
 		fs := flag.NewFlagSet(cmd, flag.ExitOnError)
 		input := fs.String("input", "", "input file path or raw text")
-		inType := fs.String("type", "", "xlsx|pdf|email_text|email_table")
+		inType := fs.String("type", "", "xlsx|xls|csv|pdf|email_text|email_table")
 		output := fs.String("output", "", "output xlsx path")
 		_ = fs.Parse(os.Args[2:])
 		if *input == "" || *inType == "" || *output == "" {
@@ -107,7 +163,7 @@ func main() {
 		}
 
 		value := *input
-		if (*inType == "xlsx" || *inType == "pdf") && !filepath.IsAbs(*input) {
+		if (*inType == "xlsx" || *inType == "xls" || *inType == "csv" || *inType == "pdf") && !filepath.IsAbs(*input) {
 			value = *input
 		}
 		items, err := pipeline.ExtractItemsFromInput(*inType, value)
@@ -126,11 +182,16 @@ func main() {
 				Source:           string(item.Source),
 				RawLine:          item.RawLine,
 				ParsedNameOrCode: item.NameOrCode,
+				ParsedCode:       item.Code,
 				ParsedQty:        item.Qty,
 				ParsedUnit:       item.Unit,
+				ConvertedQty:     match.ConvertedQty,
+				ConvertedUnit:    match.ConvertedUnit,
+				OrderableQty:     match.OrderableQty,
 				MatchStatus:      string(match.Status),
 				Confidence:       match.Confidence,
 				MatchReason:      string(match.Reason),
+				MatchWarnings:    strings.Join(match.Warnings, ","),
 			}
 			if match.Product != nil {
 				row.ProductID = match.Product.ID
@@ -158,27 +219,64 @@ func main() {
 	}
 }
 
-func makeConnector(cfg config.Config, provider string) (connectors.MailConnector, error) {
+func makeConnector(cfg config.Config, db *storage.DB, provider string) (connectors.MailConnector, error) {
 	switch strings.ToLower(strings.TrimSpace(provider)) {
 	case "gmail":
-		return gmailconnector.NewConnector(cfg)
+		return gmailconnector.NewConnector(cfg, db)
 	case "imap":
-		return imapconnector.NewConnector(cfg)
+		return imapconnector.NewConnector(cfg, db)
+	case graphconnector.Provider:
+		return graphconnector.NewConnector(cfg, db)
+	case pop3connector.Provider:
+		return pop3connector.NewConnector(cfg, db)
+	case "file":
+		return fileconnector.NewConnector(cfg, db)
 	default:
 		return nil, fmt.Errorf("unsupported provider: %s", provider)
 	}
 }
 
+// forMailbox applies the named mailbox definition to cfg; its provider wins
+// over --provider. An empty name leaves both untouched.
+func forMailbox(cfg config.Config, name, provider string) (config.Config, string) {
+	if strings.TrimSpace(name) == "" {
+		return cfg, provider
+	}
+	mb, err := cfg.Mailbox(name)
+	must(err)
+	return cfg.ForMailbox(mb), mb.Provider
+}
+
+// writeBack mirrors processing outcomes into the source mailbox for
+// connectors that support it.
+func writeBack(ctx context.Context, cfg config.Config, db *storage.DB, provider, mailbox string) error {
+	conn, err := makeConnector(cfg, db, provider)
+	if err != nil {
+		return err
+	}
+	writer, ok := conn.(connectors.OutcomeWriter)
+	if !ok {
+		return nil
+	}
+	res, err := connectors.NewWritebackService(db, writer, cfg.OutcomeWritebackMaxAttempts).Sync(ctx, provider, mailbox)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("writeback provider=%s written=%d pending=%d failed=%d\n", provider, res.Written, res.Pending, res.Failed)
+	return nil
+}
+
 func usage() {
 	fmt.Println("usage: elcom <command>")
 	fmt.Println("commands:")
 	fmt.Println("  catalog:initial-sync")
 	fmt.Println("  catalog:incremental-sync --mode=hour_price|hour_stock|day")
-	fmt.Println("  mail:fetch --provider=gmail|imap --label=INBOX --max=50")
-	fmt.Println("  mail:process --provider=gmail|imap [--messageId=...] [--batch=20]")
+	fmt.Println("  mail:fetch --provider=gmail|imap|graph|pop3|file --label=INBOX --max=50 [--mailbox=name]")
+	fmt.Println("  mail:process --provider=gmail|imap|graph|pop3|file [--messageId=...] [--batch=20] [--mailbox=name]")
+	fmt.Println("  mail:reply [--emailId=1 [--approve] | --retry-failed]")
 	fmt.Println("  mail:listen")
 	fmt.Println("  export:xlsx --emailId=1 --out=./out/result.xlsx")
-	fmt.Println("  run --input=... --type=xlsx|pdf|email_text|email_table --output=...xlsx")
+	fmt.Println("  run --input=... --type=xlsx|xls|csv|pdf|email_text|email_table --output=...xlsx")
 }
 
 func must(err error) {
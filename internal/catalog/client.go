@@ -222,6 +222,7 @@ func toProductRecord(raw map[string]any) (internal.ProductRecord, error) {
 	product.UnitHeader = toStringPtr(raw["unitHeader"])
 	product.ManufacturerHeader = toStringPtr(raw["manufacturerHeader"])
 	product.MultiplicityOrder = toFloatPtr(raw["multiplicityOrder"])
+	product.PackSize = toFloatPtr(raw["packSize"])
 	product.UpdatedAt = toStringPtr(raw["updatedAt"])
 	product.FlatCodes = toFlatCodes(raw["flatCodes"])
 	product.AnalogCodes = toStringSlice(raw["analogCodes"])
@@ -25,12 +25,38 @@ type Config struct {
 	MatchOKThreshold     float64
 	MatchReviewThreshold float64
 	MatchGapThreshold    float64
+	// MultiplicityReview sends rows whose qty was rounded up to the order
+	// multiplicity to review instead of only warning.
+	MultiplicityReview bool
+
+	QuoteDetectThreshold float64
+	ExtractQuotedText    bool
+
+	OCREnabled             bool
+	OCRTesseractPath       string
+	OCRPDFToPPMPath        string
+	OCRLanguages           string
+	OCRTimeoutSec          int
+	OCRMinConfidence       float64
+	OCRLowConfidenceReview bool
+
+	ArchiveMaxDepth      int
+	ArchiveMaxFiles      int
+	ArchiveMaxUnpackedMB int
+	Archive7zPath        string
 
 	GmailClientID     string
 	GmailClientSecret string
 	GmailRedirectURI  string
 	GmailRefreshToken string
 
+	GraphTenantID     string
+	GraphClientID     string
+	GraphClientSecret string
+	GraphUser         string
+	GraphBaseURL      string
+	GraphTokenURL     string
+
 	IMAPHost     string
 	IMAPPort     int
 	IMAPSecure   bool
@@ -38,12 +64,53 @@ type Config struct {
 	IMAPPassword string
 	IMAPMarkSeen bool
 
+	POP3Host             string
+	POP3Port             int
+	POP3Security         string
+	POP3User             string
+	POP3Password         string
+	POP3DeleteAfterStore bool
+
+	IMAPOutcomeFolderProcessed string
+	IMAPOutcomeFolderReview    string
+	IMAPOutcomeFolderSkipped   string
+
+	FileMailRoot          string
+	FileMailMoveProcessed bool
+
+	SMTPInboundEnabled    bool
+	SMTPInboundAddr       string
+	SMTPInboundDomain     string
+	SMTPInboundLMTP       bool
+	SMTPInboundRecipients []string
+	SMTPInboundMaxBytes   int64
+	SMTPInboundTLSCert    string
+	SMTPInboundTLSKey     string
+
+	SMTPOutHost       string
+	SMTPOutPort       int
+	SMTPOutUser       string
+	SMTPOutPassword   string
+	SMTPOutFrom       string
+	SMTPOutFromName   string
+	ReplyPolicy       string
+	ReplyTemplatePath string
+
+	OutcomeWriteback            bool
+	OutcomeWritebackMaxAttempts int
+	GmailOutcomeLabelPrefix     string
+
 	MailListenerProvider     string
+	MailListenerMode         string
 	MailListenerLabel        string
 	MailListenerIntervalSec  int
 	MailListenerFetchMax     int
 	MailListenerProcessBatch int
 	MailListenerAutoExport   bool
+	MailListenerAutoReply    bool
+	// MailListenerMailboxesFile points to a JSON list of Mailbox definitions;
+	// empty means the single mailbox described by MAIL_LISTENER_*.
+	MailListenerMailboxesFile string
 }
 
 func Load() (Config, error) {
@@ -69,12 +136,36 @@ func Load() (Config, error) {
 		MatchOKThreshold:     getEnvFloat("MATCH_OK_THRESHOLD", 0.90),
 		MatchReviewThreshold: getEnvFloat("MATCH_REVIEW_THRESHOLD", 0.72),
 		MatchGapThreshold:    getEnvFloat("MATCH_GAP_THRESHOLD", 0.08),
+		MultiplicityReview:   getEnvBool("MATCH_MULTIPLICITY_REVIEW", false),
+
+		QuoteDetectThreshold: getEnvFloat("QUOTE_DETECT_THRESHOLD", 0.45),
+		ExtractQuotedText:    getEnvBool("EXTRACT_QUOTED_TEXT", false),
+
+		OCREnabled:             getEnvBool("OCR_ENABLED", false),
+		OCRTesseractPath:       getEnv("OCR_TESSERACT_PATH", "tesseract"),
+		OCRPDFToPPMPath:        getEnv("OCR_PDFTOPPM_PATH", "pdftoppm"),
+		OCRLanguages:           getEnv("OCR_LANGUAGES", "rus+eng"),
+		OCRTimeoutSec:          getEnvInt("OCR_TIMEOUT_SEC", 120),
+		OCRMinConfidence:       getEnvFloat("OCR_MIN_CONFIDENCE", 70),
+		OCRLowConfidenceReview: getEnvBool("OCR_LOW_CONFIDENCE_REVIEW", true),
+
+		ArchiveMaxDepth:      getEnvInt("ARCHIVE_MAX_DEPTH", 3),
+		ArchiveMaxFiles:      getEnvInt("ARCHIVE_MAX_FILES", 200),
+		ArchiveMaxUnpackedMB: getEnvInt("ARCHIVE_MAX_UNPACKED_MB", 100),
+		Archive7zPath:        getEnv("ARCHIVE_7Z_PATH", "7z"),
 
 		GmailClientID:     getEnv("GMAIL_CLIENT_ID", ""),
 		GmailClientSecret: getEnv("GMAIL_CLIENT_SECRET", ""),
 		GmailRedirectURI:  getEnv("GMAIL_REDIRECT_URI", "https://developers.google.com/oauthplayground"),
 		GmailRefreshToken: getEnv("GMAIL_REFRESH_TOKEN", ""),
 
+		GraphTenantID:     getEnv("GRAPH_TENANT_ID", ""),
+		GraphClientID:     getEnv("GRAPH_CLIENT_ID", ""),
+		GraphClientSecret: getEnv("GRAPH_CLIENT_SECRET", ""),
+		GraphUser:         getEnv("GRAPH_USER", ""),
+		GraphBaseURL:      getEnv("GRAPH_BASE_URL", "https://graph.microsoft.com/v1.0"),
+		GraphTokenURL:     getEnv("GRAPH_TOKEN_URL", ""),
+
 		IMAPHost:     getEnv("IMAP_HOST", ""),
 		IMAPPort:     getEnvInt("IMAP_PORT", 993),
 		IMAPSecure:   getEnvBool("IMAP_SECURE", true),
@@ -82,12 +173,52 @@ func Load() (Config, error) {
 		IMAPPassword: getEnv("IMAP_PASSWORD", ""),
 		IMAPMarkSeen: getEnvBool("IMAP_MARK_SEEN", false),
 
+		POP3Host:             getEnv("POP3_HOST", ""),
+		POP3Port:             getEnvInt("POP3_PORT", 995),
+		POP3Security:         getEnv("POP3_SECURITY", "tls"),
+		POP3User:             getEnv("POP3_USER", ""),
+		POP3Password:         getEnv("POP3_PASSWORD", ""),
+		POP3DeleteAfterStore: getEnvBool("POP3_DELETE_AFTER_STORE", false),
+
+		IMAPOutcomeFolderProcessed: getEnv("IMAP_OUTCOME_FOLDER_PROCESSED", ""),
+		IMAPOutcomeFolderReview:    getEnv("IMAP_OUTCOME_FOLDER_REVIEW", ""),
+		IMAPOutcomeFolderSkipped:   getEnv("IMAP_OUTCOME_FOLDER_SKIPPED", ""),
+
+		FileMailRoot:          getEnv("FILE_MAIL_ROOT", filepath.Join(cwd, "data", "inbox")),
+		FileMailMoveProcessed: getEnvBool("FILE_MAIL_MOVE_PROCESSED", false),
+
+		SMTPInboundEnabled:    getEnvBool("SMTP_INBOUND_ENABLED", false),
+		SMTPInboundAddr:       getEnv("SMTP_INBOUND_ADDR", ":2525"),
+		SMTPInboundDomain:     getEnv("SMTP_INBOUND_DOMAIN", "localhost"),
+		SMTPInboundLMTP:       getEnvBool("SMTP_INBOUND_LMTP", false),
+		SMTPInboundRecipients: getEnvList("SMTP_INBOUND_RECIPIENTS"),
+		SMTPInboundMaxBytes:   int64(getEnvInt("SMTP_INBOUND_MAX_BYTES", 25<<20)),
+		SMTPInboundTLSCert:    getEnv("SMTP_INBOUND_TLS_CERT", ""),
+		SMTPInboundTLSKey:     getEnv("SMTP_INBOUND_TLS_KEY", ""),
+
+		SMTPOutHost:       getEnv("SMTP_OUT_HOST", ""),
+		SMTPOutPort:       getEnvInt("SMTP_OUT_PORT", 587),
+		SMTPOutUser:       getEnv("SMTP_OUT_USER", ""),
+		SMTPOutPassword:   getEnv("SMTP_OUT_PASSWORD", ""),
+		SMTPOutFrom:       getEnv("SMTP_OUT_FROM", ""),
+		SMTPOutFromName:   getEnv("SMTP_OUT_FROM_NAME", ""),
+		ReplyPolicy:       getEnv("REPLY_POLICY", "hold_on_review"),
+		ReplyTemplatePath: getEnv("REPLY_TEMPLATE_PATH", ""),
+
+		OutcomeWriteback:            getEnvBool("OUTCOME_WRITEBACK", false),
+		OutcomeWritebackMaxAttempts: getEnvInt("OUTCOME_WRITEBACK_MAX_ATTEMPTS", 5),
+		GmailOutcomeLabelPrefix:     getEnv("GMAIL_OUTCOME_LABEL_PREFIX", "Elcom"),
+
 		MailListenerProvider:     getEnv("MAIL_LISTENER_PROVIDER", "gmail"),
+		MailListenerMode:         getEnv("MAIL_LISTENER_MODE", "poll"),
 		MailListenerLabel:        getEnv("MAIL_LISTENER_LABEL", "INBOX"),
 		MailListenerIntervalSec:  getEnvInt("MAIL_LISTENER_INTERVAL_SEC", 30),
 		MailListenerFetchMax:     getEnvInt("MAIL_LISTENER_FETCH_MAX", 20),
 		MailListenerProcessBatch: getEnvInt("MAIL_LISTENER_PROCESS_BATCH", 20),
 		MailListenerAutoExport:   getEnvBool("MAIL_LISTENER_AUTO_EXPORT", true),
+		MailListenerAutoReply:    getEnvBool("MAIL_LISTENER_AUTO_REPLY", false),
+
+		MailListenerMailboxesFile: getEnv("MAIL_LISTENER_MAILBOXES_FILE", ""),
 	}
 
 	return cfg, nil
@@ -131,6 +262,16 @@ func getEnvFloat(key string, fallback float64) float64 {
 	return parsed
 }
 
+func getEnvList(key string) []string {
+	var out []string
+	for _, part := range strings.Split(getEnv(key, ""), ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
+
 func getEnvBool(key string, fallback bool) bool {
 	value := strings.ToLower(strings.TrimSpace(getEnv(key, "")))
 	if value == "" {
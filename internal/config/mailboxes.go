@@ -0,0 +1,183 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Mailbox is one listener source. Zero fields inherit the global settings,
+// so a definition only lists what differs from the env config.
+type Mailbox struct {
+	Name         string   `json:"name"`
+	Provider     string   `json:"provider"`
+	Mode         string   `json:"mode"`
+	Labels       []string `json:"labels"`
+	IntervalSec  int      `json:"intervalSec"`
+	FetchMax     int      `json:"fetchMax"`
+	ProcessBatch int      `json:"processBatch"`
+
+	GmailClientID     string `json:"gmailClientId"`
+	GmailClientSecret string `json:"gmailClientSecret"`
+	GmailRefreshToken string `json:"gmailRefreshToken"`
+
+	GraphTenantID     string `json:"graphTenantId"`
+	GraphClientID     string `json:"graphClientId"`
+	GraphClientSecret string `json:"graphClientSecret"`
+	GraphUser         string `json:"graphUser"`
+
+	IMAPHost     string `json:"imapHost"`
+	IMAPPort     int    `json:"imapPort"`
+	IMAPSecure   *bool  `json:"imapSecure"`
+	IMAPUser     string `json:"imapUser"`
+	IMAPPassword string `json:"imapPassword"`
+
+	POP3Host             string `json:"pop3Host"`
+	POP3Port             int    `json:"pop3Port"`
+	POP3Security         string `json:"pop3Security"`
+	POP3User             string `json:"pop3User"`
+	POP3Password         string `json:"pop3Password"`
+	POP3DeleteAfterStore *bool  `json:"pop3DeleteAfterStore"`
+
+	FileMailRoot string `json:"fileMailRoot"`
+
+	QuoteDetectThreshold float64 `json:"quoteDetectThreshold"`
+	MatchOKThreshold     float64 `json:"matchOkThreshold"`
+	MatchReviewThreshold float64 `json:"matchReviewThreshold"`
+	MatchGapThreshold    float64 `json:"matchGapThreshold"`
+}
+
+// Mailboxes returns the listener mailboxes. Without MAIL_LISTENER_MAILBOXES_FILE
+// it is the single MAIL_LISTENER_* mailbox; it has no name, so its emails stay
+// untagged as before.
+func (c Config) Mailboxes() ([]Mailbox, error) {
+	if strings.TrimSpace(c.MailListenerMailboxesFile) == "" {
+		return []Mailbox{{
+			Provider: c.MailListenerProvider,
+			Mode:     c.MailListenerMode,
+			Labels:   []string{c.MailListenerLabel},
+		}}, nil
+	}
+
+	blob, err := os.ReadFile(c.MailListenerMailboxesFile)
+	if err != nil {
+		return nil, err
+	}
+	var mailboxes []Mailbox
+	if err := json.Unmarshal(blob, &mailboxes); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", c.MailListenerMailboxesFile, err)
+	}
+	if len(mailboxes) == 0 {
+		return nil, fmt.Errorf("%s defines no mailboxes", c.MailListenerMailboxesFile)
+	}
+
+	seen := map[string]bool{}
+	for i := range mailboxes {
+		mb := &mailboxes[i]
+		mb.Name = strings.TrimSpace(mb.Name)
+		mb.Provider = strings.ToLower(strings.TrimSpace(mb.Provider))
+		if mb.Name == "" {
+			return nil, fmt.Errorf("mailbox #%d: name is required", i+1)
+		}
+		if seen[mb.Name] {
+			return nil, fmt.Errorf("mailbox %s: duplicate name", mb.Name)
+		}
+		seen[mb.Name] = true
+		if mb.Provider == "" {
+			return nil, fmt.Errorf("mailbox %s: provider is required", mb.Name)
+		}
+	}
+	return mailboxes, nil
+}
+
+// Mailbox looks up a definition by name.
+func (c Config) Mailbox(name string) (Mailbox, error) {
+	mailboxes, err := c.Mailboxes()
+	if err != nil {
+		return Mailbox{}, err
+	}
+	for _, mb := range mailboxes {
+		if mb.Name == name {
+			return mb, nil
+		}
+	}
+	return Mailbox{}, fmt.Errorf("unknown mailbox: %s", name)
+}
+
+// ForMailbox returns a copy of c with the mailbox overrides applied. The
+// result is what connectors and the processing pipeline of that mailbox see.
+func (c Config) ForMailbox(mb Mailbox) Config {
+	out := c
+	out.MailListenerMailboxesFile = ""
+
+	setString(&out.MailListenerProvider, mb.Provider)
+	setString(&out.MailListenerMode, mb.Mode)
+	if len(mb.Labels) > 0 {
+		out.MailListenerLabel = mb.Labels[0]
+	}
+	setInt(&out.MailListenerIntervalSec, mb.IntervalSec)
+	setInt(&out.MailListenerFetchMax, mb.FetchMax)
+	setInt(&out.MailListenerProcessBatch, mb.ProcessBatch)
+
+	setString(&out.GmailClientID, mb.GmailClientID)
+	setString(&out.GmailClientSecret, mb.GmailClientSecret)
+	setString(&out.GmailRefreshToken, mb.GmailRefreshToken)
+
+	setString(&out.GraphTenantID, mb.GraphTenantID)
+	setString(&out.GraphClientID, mb.GraphClientID)
+	setString(&out.GraphClientSecret, mb.GraphClientSecret)
+	setString(&out.GraphUser, mb.GraphUser)
+
+	setString(&out.IMAPHost, mb.IMAPHost)
+	setInt(&out.IMAPPort, mb.IMAPPort)
+	if mb.IMAPSecure != nil {
+		out.IMAPSecure = *mb.IMAPSecure
+	}
+	setString(&out.IMAPUser, mb.IMAPUser)
+	setString(&out.IMAPPassword, mb.IMAPPassword)
+
+	setString(&out.POP3Host, mb.POP3Host)
+	setInt(&out.POP3Port, mb.POP3Port)
+	setString(&out.POP3Security, mb.POP3Security)
+	setString(&out.POP3User, mb.POP3User)
+	setString(&out.POP3Password, mb.POP3Password)
+	if mb.POP3DeleteAfterStore != nil {
+		out.POP3DeleteAfterStore = *mb.POP3DeleteAfterStore
+	}
+
+	setString(&out.FileMailRoot, mb.FileMailRoot)
+
+	setFloat(&out.QuoteDetectThreshold, mb.QuoteDetectThreshold)
+	setFloat(&out.MatchOKThreshold, mb.MatchOKThreshold)
+	setFloat(&out.MatchReviewThreshold, mb.MatchReviewThreshold)
+	setFloat(&out.MatchGapThreshold, mb.MatchGapThreshold)
+	return out
+}
+
+// LabelsOrDefault returns the labels to fetch, falling back to the global
+// MAIL_LISTENER_LABEL.
+func (mb Mailbox) LabelsOrDefault(c Config) []string {
+	if len(mb.Labels) > 0 {
+		return mb.Labels
+	}
+	return []string{c.MailListenerLabel}
+}
+
+func setString(dst *string, v string) {
+	if strings.TrimSpace(v) != "" {
+		*dst = v
+	}
+}
+
+func setInt(dst *int, v int) {
+	if v > 0 {
+		*dst = v
+	}
+}
+
+func setFloat(dst *float64, v float64) {
+	if v > 0 {
+		*dst = v
+	}
+}
@@ -0,0 +1,64 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMailboxesFromFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mailboxes.json")
+	blob := `[
+  {"name": "sales", "provider": "IMAP", "imapUser": "sales@example.com", "imapSecure": false, "labels": ["INBOX", "Orders"], "fetchMax": 5},
+  {"name": "supply", "provider": "gmail", "matchOkThreshold": 0.95}
+]`
+	if err := os.WriteFile(path, []byte(blob), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	base := Config{
+		MailListenerMailboxesFile: path,
+		MailListenerLabel:         "INBOX",
+		MailListenerFetchMax:      20,
+		IMAPHost:                  "imap.example.com",
+		IMAPSecure:                true,
+		IMAPUser:                  "shared@example.com",
+		MatchOKThreshold:          0.9,
+	}
+
+	mailboxes, err := base.Mailboxes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mailboxes) != 2 || mailboxes[0].Provider != "imap" {
+		t.Fatalf("mailboxes=%+v", mailboxes)
+	}
+
+	sales := base.ForMailbox(mailboxes[0])
+	if sales.IMAPHost != "imap.example.com" || sales.IMAPUser != "sales@example.com" || sales.IMAPSecure {
+		t.Fatalf("sales imap=%s %s %t", sales.IMAPHost, sales.IMAPUser, sales.IMAPSecure)
+	}
+	if sales.MailListenerFetchMax != 5 || sales.MatchOKThreshold != 0.9 {
+		t.Fatalf("sales fetchMax=%d ok=%v", sales.MailListenerFetchMax, sales.MatchOKThreshold)
+	}
+
+	supply, err := base.Mailbox("supply")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := base.ForMailbox(supply); got.MatchOKThreshold != 0.95 || got.MailListenerFetchMax != 20 {
+		t.Fatalf("supply ok=%v fetchMax=%d", got.MatchOKThreshold, got.MailListenerFetchMax)
+	}
+	if labels := supply.LabelsOrDefault(base); len(labels) != 1 || labels[0] != "INBOX" {
+		t.Fatalf("labels=%v", labels)
+	}
+}
+
+func TestMailboxesRejectDuplicates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mailboxes.json")
+	if err := os.WriteFile(path, []byte(`[{"name":"a","provider":"imap"},{"name":"a","provider":"gmail"}]`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := (Config{MailListenerMailboxesFile: path}).Mailboxes(); err == nil {
+		t.Fatal("duplicate names accepted")
+	}
+}
@@ -1,7 +1,24 @@
 package connectors
 
-import "elcom/internal"
+import (
+	"context"
+
+	"elcom/internal"
+)
+
+// MessageHandler receives fetched messages one at a time. An error stops the
+// fetch, and the connector must not advance its cursor past that message.
+type MessageHandler func(internal.FetchedMailMessage) error
 
 type MailConnector interface {
-	FetchInbox(label string, max int) ([]internal.FetchedMailMessage, error)
+	FetchInbox(ctx context.Context, label string, max int, handle MessageHandler) error
+}
+
+// CtxErr prefers the context error when a command failed because
+// cancellation terminated the connection.
+func CtxErr(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
 }
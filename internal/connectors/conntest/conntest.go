@@ -0,0 +1,50 @@
+// Package conntest holds fixtures shared by the connector tests.
+package conntest
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"elcom/internal"
+	"elcom/internal/connectors"
+	"elcom/internal/storage"
+)
+
+// RawMessage is a small RFC 822 quote request with an encoded sender name,
+// a Cyrillic subject "Заявка <id>" and Message-ID <id@example.com>.
+func RawMessage(id string) string {
+	return fmt.Sprintf("From: =?UTF-8?B?0JjQstCw0L0=?= <ivan@example.com>\r\nSubject: Заявка %s\r\nDate: Sun, 08 Feb 2026 10:00:00 +0300\r\nMessage-ID: <%s@example.com>\r\n\r\nКабель 10 шт\r\n", id, id)
+}
+
+// OpenDB opens an empty database that is closed when the test ends.
+func OpenDB(t *testing.T) *storage.DB {
+	t.Helper()
+	db, err := storage.Open(filepath.Join(t.TempDir(), "app.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+	return db
+}
+
+// Fetch runs one FetchInbox and returns every message handed to the handler.
+func Fetch(c connectors.MailConnector, label string, max int) ([]internal.FetchedMailMessage, error) {
+	var out []internal.FetchedMailMessage
+	err := c.FetchInbox(context.Background(), label, max, func(msg internal.FetchedMailMessage) error {
+		out = append(out, msg)
+		return nil
+	})
+	return out, err
+}
+
+// MustFetch is Fetch failing the test on error.
+func MustFetch(t *testing.T, c connectors.MailConnector, label string, max int) []internal.FetchedMailMessage {
+	t.Helper()
+	out, err := Fetch(c, label, max)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
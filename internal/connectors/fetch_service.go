@@ -1,6 +1,9 @@
 package connectors
 
 import (
+	"context"
+
+	"elcom/internal"
 	"elcom/internal/storage"
 )
 
@@ -8,6 +11,7 @@ type FetchService struct {
 	db        *storage.DB
 	connector MailConnector
 	store     *MailStoreService
+	mailbox   string
 }
 
 type FetchResult struct {
@@ -15,27 +19,31 @@ type FetchResult struct {
 	Stored  int
 }
 
-func NewFetchService(db *storage.DB, rawMailDir string, connector MailConnector) *FetchService {
+// NewFetchService tags every stored email with mailbox; pass "" for fetches
+// that do not belong to a listener mailbox.
+func NewFetchService(db *storage.DB, rawMailDir, mailbox string, connector MailConnector) *FetchService {
 	return &FetchService{
 		db:        db,
 		connector: connector,
 		store:     NewMailStoreService(db, rawMailDir),
+		mailbox:   mailbox,
 	}
 }
 
-func (s *FetchService) FetchAndStore(label string, max int) (FetchResult, error) {
-	messages, err := s.connector.FetchInbox(label, max)
-	if err != nil {
-		return FetchResult{}, err
-	}
-
-	stored := 0
-	for _, msg := range messages {
+// FetchAndStore stores each message as the connector yields it. When the
+// fetch fails halfway the result still reports what was stored before the error.
+func (s *FetchService) FetchAndStore(ctx context.Context, label string, max int) (FetchResult, error) {
+	var result FetchResult
+	err := s.connector.FetchInbox(ctx, label, max, func(msg internal.FetchedMailMessage) error {
+		result.Fetched++
+		if msg.Mailbox == "" {
+			msg.Mailbox = s.mailbox
+		}
 		if _, err := s.store.Store(msg); err != nil {
-			return FetchResult{}, err
+			return err
 		}
-		stored++
-	}
-
-	return FetchResult{Fetched: len(messages), Stored: stored}, nil
+		result.Stored++
+		return nil
+	})
+	return result, err
 }
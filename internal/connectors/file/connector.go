@@ -0,0 +1,316 @@
+package file
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"elcom/internal"
+	"elcom/internal/config"
+	"elcom/internal/connectors"
+	"elcom/internal/storage"
+)
+
+// processedDir is created next to the scanned source when moving is enabled.
+const processedDir = "processed"
+
+// Connector reads mail exported to disk: a Maildir tree, an mbox file, a
+// single .eml file or a directory of .eml files.
+type Connector struct {
+	db           *storage.DB
+	root         string
+	moveIngested bool
+}
+
+func NewConnector(cfg config.Config, db *storage.DB) (*Connector, error) {
+	if err := cfg.Require("FILE_MAIL_ROOT", cfg.FileMailRoot); err != nil {
+		return nil, err
+	}
+	return &Connector{db: db, root: cfg.FileMailRoot, moveIngested: cfg.FileMailMoveProcessed}, nil
+}
+
+// source is one file on disk holding one or more messages.
+type source struct {
+	path string
+	// base is the directory the processed/ folder is created in.
+	base string
+	mbox bool
+}
+
+// sourcePos records how far a source was read. Messages counts the messages
+// handed over; Done means all of them were, at the recorded Size.
+type sourcePos struct {
+	Size     int64 `json:"size"`
+	Messages int   `json:"messages"`
+	Done     bool  `json:"done"`
+}
+
+// fileCursor maps source paths under one label to their read position.
+type fileCursor map[string]sourcePos
+
+// FetchInbox resolves label against the configured root ("" and INBOX mean
+// the root itself) and yields the messages found there in file-name order,
+// resuming where the previous run stopped. A source that changed size is read
+// again from the start, except an mbox that grew, which continues after the
+// messages already handed over.
+func (c *Connector) FetchInbox(ctx context.Context, label string, max int, handle connectors.MessageHandler) error {
+	path := c.resolve(label)
+	sources, err := collectSources(path)
+	if err != nil {
+		return err
+	}
+	cursor, err := c.loadCursor(path)
+	if err != nil {
+		return err
+	}
+
+	// Only sources still on disk keep their entry.
+	next := make(fileCursor, len(sources))
+	for _, src := range sources {
+		if pos, ok := cursor[src.path]; ok {
+			next[src.path] = pos
+		}
+	}
+	err = c.fetch(ctx, sources, next, max, handle)
+	if saveErr := c.saveCursor(path, next); err == nil {
+		err = saveErr
+	}
+	return err
+}
+
+// fetch updates cursor after every handled message, so a failure keeps the
+// progress made before it.
+func (c *Connector) fetch(ctx context.Context, sources []source, cursor fileCursor, max int, handle connectors.MessageHandler) error {
+	fetched := 0
+	for _, src := range sources {
+		if max > 0 && fetched >= max {
+			return nil
+		}
+		info, err := os.Stat(src.path)
+		if err != nil {
+			return err
+		}
+		pos := cursor[src.path]
+		if pos.Size != info.Size() && !(src.mbox && info.Size() > pos.Size) {
+			pos = sourcePos{}
+		}
+		if pos.Done && pos.Size == info.Size() {
+			continue
+		}
+
+		data, err := os.ReadFile(src.path)
+		if err != nil {
+			return err
+		}
+		raws := [][]byte{data}
+		if src.mbox {
+			raws = splitMbox(data)
+		}
+		pos = sourcePos{Size: info.Size(), Messages: min(pos.Messages, len(raws))}
+		for _, raw := range raws[pos.Messages:] {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if max > 0 && fetched >= max {
+				break
+			}
+			if err := handle(toMessage(raw, info.ModTime())); err != nil {
+				return err
+			}
+			fetched++
+			pos.Messages++
+			cursor[src.path] = pos
+		}
+		pos.Done = pos.Messages == len(raws)
+		cursor[src.path] = pos
+
+		// An mbox is only moved once every message in it was handed over.
+		if c.moveIngested && pos.Done {
+			if err := moveToProcessed(src); err != nil {
+				return err
+			}
+			delete(cursor, src.path)
+		}
+	}
+	return nil
+}
+
+func (c *Connector) resolve(label string) string {
+	label = strings.TrimSpace(label)
+	if label == "" || strings.EqualFold(label, "INBOX") {
+		return c.root
+	}
+	if filepath.IsAbs(label) {
+		return label
+	}
+	return filepath.Join(c.root, label)
+}
+
+func collectSources(path string) ([]source, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []source{fileSource(path, filepath.Dir(path))}, nil
+	}
+
+	if isMaildir(path) {
+		out := []source{}
+		for _, sub := range []string{"new", "cur"} {
+			entries, err := os.ReadDir(filepath.Join(path, sub))
+			if err != nil && !os.IsNotExist(err) {
+				return nil, err
+			}
+			for _, e := range entries {
+				if e.Type().IsRegular() && !strings.HasPrefix(e.Name(), ".") {
+					out = append(out, source{path: filepath.Join(path, sub, e.Name()), base: path})
+				}
+			}
+		}
+		return out, nil
+	}
+
+	out := []source{}
+	err = filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if p != path && d.Name() == processedDir {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		lower := strings.ToLower(d.Name())
+		if strings.HasSuffix(lower, ".eml") || strings.HasSuffix(lower, ".mbox") {
+			out = append(out, fileSource(p, path))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].path < out[j].path })
+	return out, nil
+}
+
+func fileSource(path, base string) source {
+	src := source{path: path, base: base}
+	if f, err := os.Open(path); err == nil {
+		head := make([]byte, 5)
+		n, _ := f.Read(head)
+		_ = f.Close()
+		src.mbox = string(head[:n]) == "From "
+	}
+	return src
+}
+
+func isMaildir(path string) bool {
+	for _, sub := range []string{"new", "cur"} {
+		if info, err := os.Stat(filepath.Join(path, sub)); err == nil && info.IsDir() {
+			return true
+		}
+	}
+	return false
+}
+
+// splitMbox splits an mboxo/mboxrd file on "From " postmark lines and
+// unescapes ">From " quoting in bodies.
+func splitMbox(data []byte) [][]byte {
+	data = bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n"))
+	out := [][]byte{}
+	var cur []byte
+	started := false
+	flush := func() {
+		if started {
+			out = append(out, bytes.TrimSuffix(cur, []byte("\n")))
+		}
+	}
+	for _, line := range bytes.SplitAfter(data, []byte("\n")) {
+		if bytes.HasPrefix(line, []byte("From ")) {
+			flush()
+			cur = nil
+			started = true
+			continue
+		}
+		if !started {
+			continue
+		}
+		if len(line) > 0 && line[0] == '>' && bytes.HasPrefix(bytes.TrimLeft(line, ">"), []byte("From ")) {
+			line = line[1:]
+		}
+		cur = append(cur, line...)
+	}
+	flush()
+	return out
+}
+
+func toMessage(raw []byte, modTime time.Time) internal.FetchedMailMessage {
+	headers, _ := connectors.ParseMessageHeaders(raw)
+
+	messageID := headers.MessageID
+	if messageID == "" {
+		sum := sha256.Sum256(raw)
+		messageID = "file-" + hex.EncodeToString(sum[:12])
+	}
+	received := modTime.UTC()
+	if !headers.Date.IsZero() {
+		received = headers.Date.UTC()
+	}
+
+	return internal.FetchedMailMessage{
+		Provider:   "file",
+		MessageID:  messageID,
+		Subject:    headers.Subject,
+		From:       headers.From,
+		ReceivedAt: received.Format(time.RFC3339),
+		Raw:        raw,
+	}
+}
+
+func moveToProcessed(src source) error {
+	rel, err := filepath.Rel(src.base, src.path)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		rel = filepath.Base(src.path)
+	}
+	dst := filepath.Join(src.base, processedDir, rel)
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+	return os.Rename(src.path, dst)
+}
+
+// cursorKey is the absolute label path, so relative and absolute labels
+// naming the same directory share one cursor.
+func cursorKey(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	return "file.cursor." + path
+}
+
+func (c *Connector) loadCursor(path string) (fileCursor, error) {
+	cursor := fileCursor{}
+	value, err := c.db.GetMetadata(cursorKey(path))
+	if err != nil || value == nil {
+		return cursor, err
+	}
+	if err := json.Unmarshal([]byte(*value), &cursor); err != nil {
+		return fileCursor{}, nil
+	}
+	return cursor, nil
+}
+
+func (c *Connector) saveCursor(path string, cursor fileCursor) error {
+	blob, _ := json.Marshal(cursor)
+	return c.db.SetMetadata(cursorKey(path), string(blob))
+}
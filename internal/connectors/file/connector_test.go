@@ -0,0 +1,172 @@
+package file
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"elcom/internal/config"
+	"elcom/internal/connectors"
+	"elcom/internal/connectors/conntest"
+	"elcom/internal/pipeline"
+	"elcom/internal/storage"
+)
+
+const quoteEML = "From: customer@example.com\r\nSubject: =?UTF-8?B?0JfQsNGP0LLQutCw?=\r\nDate: Sun, 08 Feb 2026 10:00:00 +0300\r\nMessage-ID: <%s@example.com>\r\nContent-Type: text/plain; charset=utf-8\r\n\r\nПросьба выставить КП:\r\nКабель ВВГнг 3x2.5 10 шт\r\nПровод ПВС 2x1.5 5 м\r\n"
+
+func writeEML(t *testing.T, path, id string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(fmt.Sprintf(quoteEML, id)), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newTestConnector(t *testing.T, root string, move bool) *Connector {
+	t.Helper()
+	return &Connector{db: conntest.OpenDB(t), root: root, moveIngested: move}
+}
+
+func TestFetchEMLDirectoryAndMove(t *testing.T) {
+	root := t.TempDir()
+	writeEML(t, filepath.Join(root, "a.eml"), "a")
+	writeEML(t, filepath.Join(root, "nested", "b.eml"), "b")
+
+	c := newTestConnector(t, root, true)
+	msgs := conntest.MustFetch(t, c, "INBOX", 0)
+	if len(msgs) != 2 {
+		t.Fatalf("len=%d", len(msgs))
+	}
+	if msgs[0].MessageID != "<a@example.com>" || msgs[0].Subject != "Заявка" || msgs[0].Provider != "file" {
+		t.Fatalf("msg=%+v", msgs[0])
+	}
+	if msgs[0].ReceivedAt != "2026-02-08T07:00:00Z" {
+		t.Fatalf("receivedAt=%s", msgs[0].ReceivedAt)
+	}
+	if _, err := os.Stat(filepath.Join(root, "processed", "nested", "b.eml")); err != nil {
+		t.Fatalf("not moved: %v", err)
+	}
+	if again := conntest.MustFetch(t, c, "", 0); len(again) != 0 {
+		t.Fatalf("processed files rescanned: %d", len(again))
+	}
+}
+
+func TestFetchMaildirAndMbox(t *testing.T) {
+	root := t.TempDir()
+	writeEML(t, filepath.Join(root, "box", "new", "1700000000.1.host"), "new")
+	writeEML(t, filepath.Join(root, "box", "cur", "1700000000.2.host:2,S"), "cur")
+	if err := os.MkdirAll(filepath.Join(root, "box", "tmp"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	c := newTestConnector(t, root, false)
+	if msgs := conntest.MustFetch(t, c, "box", 0); len(msgs) != 2 {
+		t.Fatalf("maildir len=%d", len(msgs))
+	}
+
+	mbox := "From customer@example.com Sun Feb  8 10:00:00 2026\n" +
+		"Message-ID: <m1@example.com>\nSubject: one\n\n>From the body\n\n" +
+		"From customer@example.com Sun Feb  8 10:01:00 2026\n" +
+		"Message-ID: <m2@example.com>\nSubject: two\n\nbody\n"
+	mboxPath := filepath.Join(root, "export.mbox")
+	if err := os.WriteFile(mboxPath, []byte(mbox), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	msgs := conntest.MustFetch(t, c, mboxPath, 0)
+	if len(msgs) != 2 || msgs[1].MessageID != "<m2@example.com>" {
+		t.Fatalf("mbox=%+v", msgs)
+	}
+	if string(msgs[0].Raw) != "Message-ID: <m1@example.com>\nSubject: one\n\nFrom the body\n" {
+		t.Fatalf("raw=%q", msgs[0].Raw)
+	}
+	if again := conntest.MustFetch(t, c, mboxPath, 0); len(again) != 0 {
+		t.Fatalf("mbox re-read: %d", len(again))
+	}
+}
+
+func TestFetchResumesPastMax(t *testing.T) {
+	root := t.TempDir()
+	for _, id := range []string{"a", "b", "c"} {
+		writeEML(t, filepath.Join(root, id+".eml"), id)
+	}
+	mbox := "From customer@example.com Sun Feb  8 10:00:00 2026\nMessage-ID: <m1@example.com>\n\none\n" +
+		"From customer@example.com Sun Feb  8 10:01:00 2026\nMessage-ID: <m2@example.com>\n\ntwo\n" +
+		"From customer@example.com Sun Feb  8 10:02:00 2026\nMessage-ID: <m3@example.com>\n\nthree\n"
+	mboxPath := filepath.Join(root, "z.mbox")
+	if err := os.WriteFile(mboxPath, []byte(mbox), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	c := newTestConnector(t, root, true)
+	var ids []string
+	for run := 0; run < 4; run++ {
+		for _, msg := range conntest.MustFetch(t, c, "INBOX", 2) {
+			ids = append(ids, msg.MessageID)
+		}
+		// The mbox stays in place until its last message was handed over.
+		_, err := os.Stat(mboxPath)
+		if moved := os.IsNotExist(err); moved != (run >= 2) {
+			t.Fatalf("run %d: mbox moved=%v", run, moved)
+		}
+	}
+	want := "[<a@example.com> <b@example.com> <c@example.com> <m1@example.com> <m2@example.com> <m3@example.com>]"
+	if got := fmt.Sprint(ids); got != want {
+		t.Fatalf("ids=%s", got)
+	}
+
+	// Without moving, messages appended to an mbox are picked up after the old ones.
+	c = newTestConnector(t, root, false)
+	mboxPath = filepath.Join(root, "processed", "z.mbox")
+	if msgs := conntest.MustFetch(t, c, mboxPath, 2); len(msgs) != 2 {
+		t.Fatalf("first=%d", len(msgs))
+	}
+	f, err := os.OpenFile(mboxPath, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = f.WriteString("From customer@example.com Sun Feb  8 10:03:00 2026\nMessage-ID: <m4@example.com>\n\nfour\n")
+	_ = f.Close()
+	msgs := conntest.MustFetch(t, c, mboxPath, 0)
+	if len(msgs) != 2 || msgs[0].MessageID != "<m3@example.com>" || msgs[1].MessageID != "<m4@example.com>" {
+		t.Fatalf("appended=%+v", msgs)
+	}
+}
+
+func TestFileSourceThroughPipeline(t *testing.T) {
+	tmp := t.TempDir()
+	root := filepath.Join(tmp, "inbox")
+	writeEML(t, filepath.Join(root, "quote.eml"), "quote")
+
+	db, err := storage.Open(filepath.Join(tmp, "app.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	cfg, _ := config.Load()
+	cfg.FileMailRoot = root
+	conn, err := NewConnector(cfg, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fetch := connectors.NewFetchService(db, filepath.Join(tmp, "raw"), "", conn)
+	res, err := fetch.FetchAndStore(context.Background(), "INBOX", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Stored != 1 {
+		t.Fatalf("stored=%d", res.Stored)
+	}
+
+	emails, lines, err := pipeline.NewProcessingService(db, cfg).ProcessPending(10, "file", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if emails != 1 || lines < 2 {
+		t.Fatalf("emails=%d lines=%d", emails, lines)
+	}
+}
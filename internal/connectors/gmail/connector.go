@@ -3,24 +3,49 @@ package gmail
 import (
 	"context"
 	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"strings"
+	"net/http"
 	"time"
 
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
 	"google.golang.org/api/gmail/v1"
+	"google.golang.org/api/googleapi"
 	"google.golang.org/api/option"
 
 	"elcom/internal"
 	"elcom/internal/config"
+	"elcom/internal/connectors"
+	"elcom/internal/storage"
 )
 
+// maxPageSize is the Gmail API upper bound for messages.list and history.list.
+const maxPageSize = 500
+
+var errEmptyRaw = errors.New("gmail message has no raw payload")
+
 type Connector struct {
 	service *gmail.Service
+	db      *storage.DB
+	account string
+
+	labelPrefix string
+	// outcomeLabels caches label IDs resolved by WriteOutcome.
+	outcomeLabels map[connectors.Outcome]string
 }
 
-func NewConnector(cfg config.Config) (*Connector, error) {
+// syncCursor is the per-label position persisted in the metadata table.
+// While Backfilling is set, PageToken points at the next messages.list page
+// and HistoryID is the mailbox history captured when the backfill started.
+type syncCursor struct {
+	HistoryID   uint64 `json:"historyId"`
+	PageToken   string `json:"pageToken,omitempty"`
+	Backfilling bool   `json:"backfilling,omitempty"`
+}
+
+func NewConnector(cfg config.Config, db *storage.DB) (*Connector, error) {
 	if err := cfg.Require("GMAIL_CLIENT_ID", cfg.GmailClientID); err != nil {
 		return nil, err
 	}
@@ -31,12 +56,17 @@ func NewConnector(cfg config.Config) (*Connector, error) {
 		return nil, err
 	}
 
+	// Write-back needs gmail.modify; the refresh token must have been issued for it.
+	scope := gmail.GmailReadonlyScope
+	if cfg.OutcomeWriteback {
+		scope = gmail.GmailModifyScope
+	}
 	oauthCfg := &oauth2.Config{
 		ClientID:     cfg.GmailClientID,
 		ClientSecret: cfg.GmailClientSecret,
 		Endpoint:     google.Endpoint,
 		RedirectURL:  cfg.GmailRedirectURI,
-		Scopes:       []string{gmail.GmailReadonlyScope},
+		Scopes:       []string{scope},
 	}
 
 	tokenSource := oauthCfg.TokenSource(context.Background(), &oauth2.Token{RefreshToken: cfg.GmailRefreshToken})
@@ -45,74 +75,307 @@ func NewConnector(cfg config.Config) (*Connector, error) {
 		return nil, err
 	}
 
-	return &Connector{service: svc}, nil
+	return &Connector{service: svc, db: db, labelPrefix: cfg.GmailOutcomeLabelPrefix}, nil
 }
 
-func (c *Connector) FetchInbox(label string, max int) ([]internal.FetchedMailMessage, error) {
-	listCall := c.service.Users.Messages.List("me").LabelIds(label).MaxResults(int64(max))
-	listResp, err := listCall.Do()
+// FetchInbox streams messages added to label since the previous run. The
+// first run pages through the whole label (max messages per run, resumed on
+// the next call); afterwards users.history.list yields only new messages.
+func (c *Connector) FetchInbox(ctx context.Context, label string, max int, handle connectors.MessageHandler) error {
+	cursor, err := c.loadCursor(ctx, label)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	if cursor.HistoryID == 0 || cursor.Backfilling {
+		return c.backfill(ctx, label, max, cursor, handle)
 	}
 
-	messages := listResp.Messages
-	out := make([]internal.FetchedMailMessage, 0, len(messages))
+	err = c.fetchHistory(ctx, label, cursor, handle)
+	if isNotFound(err) {
+		// startHistoryId is too old (history is kept for about a week): start over.
+		return c.backfill(ctx, label, max, syncCursor{}, handle)
+	}
+	return err
+}
 
-	for _, msgRef := range messages {
-		if msgRef.Id == "" {
-			continue
+// backfill saves the cursor after every completed page; a failure inside a
+// page replays that page next time, which the idempotent store absorbs.
+func (c *Connector) backfill(ctx context.Context, label string, max int, cursor syncCursor, handle connectors.MessageHandler) error {
+	if cursor.HistoryID == 0 {
+		profile, err := c.service.Users.GetProfile("me").Context(ctx).Do()
+		if err != nil {
+			return err
 		}
+		cursor = syncCursor{HistoryID: profile.HistoryId, Backfilling: true}
+		if err := c.saveCursor(ctx, label, cursor); err != nil {
+			return err
+		}
+	}
 
-		rawResp, err := c.service.Users.Messages.Get("me", msgRef.Id).Format("raw").Do()
-		if err != nil {
-			return nil, err
+	pageSize := int64(maxPageSize)
+	if max > 0 && max < maxPageSize {
+		pageSize = int64(max)
+	}
+
+	fetched := 0
+	for {
+		call := c.service.Users.Messages.List("me").LabelIds(label).MaxResults(pageSize).Context(ctx)
+		if cursor.PageToken != "" {
+			call = call.PageToken(cursor.PageToken)
 		}
-		metaResp, err := c.service.Users.Messages.Get("me", msgRef.Id).Format("metadata").MetadataHeaders("Subject", "From", "Date", "Message-ID").Do()
+		resp, err := call.Do()
 		if err != nil {
-			return nil, err
+			return err
 		}
 
-		if rawResp.Raw == "" {
-			continue
+		for _, ref := range resp.Messages {
+			if ref.Id == "" {
+				continue
+			}
+			msg, err := c.fetchMessage(ctx, ref.Id)
+			if isSkippable(err) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			if err := handle(msg); err != nil {
+				return err
+			}
+			fetched++
+		}
+
+		cursor.PageToken = resp.NextPageToken
+		if cursor.PageToken == "" {
+			cursor.Backfilling = false
 		}
+		if err := c.saveCursor(ctx, label, cursor); err != nil {
+			return err
+		}
+		if !cursor.Backfilling || (max > 0 && fetched >= max) {
+			return nil
+		}
+	}
+}
 
-		rawBytes, err := decodeBase64URL(rawResp.Raw)
+// fetchHistory drains every history page so the cursor never skips mail;
+// max only bounds the backfill. The cursor moves only after every added
+// message was handled, so a failed run replays the same history range.
+func (c *Connector) fetchHistory(ctx context.Context, label string, cursor syncCursor, handle connectors.MessageHandler) error {
+	ids := []string{}
+	seen := map[string]struct{}{}
+	latest := cursor.HistoryID
+	pageToken := ""
+	for {
+		call := c.service.Users.History.List("me").
+			StartHistoryId(cursor.HistoryID).
+			LabelId(label).
+			HistoryTypes("messageAdded").
+			MaxResults(maxPageSize).
+			Context(ctx)
+		if pageToken != "" {
+			call = call.PageToken(pageToken)
+		}
+		resp, err := call.Do()
 		if err != nil {
-			return nil, err
+			return err
 		}
 
-		headers := map[string]string{}
-		if metaResp.Payload != nil {
-			for _, h := range metaResp.Payload.Headers {
-				headers[strings.ToLower(h.Name)] = h.Value
+		for _, h := range resp.History {
+			for _, added := range h.MessagesAdded {
+				if added.Message == nil || added.Message.Id == "" {
+					continue
+				}
+				if _, ok := seen[added.Message.Id]; ok {
+					continue
+				}
+				seen[added.Message.Id] = struct{}{}
+				ids = append(ids, added.Message.Id)
 			}
 		}
+		if resp.HistoryId > latest {
+			latest = resp.HistoryId
+		}
+		pageToken = resp.NextPageToken
+		if pageToken == "" {
+			break
+		}
+	}
 
-		received := time.Now().UTC().Format(time.RFC3339)
-		if dateHeader := headers["date"]; dateHeader != "" {
-			if t, err := time.Parse(time.RFC1123Z, dateHeader); err == nil {
-				received = t.UTC().Format(time.RFC3339)
-			} else if t, err := mailDateFallback(dateHeader); err == nil {
-				received = t.UTC().Format(time.RFC3339)
-			}
+	for _, id := range ids {
+		msg, err := c.fetchMessage(ctx, id)
+		if isSkippable(err) {
+			continue
+		}
+		if err != nil {
+			return err
 		}
+		if err := handle(msg); err != nil {
+			return err
+		}
+	}
+
+	cursor.HistoryID = latest
+	return c.saveCursor(ctx, label, cursor)
+}
+
+// fetchMessage downloads one message in raw format; envelope fields come
+// from the RFC822 headers, so no separate metadata request is needed.
+func (c *Connector) fetchMessage(ctx context.Context, id string) (internal.FetchedMailMessage, error) {
+	resp, err := c.service.Users.Messages.Get("me", id).Format("raw").Context(ctx).Do()
+	if err != nil {
+		return internal.FetchedMailMessage{}, err
+	}
+	if resp.Raw == "" {
+		return internal.FetchedMailMessage{}, errEmptyRaw
+	}
+	rawBytes, err := decodeBase64URL(resp.Raw)
+	if err != nil {
+		return internal.FetchedMailMessage{}, err
+	}
+
+	headers, _ := connectors.ParseMessageHeaders(rawBytes)
 
-		messageID := headers["message-id"]
-		if messageID == "" {
-			messageID = msgRef.Id
+	received := time.Now().UTC()
+	if !headers.Date.IsZero() {
+		received = headers.Date.UTC()
+	} else if resp.InternalDate > 0 {
+		received = time.UnixMilli(resp.InternalDate).UTC()
+	}
+
+	messageID := headers.MessageID
+	if messageID == "" {
+		messageID = id
+	}
+
+	return internal.FetchedMailMessage{
+		Provider:   "gmail",
+		MessageID:  messageID,
+		Subject:    headers.Subject,
+		From:       headers.From,
+		ReceivedAt: received.Format(time.RFC3339),
+		Raw:        rawBytes,
+		SourceRef:  id,
+	}, nil
+}
+
+// outcomeLabelNames are appended to labelPrefix, e.g. "Elcom/Review".
+var outcomeLabelNames = map[connectors.Outcome]string{
+	connectors.OutcomeProcessed: "Processed",
+	connectors.OutcomeReview:    "Review",
+	connectors.OutcomeSkipped:   "Skipped",
+}
+
+// WriteOutcome puts the outcome label on the message and removes the other
+// outcome labels. Missing labels are created on first use.
+func (c *Connector) WriteOutcome(ctx context.Context, sourceRef string, outcome connectors.Outcome) error {
+	labels, err := c.resolveOutcomeLabels(ctx)
+	if err != nil {
+		return err
+	}
+	target, ok := labels[outcome]
+	if !ok {
+		return fmt.Errorf("unknown outcome: %s", outcome)
+	}
+	req := &gmail.ModifyMessageRequest{AddLabelIds: []string{target}}
+	for o, id := range labels {
+		if o != outcome {
+			req.RemoveLabelIds = append(req.RemoveLabelIds, id)
 		}
+	}
+	_, err = c.service.Users.Messages.Modify("me", sourceRef, req).Context(ctx).Do()
+	if isNotFound(err) {
+		return fmt.Errorf("%w: gmail message %s", connectors.ErrSourceGone, sourceRef)
+	}
+	return err
+}
+
+func (c *Connector) resolveOutcomeLabels(ctx context.Context) (map[connectors.Outcome]string, error) {
+	if c.outcomeLabels != nil {
+		return c.outcomeLabels, nil
+	}
+	prefix := c.labelPrefix
+	if prefix == "" {
+		prefix = "Elcom"
+	}
 
-		out = append(out, internal.FetchedMailMessage{
-			Provider:   "gmail",
-			MessageID:  messageID,
-			Subject:    headers["subject"],
-			From:       headers["from"],
-			ReceivedAt: received,
-			Raw:        rawBytes,
-		})
+	resp, err := c.service.Users.Labels.List("me").Context(ctx).Do()
+	if err != nil {
+		return nil, err
+	}
+	existing := map[string]string{}
+	for _, l := range resp.Labels {
+		existing[l.Name] = l.Id
 	}
 
-	return out, nil
+	resolved := map[connectors.Outcome]string{}
+	for outcome, suffix := range outcomeLabelNames {
+		name := prefix + "/" + suffix
+		if id, ok := existing[name]; ok {
+			resolved[outcome] = id
+			continue
+		}
+		created, err := c.service.Users.Labels.Create("me", &gmail.Label{
+			Name:                  name,
+			LabelListVisibility:   "labelShow",
+			MessageListVisibility: "show",
+		}).Context(ctx).Do()
+		if err != nil {
+			return nil, fmt.Errorf("create gmail label %s: %w", name, err)
+		}
+		resolved[outcome] = created.Id
+	}
+	c.outcomeLabels = resolved
+	return resolved, nil
+}
+
+// cursorKey includes the account address so several Gmail mailboxes can
+// share one database.
+func (c *Connector) cursorKey(ctx context.Context, label string) (string, error) {
+	if c.account == "" {
+		profile, err := c.service.Users.GetProfile("me").Context(ctx).Do()
+		if err != nil {
+			return "", err
+		}
+		c.account = profile.EmailAddress
+	}
+	return fmt.Sprintf("gmail.cursor.%s/%s", c.account, label), nil
+}
+
+func (c *Connector) loadCursor(ctx context.Context, label string) (syncCursor, error) {
+	var cursor syncCursor
+	key, err := c.cursorKey(ctx, label)
+	if err != nil {
+		return cursor, err
+	}
+	value, err := c.db.GetMetadata(key)
+	if err != nil || value == nil {
+		return cursor, err
+	}
+	if err := json.Unmarshal([]byte(*value), &cursor); err != nil {
+		return syncCursor{}, nil
+	}
+	return cursor, nil
+}
+
+func (c *Connector) saveCursor(ctx context.Context, label string, cursor syncCursor) error {
+	key, err := c.cursorKey(ctx, label)
+	if err != nil {
+		return err
+	}
+	blob, _ := json.Marshal(cursor)
+	return c.db.SetMetadata(key, string(blob))
+}
+
+// isSkippable reports per-message errors that must not abort the batch:
+// the message was deleted after being listed, or has no payload.
+func isSkippable(err error) bool {
+	return isNotFound(err) || errors.Is(err, errEmptyRaw)
+}
+
+func isNotFound(err error) bool {
+	var apiErr *googleapi.Error
+	return errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound
 }
 
 func decodeBase64URL(input string) ([]byte, error) {
@@ -126,13 +389,3 @@ func decodeBase64URL(input string) ([]byte, error) {
 	}
 	return nil, fmt.Errorf("decode gmail raw payload: %w", err)
 }
-
-func mailDateFallback(value string) (time.Time, error) {
-	layouts := []string{time.RFC1123Z, time.RFC1123, time.RFC822Z, time.RFC822, time.RFC850, time.ANSIC}
-	for _, layout := range layouts {
-		if parsed, err := time.Parse(layout, value); err == nil {
-			return parsed, nil
-		}
-	}
-	return time.Time{}, fmt.Errorf("unsupported date format")
-}
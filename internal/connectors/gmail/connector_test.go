@@ -0,0 +1,113 @@
+package gmail
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"google.golang.org/api/gmail/v1"
+	"google.golang.org/api/option"
+
+	"elcom/internal/connectors/conntest"
+	"elcom/internal/storage"
+)
+
+// rawMessage is the fixture as Gmail returns it for format=raw.
+func rawMessage(id string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(conntest.RawMessage(id)))
+}
+
+func TestFetchInboxBackfillThenHistory(t *testing.T) {
+	gets := 0
+	mux := http.NewServeMux()
+	writeJSON := func(w http.ResponseWriter, v any) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(v)
+	}
+	mux.HandleFunc("/gmail/v1/users/me/profile", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]any{"emailAddress": "sales@example.com", "historyId": "100"})
+	})
+	mux.HandleFunc("/gmail/v1/users/me/messages", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("labelIds") != "INBOX" {
+			t.Errorf("labelIds=%q", r.URL.Query().Get("labelIds"))
+		}
+		if r.URL.Query().Get("pageToken") == "p2" {
+			writeJSON(w, map[string]any{"messages": []map[string]string{{"id": "m3"}}})
+			return
+		}
+		writeJSON(w, map[string]any{"messages": []map[string]string{{"id": "m1"}, {"id": "m2"}}, "nextPageToken": "p2"})
+	})
+	mux.HandleFunc("/gmail/v1/users/me/messages/", func(w http.ResponseWriter, r *http.Request) {
+		gets++
+		if r.URL.Query().Get("format") != "raw" {
+			t.Errorf("format=%q", r.URL.Query().Get("format"))
+		}
+		id := strings.TrimPrefix(r.URL.Path, "/gmail/v1/users/me/messages/")
+		writeJSON(w, map[string]any{"id": id, "raw": rawMessage(id)})
+	})
+	mux.HandleFunc("/gmail/v1/users/me/history", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("startHistoryId") != "100" {
+			t.Errorf("startHistoryId=%q", r.URL.Query().Get("startHistoryId"))
+		}
+		writeJSON(w, map[string]any{
+			"historyId": "120",
+			"history":   []map[string]any{{"id": "110", "messagesAdded": []map[string]any{{"message": map[string]string{"id": "m4"}}}}},
+		})
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	svc, err := gmail.NewService(context.Background(), option.WithEndpoint(srv.URL+"/"), option.WithHTTPClient(srv.Client()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := storage.Open(filepath.Join(t.TempDir(), "app.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	conn := &Connector{service: svc, db: db}
+
+	first, err := conntest.Fetch(conn, "INBOX", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(first) != 2 || first[0].MessageID != "<m1@example.com>" || first[0].Subject != "Заявка m1" || !strings.HasPrefix(first[0].From, "Иван") {
+		t.Fatalf("first=%+v", first)
+	}
+	if first[0].ReceivedAt != "2026-02-08T07:00:00Z" {
+		t.Fatalf("receivedAt=%s", first[0].ReceivedAt)
+	}
+
+	second, err := conntest.Fetch(conn, "INBOX", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(second) != 1 || second[0].MessageID != "<m3@example.com>" {
+		t.Fatalf("second=%+v", second)
+	}
+
+	third, err := conntest.Fetch(conn, "INBOX", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(third) != 1 || third[0].MessageID != "<m4@example.com>" {
+		t.Fatalf("third=%+v", third)
+	}
+	if gets != 4 {
+		t.Fatalf("message gets=%d, want one per message", gets)
+	}
+
+	cursor, err := conn.loadCursor(context.Background(), "INBOX")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cursor.HistoryID != 120 || cursor.Backfilling {
+		t.Fatalf("cursor=%+v", cursor)
+	}
+}
@@ -0,0 +1,324 @@
+package graph
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2/clientcredentials"
+
+	"elcom/internal"
+	"elcom/internal/config"
+	"elcom/internal/connectors"
+	"elcom/internal/storage"
+)
+
+// Provider is the emails.provider value of Graph mail.
+const Provider = "graph"
+
+const (
+	// maxPageSize is sent as odata.maxpagesize; Graph caps delta pages itself.
+	maxPageSize = 100
+	// maxAttempts bounds retries of throttled (429) and unavailable (503) calls.
+	maxAttempts   = 4
+	maxRetryAfter = 30 * time.Second
+)
+
+// retryDelay is the linear backoff step when Graph sends no Retry-After.
+var retryDelay = time.Second
+
+var errNotFound = errors.New("graph message not found")
+
+// wellKnownFolders maps IMAP-style labels to Graph well-known folder names.
+var wellKnownFolders = map[string]string{
+	"INBOX":   "inbox",
+	"ARCHIVE": "archive",
+	"JUNK":    "junkemail",
+	"SENT":    "sentitems",
+}
+
+type Connector struct {
+	httpClient *http.Client
+	db         *storage.DB
+	baseURL    string
+	user       string
+}
+
+// deltaCursor is the per-folder position persisted in the metadata table.
+// NextLink resumes an unfinished round; DeltaLink starts the next one.
+type deltaCursor struct {
+	NextLink  string `json:"nextLink,omitempty"`
+	DeltaLink string `json:"deltaLink,omitempty"`
+}
+
+type deltaPage struct {
+	Value     []deltaMessage `json:"value"`
+	NextLink  string         `json:"@odata.nextLink"`
+	DeltaLink string         `json:"@odata.deltaLink"`
+}
+
+type deltaMessage struct {
+	ID               string          `json:"id"`
+	ReceivedDateTime string          `json:"receivedDateTime"`
+	Removed          json.RawMessage `json:"@removed"`
+}
+
+// apiError is the Graph error envelope plus the HTTP status.
+type apiError struct {
+	Status     int
+	Code       string `json:"code"`
+	Message    string `json:"message"`
+	retryAfter time.Duration
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("graph api status=%d code=%s: %s", e.Status, e.Code, e.Message)
+}
+
+// NewConnector authenticates with the OAuth2 client-credentials grant of an
+// Entra ID app that has the Mail.Read application permission.
+func NewConnector(cfg config.Config, db *storage.DB) (*Connector, error) {
+	if err := cfg.Require("GRAPH_CLIENT_ID", cfg.GraphClientID); err != nil {
+		return nil, err
+	}
+	if err := cfg.Require("GRAPH_CLIENT_SECRET", cfg.GraphClientSecret); err != nil {
+		return nil, err
+	}
+	if err := cfg.Require("GRAPH_USER", cfg.GraphUser); err != nil {
+		return nil, err
+	}
+	tokenURL := cfg.GraphTokenURL
+	if tokenURL == "" {
+		if err := cfg.Require("GRAPH_TENANT_ID", cfg.GraphTenantID); err != nil {
+			return nil, err
+		}
+		tokenURL = fmt.Sprintf("https://login.microsoftonline.com/%s/oauth2/v2.0/token", url.PathEscape(cfg.GraphTenantID))
+	}
+
+	oauthCfg := &clientcredentials.Config{
+		ClientID:     cfg.GraphClientID,
+		ClientSecret: cfg.GraphClientSecret,
+		TokenURL:     tokenURL,
+		Scopes:       []string{"https://graph.microsoft.com/.default"},
+	}
+	return &Connector{
+		httpClient: oauthCfg.Client(context.Background()),
+		db:         db,
+		baseURL:    strings.TrimRight(cfg.GraphBaseURL, "/"),
+		user:       cfg.GraphUser,
+	}, nil
+}
+
+// FetchInbox walks the folder's message delta. The first round lists the
+// whole folder (max messages per run, resumed on the next call); later rounds
+// start from the stored deltaLink and yield only new messages.
+func (c *Connector) FetchInbox(ctx context.Context, label string, max int, handle connectors.MessageHandler) error {
+	cursor, err := c.loadCursor(label)
+	if err != nil {
+		return err
+	}
+
+	err = c.fetchDelta(ctx, label, max, cursor, handle)
+	var apiErr *apiError
+	if errors.As(err, &apiErr) && apiErr.Status == http.StatusGone {
+		// The delta token expired or the sync state was reset: start over.
+		return c.fetchDelta(ctx, label, max, deltaCursor{}, handle)
+	}
+	return err
+}
+
+// fetchDelta saves the cursor after every completed page, so a failure
+// repeats at most the page it happened in.
+func (c *Connector) fetchDelta(ctx context.Context, label string, max int, cursor deltaCursor, handle connectors.MessageHandler) error {
+	link := cursor.NextLink
+	if link == "" {
+		link = cursor.DeltaLink
+	}
+	if link == "" {
+		link = c.initialDeltaURL(label)
+	}
+
+	fetched := 0
+	for {
+		var page deltaPage
+		if err := c.getJSON(ctx, link, &page); err != nil {
+			return err
+		}
+
+		for _, item := range page.Value {
+			if item.ID == "" || len(item.Removed) > 0 {
+				continue
+			}
+			msg, err := c.fetchMessage(ctx, item)
+			if errors.Is(err, errNotFound) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			if err := handle(msg); err != nil {
+				return err
+			}
+			fetched++
+		}
+
+		if page.NextLink != "" {
+			cursor = deltaCursor{NextLink: page.NextLink}
+		} else {
+			cursor = deltaCursor{DeltaLink: page.DeltaLink}
+		}
+		if err := c.saveCursor(label, cursor); err != nil {
+			return err
+		}
+		if page.NextLink == "" || (max > 0 && fetched >= max) {
+			return nil
+		}
+		link = page.NextLink
+	}
+}
+
+func (c *Connector) initialDeltaURL(label string) string {
+	folder, ok := wellKnownFolders[strings.ToUpper(label)]
+	if !ok {
+		// Anything else is taken as a folder id or well-known name as is.
+		folder = label
+	}
+	q := url.Values{}
+	q.Set("$select", "id,receivedDateTime")
+	return fmt.Sprintf("%s/users/%s/mailFolders/%s/messages/delta?%s", c.baseURL, url.PathEscape(c.user), url.PathEscape(folder), q.Encode())
+}
+
+// fetchMessage downloads the MIME content; envelope fields come from its
+// headers, with receivedDateTime as the fallback date.
+func (c *Connector) fetchMessage(ctx context.Context, item deltaMessage) (internal.FetchedMailMessage, error) {
+	link := fmt.Sprintf("%s/users/%s/messages/%s/$value", c.baseURL, url.PathEscape(c.user), url.PathEscape(item.ID))
+	resp, err := c.do(ctx, link)
+	var apiErr *apiError
+	if errors.As(err, &apiErr) && apiErr.Status == http.StatusNotFound {
+		return internal.FetchedMailMessage{}, errNotFound
+	}
+	if err != nil {
+		return internal.FetchedMailMessage{}, err
+	}
+	raw, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return internal.FetchedMailMessage{}, err
+	}
+
+	headers, _ := connectors.ParseMessageHeaders(raw)
+
+	received := time.Now().UTC()
+	if !headers.Date.IsZero() {
+		received = headers.Date.UTC()
+	} else if parsed, err := time.Parse(time.RFC3339, item.ReceivedDateTime); err == nil {
+		received = parsed.UTC()
+	}
+
+	messageID := headers.MessageID
+	if messageID == "" {
+		messageID = item.ID
+	}
+
+	return internal.FetchedMailMessage{
+		Provider:   Provider,
+		MessageID:  messageID,
+		Subject:    headers.Subject,
+		From:       headers.From,
+		ReceivedAt: received.Format(time.RFC3339),
+		Raw:        raw,
+		SourceRef:  item.ID,
+	}, nil
+}
+
+func (c *Connector) getJSON(ctx context.Context, link string, out any) error {
+	resp, err := c.do(ctx, link)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// do issues a GET and retries throttling as Graph asks via Retry-After.
+// Non-2xx responses become *apiError.
+func (c *Connector) do(ctx context.Context, link string) (*http.Response, error) {
+	for attempt := 1; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, link, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Prefer", fmt.Sprintf("odata.maxpagesize=%d", maxPageSize))
+
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+			return resp, nil
+		}
+
+		apiErr := readError(resp)
+		retryable := apiErr.Status == http.StatusTooManyRequests || apiErr.Status == http.StatusServiceUnavailable
+		if !retryable || attempt >= maxAttempts {
+			return nil, apiErr
+		}
+		wait := apiErr.retryAfter
+		if wait <= 0 {
+			wait = time.Duration(attempt) * retryDelay
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+func readError(resp *http.Response) *apiError {
+	defer resp.Body.Close()
+	var envelope struct {
+		Error apiError `json:"error"`
+	}
+	blob, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	_ = json.Unmarshal(blob, &envelope)
+
+	out := envelope.Error
+	out.Status = resp.StatusCode
+	if out.Message == "" {
+		out.Message = strings.TrimSpace(string(blob))
+	}
+	if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && secs > 0 {
+		out.retryAfter = min(time.Duration(secs)*time.Second, maxRetryAfter)
+	}
+	return &out
+}
+
+// cursorKey is per mailbox user and folder.
+func (c *Connector) cursorKey(label string) string {
+	return fmt.Sprintf("graph.cursor.%s/%s", c.user, label)
+}
+
+func (c *Connector) loadCursor(label string) (deltaCursor, error) {
+	var cursor deltaCursor
+	value, err := c.db.GetMetadata(c.cursorKey(label))
+	if err != nil || value == nil {
+		return cursor, err
+	}
+	if err := json.Unmarshal([]byte(*value), &cursor); err != nil {
+		return deltaCursor{}, nil
+	}
+	return cursor, nil
+}
+
+func (c *Connector) saveCursor(label string, cursor deltaCursor) error {
+	blob, _ := json.Marshal(cursor)
+	return c.db.SetMetadata(c.cursorKey(label), string(blob))
+}
@@ -0,0 +1,134 @@
+package graph
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"elcom/internal"
+	"elcom/internal/config"
+	"elcom/internal/connectors/conntest"
+)
+
+func newTestConnector(t *testing.T, fake *fakeGraph) *Connector {
+	t.Helper()
+	conn, err := NewConnector(config.Config{
+		GraphClientID:     fakeClientID,
+		GraphClientSecret: fakeClientSecret,
+		GraphUser:         fake.user,
+		GraphBaseURL:      fake.srv.URL + "/v1.0",
+		GraphTokenURL:     fake.srv.URL + "/token",
+	}, conntest.OpenDB(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+func fetchIDs(t *testing.T, c *Connector, label string, max int) []string {
+	t.Helper()
+	var ids []string
+	for _, msg := range conntest.MustFetch(t, c, label, max) {
+		ids = append(ids, msg.SourceRef)
+	}
+	return ids
+}
+
+func TestFetchInboxDeltaPagingThenIncremental(t *testing.T) {
+	fake := newFakeGraph(t, "sales@example.com")
+	for _, id := range []string{"m1", "m2", "m3"} {
+		fake.add("inbox", id, conntest.RawMessage(id))
+	}
+	conn := newTestConnector(t, fake)
+
+	var msgs []internal.FetchedMailMessage
+	err := conn.FetchInbox(context.Background(), "INBOX", 0, func(msg internal.FetchedMailMessage) error {
+		msgs = append(msgs, msg)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 3 || fake.deltaRequests != 2 {
+		t.Fatalf("msgs=%d deltaRequests=%d", len(msgs), fake.deltaRequests)
+	}
+	first := msgs[0]
+	if first.Provider != Provider || first.MessageID != "<m1@example.com>" || first.Subject != "Заявка m1" || first.ReceivedAt != "2026-02-08T07:00:00Z" {
+		t.Fatalf("first=%+v", first)
+	}
+
+	// Only mail added after the deltaLink is returned by the next round.
+	fake.add("inbox", "m4", conntest.RawMessage("m4"))
+	if ids := fetchIDs(t, conn, "INBOX", 0); fmt.Sprint(ids) != "[m4]" {
+		t.Fatalf("incremental=%v", ids)
+	}
+	if ids := fetchIDs(t, conn, "INBOX", 0); len(ids) != 0 {
+		t.Fatalf("repeat=%v", ids)
+	}
+	if fake.tokenRequests != 1 {
+		t.Fatalf("token requested %d times", fake.tokenRequests)
+	}
+}
+
+func TestFetchInboxMaxResumesFromNextLink(t *testing.T) {
+	fake := newFakeGraph(t, "sales@example.com")
+	for _, id := range []string{"m1", "m2", "m3"} {
+		fake.add("inbox", id, conntest.RawMessage(id))
+	}
+	conn := newTestConnector(t, fake)
+
+	if ids := fetchIDs(t, conn, "INBOX", 2); fmt.Sprint(ids) != "[m1 m2]" {
+		t.Fatalf("first=%v", ids)
+	}
+	if ids := fetchIDs(t, conn, "INBOX", 2); fmt.Sprint(ids) != "[m3]" {
+		t.Fatalf("second=%v", ids)
+	}
+}
+
+func TestFetchInboxSkipsRemovedAndResyncsExpiredDelta(t *testing.T) {
+	fake := newFakeGraph(t, "sales@example.com")
+	for _, id := range []string{"m1", "m2", "m3"} {
+		fake.add("inbox", id, conntest.RawMessage(id))
+	}
+	fake.delete("m2")
+	conn := newTestConnector(t, fake)
+
+	if ids := fetchIDs(t, conn, "INBOX", 0); fmt.Sprint(ids) != "[m1 m3]" {
+		t.Fatalf("first=%v", ids)
+	}
+
+	// 410 Gone on the stored deltaLink: the folder is listed again.
+	fake.expireDeltaTokens()
+	if ids := fetchIDs(t, conn, "INBOX", 0); fmt.Sprint(ids) != "[m1 m3]" {
+		t.Fatalf("resync=%v", ids)
+	}
+}
+
+func TestFetchInboxRetriesUnavailable(t *testing.T) {
+	retryDelay = time.Millisecond
+	t.Cleanup(func() { retryDelay = time.Second })
+
+	fake := newFakeGraph(t, "sales@example.com")
+	fake.add("inbox", "m1", conntest.RawMessage("m1"))
+	fake.unavailable = 2
+	conn := newTestConnector(t, fake)
+
+	if ids := fetchIDs(t, conn, "INBOX", 0); fmt.Sprint(ids) != "[m1]" {
+		t.Fatalf("ids=%v", ids)
+	}
+}
+
+func TestFetchInboxCancelled(t *testing.T) {
+	fake := newFakeGraph(t, "sales@example.com")
+	fake.add("inbox", "m1", conntest.RawMessage("m1"))
+	conn := newTestConnector(t, fake)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := conn.FetchInbox(ctx, "INBOX", 0, func(internal.FetchedMailMessage) error { return nil })
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("err=%v", err)
+	}
+}
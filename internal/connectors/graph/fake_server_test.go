@@ -0,0 +1,224 @@
+package graph
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const (
+	fakeClientID     = "client-id"
+	fakeClientSecret = "client-secret"
+	fakeToken        = "fake-access-token"
+)
+
+type fakeMessage struct {
+	id      string
+	raw     string
+	deleted bool
+}
+
+// fakeGraph is an in-memory stand-in for the Graph mail endpoints used by
+// the connector: the client-credentials token endpoint, mailFolders/{f}/
+// messages/delta with nextLink/deltaLink paging, and messages/{id}/$value.
+// Delta state is encoded in the links: $skiptoken is the next index of the
+// current round, $deltatoken the index where the next round starts.
+type fakeGraph struct {
+	t   *testing.T
+	srv *httptest.Server
+
+	mu       sync.Mutex
+	user     string
+	folders  map[string][]*fakeMessage
+	pageSize int
+	// generation invalidates every issued delta token when bumped.
+	generation int
+	// unavailable answers that many API calls with 503 before serving.
+	unavailable int
+
+	tokenRequests int
+	deltaRequests int
+}
+
+func newFakeGraph(t *testing.T, user string) *fakeGraph {
+	f := &fakeGraph{t: t, user: user, folders: map[string][]*fakeMessage{}, pageSize: 2}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", f.handleToken)
+	mux.HandleFunc("/v1.0/", f.handleAPI)
+	f.srv = httptest.NewServer(mux)
+	t.Cleanup(f.srv.Close)
+	return f
+}
+
+func (f *fakeGraph) add(folder, id, raw string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.folders[folder] = append(f.folders[folder], &fakeMessage{id: id, raw: raw})
+}
+
+func (f *fakeGraph) delete(id string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, msgs := range f.folders {
+		for _, m := range msgs {
+			if m.id == id {
+				m.deleted = true
+			}
+		}
+	}
+}
+
+func (f *fakeGraph) expireDeltaTokens() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.generation++
+}
+
+func (f *fakeGraph) handleToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	id, secret, ok := r.BasicAuth()
+	if !ok {
+		id, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	if r.PostForm.Get("grant_type") != "client_credentials" || id != fakeClientID || secret != fakeClientSecret {
+		writeFakeError(w, http.StatusUnauthorized, "invalid_client", "bad client credentials")
+		return
+	}
+	if scope := r.PostForm.Get("scope"); scope != "https://graph.microsoft.com/.default" {
+		f.t.Errorf("scope=%q", scope)
+	}
+	f.mu.Lock()
+	f.tokenRequests++
+	f.mu.Unlock()
+	writeFakeJSON(w, map[string]any{"access_token": fakeToken, "token_type": "Bearer", "expires_in": 3600})
+}
+
+func (f *fakeGraph) handleAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "Bearer "+fakeToken {
+		writeFakeError(w, http.StatusUnauthorized, "InvalidAuthenticationToken", "missing token")
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.unavailable > 0 {
+		f.unavailable--
+		writeFakeError(w, http.StatusServiceUnavailable, "serviceNotAvailable", "try later")
+		return
+	}
+
+	userPrefix := "/v1.0/users/" + f.user + "/"
+	if !strings.HasPrefix(r.URL.Path, userPrefix) {
+		writeFakeError(w, http.StatusNotFound, "ErrorInvalidUser", "unknown user")
+		return
+	}
+	rest := strings.TrimPrefix(r.URL.Path, userPrefix)
+
+	switch {
+	case strings.HasPrefix(rest, "mailFolders/") && strings.HasSuffix(rest, "/messages/delta"):
+		folder := strings.TrimSuffix(strings.TrimPrefix(rest, "mailFolders/"), "/messages/delta")
+		f.serveDelta(w, r, folder)
+	case strings.HasPrefix(rest, "messages/") && strings.HasSuffix(rest, "/$value"):
+		id := strings.TrimSuffix(strings.TrimPrefix(rest, "messages/"), "/$value")
+		f.serveValue(w, id)
+	default:
+		writeFakeError(w, http.StatusNotFound, "ResourceNotFound", rest)
+	}
+}
+
+func (f *fakeGraph) serveDelta(w http.ResponseWriter, r *http.Request, folder string) {
+	f.deltaRequests++
+	msgs, ok := f.folders[folder]
+	if !ok {
+		writeFakeError(w, http.StatusNotFound, "ErrorInvalidIdMalformed", "unknown folder "+folder)
+		return
+	}
+
+	q := r.URL.Query()
+	start := 0
+	for _, key := range []string{"$skiptoken", "$deltatoken"} {
+		token := q.Get(key)
+		if token == "" {
+			continue
+		}
+		gen, pos, ok := parseFakeToken(token)
+		if !ok || gen != f.generation {
+			writeFakeError(w, http.StatusGone, "SyncStateNotFound", "the sync state generation is old")
+			return
+		}
+		start = pos
+	}
+
+	pageSize := f.pageSize
+	if pref := r.Header.Get("Prefer"); strings.HasPrefix(pref, "odata.maxpagesize=") {
+		if n, err := strconv.Atoi(strings.TrimPrefix(pref, "odata.maxpagesize=")); err == nil && n < pageSize {
+			pageSize = n
+		}
+	}
+	end := min(start+pageSize, len(msgs))
+
+	value := []map[string]any{}
+	for _, m := range msgs[start:end] {
+		if m.deleted {
+			value = append(value, map[string]any{"id": m.id, "@removed": map[string]string{"reason": "deleted"}})
+			continue
+		}
+		value = append(value, map[string]any{"id": m.id, "receivedDateTime": "2026-02-08T07:00:00Z"})
+	}
+
+	link := func(key string, pos int) string {
+		v := url.Values{}
+		v.Set(key, fmt.Sprintf("%d.%d", f.generation, pos))
+		return fmt.Sprintf("%s/v1.0/users/%s/mailFolders/%s/messages/delta?%s", f.srv.URL, f.user, folder, v.Encode())
+	}
+	page := map[string]any{"value": value}
+	if end < len(msgs) {
+		page["@odata.nextLink"] = link("$skiptoken", end)
+	} else {
+		page["@odata.deltaLink"] = link("$deltatoken", end)
+	}
+	writeFakeJSON(w, page)
+}
+
+func (f *fakeGraph) serveValue(w http.ResponseWriter, id string) {
+	for _, msgs := range f.folders {
+		for _, m := range msgs {
+			if m.id == id && !m.deleted {
+				w.Header().Set("Content-Type", "message/rfc822")
+				_, _ = w.Write([]byte(m.raw))
+				return
+			}
+		}
+	}
+	writeFakeError(w, http.StatusNotFound, "ErrorItemNotFound", "The specified object was not found in the store.")
+}
+
+func parseFakeToken(token string) (int, int, bool) {
+	genPart, posPart, ok := strings.Cut(token, ".")
+	if !ok {
+		return 0, 0, false
+	}
+	gen, err1 := strconv.Atoi(genPart)
+	pos, err2 := strconv.Atoi(posPart)
+	return gen, pos, err1 == nil && err2 == nil
+}
+
+func writeFakeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeFakeError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]any{"error": map[string]string{"code": code, "message": message}})
+}
@@ -0,0 +1,56 @@
+package connectors
+
+import (
+	"bytes"
+	"fmt"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/jhillyerd/enmime"
+)
+
+// MessageHeaders is the envelope summary connectors derive from raw RFC822
+// when the source has no structured metadata of its own.
+type MessageHeaders struct {
+	MessageID string
+	Subject   string
+	From      string
+	Date      time.Time
+}
+
+// ParseMessageHeaders reads only the header block of raw and decodes
+// RFC 2047 words in Subject and From. A zero Date means it was missing or
+// unparseable.
+func ParseMessageHeaders(raw []byte) (MessageHeaders, error) {
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return MessageHeaders{}, err
+	}
+
+	out := MessageHeaders{
+		MessageID: strings.TrimSpace(msg.Header.Get("Message-ID")),
+		Subject:   enmime.DecodeRFC2047(msg.Header.Get("Subject")),
+		From:      enmime.DecodeRFC2047(msg.Header.Get("From")),
+	}
+	if date := strings.TrimSpace(msg.Header.Get("Date")); date != "" {
+		if parsed, err := ParseMailDate(date); err == nil {
+			out.Date = parsed
+		}
+	}
+	return out, nil
+}
+
+// ParseMailDate accepts RFC 5322 dates plus the older layouts still seen in the wild.
+func ParseMailDate(value string) (time.Time, error) {
+	if parsed, err := mail.ParseDate(value); err == nil {
+		return parsed, nil
+	}
+	layouts := []string{time.RFC1123Z, time.RFC1123, time.RFC822Z, time.RFC822, time.RFC850, time.ANSIC}
+	for _, layout := range layouts {
+		if parsed, err := time.Parse(layout, value); err == nil {
+			return parsed, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unsupported date format")
+}
@@ -1,9 +1,14 @@
 package imap
 
 import (
+	"context"
 	"crypto/tls"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -12,18 +17,49 @@ import (
 
 	"elcom/internal"
 	"elcom/internal/config"
+	"elcom/internal/connectors"
+	"elcom/internal/storage"
+)
+
+// ErrIdleUnsupported is returned by Watch when the server does not advertise IDLE.
+var ErrIdleUnsupported = errors.New("imap server does not support IDLE")
+
+const (
+	// idleRestartInterval re-issues IDLE well before the 29-minute limit of RFC 2177.
+	idleRestartInterval = 25 * time.Minute
+	// fetchChunkSize bounds how many raw messages are held in memory at once.
+	fetchChunkSize = 20
 )
 
 type Connector struct {
+	db       *storage.DB
 	host     string
 	port     int
 	secure   bool
 	user     string
 	password string
 	markSeen bool
+
+	// outcomeFolders maps an outcome to the folder WriteOutcome moves the
+	// message to; outcomes without a folder only get a keyword.
+	outcomeFolders map[connectors.Outcome]string
+	created        map[string]bool
+
+	// session is the long-lived client owned by Watch; nil in one-shot mode.
+	session *imapclient.Client
+	// outcomeSession is dialled by the first WriteOutcome that cannot use
+	// session and kept until EndOutcomes.
+	outcomeSession *imapclient.Client
 }
 
-func NewConnector(cfg config.Config) (*Connector, error) {
+// uidCursor is the per-mailbox position persisted in the metadata table.
+// LastUID is only meaningful while UIDValidity matches the server.
+type uidCursor struct {
+	UIDValidity uint32 `json:"uidValidity"`
+	LastUID     uint32 `json:"lastUid"`
+}
+
+func NewConnector(cfg config.Config, db *storage.DB) (*Connector, error) {
 	if err := cfg.Require("IMAP_HOST", cfg.IMAPHost); err != nil {
 		return nil, err
 	}
@@ -35,64 +71,146 @@ func NewConnector(cfg config.Config) (*Connector, error) {
 	}
 
 	return &Connector{
+		db:       db,
 		host:     cfg.IMAPHost,
 		port:     cfg.IMAPPort,
 		secure:   cfg.IMAPSecure,
 		user:     cfg.IMAPUser,
 		password: cfg.IMAPPassword,
 		markSeen: cfg.IMAPMarkSeen,
+		outcomeFolders: map[connectors.Outcome]string{
+			connectors.OutcomeProcessed: cfg.IMAPOutcomeFolderProcessed,
+			connectors.OutcomeReview:    cfg.IMAPOutcomeFolderReview,
+			connectors.OutcomeSkipped:   cfg.IMAPOutcomeFolderSkipped,
+		},
+		created: map[string]bool{},
 	}, nil
 }
 
-func (c *Connector) FetchInbox(label string, max int) ([]internal.FetchedMailMessage, error) {
-	addr := fmt.Sprintf("%s:%d", c.host, c.port)
-	var client *imapclient.Client
-	var err error
-	if c.secure {
-		client, err = imapclient.DialTLS(addr, &tls.Config{ServerName: c.host})
-	} else {
-		client, err = imapclient.Dial(addr)
-	}
-	if err != nil {
-		return nil, err
+// FetchInbox streams messages with UID above the stored cursor, oldest first,
+// in chunks of fetchChunkSize. The cursor advances after each message the
+// handler accepts, so an interrupted run resumes where it stopped.
+func (c *Connector) FetchInbox(ctx context.Context, label string, max int, handle connectors.MessageHandler) error {
+	client := c.session
+	if client == nil {
+		var err error
+		client, err = c.dial()
+		if err != nil {
+			return err
+		}
+		defer client.Logout()
 	}
-	defer client.Logout()
+	// go-imap has no context support; dropping the connection unblocks any
+	// command in flight.
+	stop := context.AfterFunc(ctx, func() { _ = client.Terminate() })
+	defer stop()
 
-	if err := client.Login(c.user, c.password); err != nil {
-		return nil, err
+	status, err := client.Select(label, false)
+	if err != nil {
+		return connectors.CtxErr(ctx, err)
 	}
 
-	_, err = client.Select(label, false)
+	cursor, err := c.loadCursor(label)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	if cursor.UIDValidity != status.UidValidity {
+		// Mailbox was recreated or renumbered: previously seen UIDs mean nothing now.
+		cursor = uidCursor{UIDValidity: status.UidValidity}
 	}
 
+	// "last+1:*" always matches the highest UID even when it is <= last,
+	// so the result is filtered again below.
 	criteria := imap.NewSearchCriteria()
-	criteria.WithoutFlags = []string{imap.SeenFlag}
-	ids, err := client.Search(criteria)
+	criteria.Uid = new(imap.SeqSet)
+	criteria.Uid.AddRange(cursor.LastUID+1, 0)
+	found, err := client.UidSearch(criteria)
 	if err != nil {
-		return nil, err
+		return connectors.CtxErr(ctx, err)
+	}
+
+	uids := make([]uint32, 0, len(found))
+	for _, uid := range found {
+		if uid > cursor.LastUID {
+			uids = append(uids, uid)
+		}
 	}
-	if len(ids) == 0 {
-		return nil, nil
+	if len(uids) == 0 {
+		return c.saveCursor(label, cursor)
 	}
 
-	if len(ids) > max {
-		ids = ids[len(ids)-max:]
+	// Oldest first so the cursor never jumps over messages left for the next run.
+	sort.Slice(uids, func(i, j int) bool { return uids[i] < uids[j] })
+	if max > 0 && len(uids) > max {
+		uids = uids[:max]
 	}
 
+	for len(uids) > 0 {
+		n := fetchChunkSize
+		if n > len(uids) {
+			n = len(uids)
+		}
+		chunk := uids[:n]
+		uids = uids[n:]
+
+		batch, err := c.fetchChunk(client, label, chunk, cursor.UIDValidity)
+		if err != nil {
+			return connectors.CtxErr(ctx, err)
+		}
+
+		handled := new(imap.SeqSet)
+		for _, f := range batch {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if err := handle(f.msg); err != nil {
+				return err
+			}
+			cursor.LastUID = f.uid
+			if err := c.saveCursor(label, cursor); err != nil {
+				return err
+			}
+			handled.AddNum(f.uid)
+		}
+		// UIDs in the chunk that vanished before the fetch are skipped too.
+		cursor.LastUID = chunk[len(chunk)-1]
+		if err := c.saveCursor(label, cursor); err != nil {
+			return err
+		}
+
+		if c.markSeen && !handled.Empty() {
+			item := imap.FormatFlagsOp(imap.AddFlags, true)
+			flags := []interface{}{imap.SeenFlag}
+			if err := client.UidStore(handled, item, flags, nil); err != nil {
+				return connectors.CtxErr(ctx, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+type fetchedMessage struct {
+	uid uint32
+	msg internal.FetchedMailMessage
+}
+
+// fetchChunk downloads a bounded set of UIDs and returns them sorted by UID.
+func (c *Connector) fetchChunk(client *imapclient.Client, label string, uids []uint32, uidValidity uint32) ([]fetchedMessage, error) {
 	seqset := new(imap.SeqSet)
-	seqset.AddNum(ids...)
+	seqset.AddNum(uids...)
 
-	section := &imap.BodySectionName{}
+	section := &imap.BodySectionName{Peek: true}
 	items := []imap.FetchItem{imap.FetchEnvelope, imap.FetchInternalDate, imap.FetchUid, section.FetchItem()}
-	messages := make(chan *imap.Message, len(ids))
+	messages := make(chan *imap.Message, len(uids))
 	fetchDone := make(chan error, 1)
-	go func() { fetchDone <- client.Fetch(seqset, items, messages) }()
+	go func() { fetchDone <- client.UidFetch(seqset, items, messages) }()
 
-	out := make([]internal.FetchedMailMessage, 0, len(ids))
+	batch := make([]fetchedMessage, 0, len(uids))
+	var readErr error
 	for msg := range messages {
-		if msg == nil {
+		// Keep draining after an error so the fetch goroutine can finish.
+		if msg == nil || readErr != nil {
 			continue
 		}
 		body := msg.GetBody(section)
@@ -101,7 +219,8 @@ func (c *Connector) FetchInbox(label string, max int) ([]internal.FetchedMailMes
 		}
 		raw, err := io.ReadAll(body)
 		if err != nil {
-			return nil, err
+			readErr = err
+			continue
 		}
 
 		messageID := ""
@@ -113,7 +232,7 @@ func (c *Connector) FetchInbox(label string, max int) ([]internal.FetchedMailMes
 			from = formatAddresses(msg.Envelope.From)
 		}
 		if messageID == "" {
-			messageID = fmt.Sprintf("imap-%d", msg.Uid)
+			messageID = fmt.Sprintf("imap-%d-%d", uidValidity, msg.Uid)
 		}
 
 		received := time.Now().UTC().Format(time.RFC3339)
@@ -121,31 +240,296 @@ func (c *Connector) FetchInbox(label string, max int) ([]internal.FetchedMailMes
 			received = msg.InternalDate.UTC().Format(time.RFC3339)
 		}
 
-		out = append(out, internal.FetchedMailMessage{
+		batch = append(batch, fetchedMessage{uid: msg.Uid, msg: internal.FetchedMailMessage{
 			Provider:   "imap",
 			MessageID:  messageID,
 			Subject:    subject,
 			From:       from,
 			ReceivedAt: received,
 			Raw:        raw,
-		})
+			SourceRef:  formatSourceRef(label, uidValidity, msg.Uid),
+		}})
+	}
 
-		if c.markSeen {
-			single := new(imap.SeqSet)
-			single.AddNum(msg.SeqNum)
-			item := imap.FormatFlagsOp(imap.AddFlags, true)
-			flags := []interface{}{imap.SeenFlag}
-			if err := client.Store(single, item, flags, nil); err != nil {
-				return nil, err
+	if err := <-fetchDone; err != nil {
+		return nil, err
+	}
+	if readErr != nil {
+		return nil, readErr
+	}
+
+	sort.Slice(batch, func(i, j int) bool { return batch[i].uid < batch[j].uid })
+	return batch, nil
+}
+
+// Watch keeps one authenticated session open on label and blocks in IDLE.
+// onChange runs once after connecting and again each time the server reports
+// EXISTS; FetchInbox calls made from onChange reuse the session. Watch returns
+// nil when ctx is done and an error when the connection is lost.
+func (c *Connector) Watch(ctx context.Context, label string, onChange func()) error {
+	client, err := c.dial()
+	if err != nil {
+		return err
+	}
+	defer client.Logout()
+
+	if ok, err := client.Support("IDLE"); err != nil {
+		return err
+	} else if !ok {
+		return ErrIdleUnsupported
+	}
+	if _, err := client.Select(label, false); err != nil {
+		return err
+	}
+
+	sessionCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// The client blocks if Updates is not drained, so updates are collapsed
+	// into a single pending wake-up.
+	updates := make(chan imapclient.Update, 32)
+	wake := make(chan struct{}, 1)
+	client.Updates = updates
+	go func() {
+		for {
+			select {
+			case <-sessionCtx.Done():
+				return
+			case update := <-updates:
+				if _, ok := update.(*imapclient.MailboxUpdate); !ok {
+					continue
+				}
+				select {
+				case wake <- struct{}{}:
+				default:
+				}
 			}
 		}
+	}()
+
+	c.session = client
+	defer func() { c.session = nil }()
+
+	for {
+		// Anything reported before this point is covered by the fetch below.
+		select {
+		case <-wake:
+		default:
+		}
+		onChange()
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		stop := make(chan struct{})
+		done := make(chan error, 1)
+		go func() { done <- client.Idle(stop, &imapclient.IdleOptions{LogoutTimeout: idleRestartInterval}) }()
+
+		select {
+		case <-ctx.Done():
+			close(stop)
+			<-done
+			return nil
+		case <-wake:
+			close(stop)
+			if err := <-done; err != nil {
+				return err
+			}
+		case <-client.LoggedOut():
+			// Wait for Idle to return so the deferred Logout does not race it.
+			close(stop)
+			<-done
+			return errors.New("imap connection closed while idling")
+		case err := <-done:
+			close(stop)
+			if err == nil {
+				err = errors.New("imap idle ended unexpectedly")
+			}
+			return err
+		}
 	}
+}
 
-	if err := <-fetchDone; err != nil {
+// outcomeKeywords are the IMAP keywords set by WriteOutcome; setting one
+// clears the others.
+var outcomeKeywords = map[connectors.Outcome]string{
+	connectors.OutcomeProcessed: "$ElcomProcessed",
+	connectors.OutcomeReview:    "$ElcomReview",
+	connectors.OutcomeSkipped:   "$ElcomSkipped",
+}
+
+// WriteOutcome tags the message with the outcome keyword and, when a folder
+// is configured for the outcome, moves it there.
+func (c *Connector) WriteOutcome(ctx context.Context, sourceRef string, outcome connectors.Outcome) error {
+	mailbox, uidValidity, uid, err := parseSourceRef(sourceRef)
+	if err != nil {
+		return err
+	}
+	keyword, ok := outcomeKeywords[outcome]
+	if !ok {
+		return fmt.Errorf("unknown outcome: %s", outcome)
+	}
+
+	client, err := c.outcomeClient(mailbox)
+	if err != nil {
+		return err
+	}
+	stop := context.AfterFunc(ctx, func() { _ = client.Terminate() })
+	defer stop()
+	// A broken connection is dropped so the next call dials again.
+	defer func() {
+		if client == c.outcomeSession && client.State() == imap.LogoutState {
+			c.outcomeSession = nil
+		}
+	}()
+
+	if client.Mailbox().UidValidity != uidValidity {
+		return fmt.Errorf("%w: %s UIDVALIDITY changed", connectors.ErrSourceGone, mailbox)
+	}
+
+	seqset := new(imap.SeqSet)
+	seqset.AddNum(uid)
+	others := make([]interface{}, 0, len(outcomeKeywords)-1)
+	for o, k := range outcomeKeywords {
+		if o != outcome {
+			others = append(others, k)
+		}
+	}
+	if err := client.UidStore(seqset, imap.FormatFlagsOp(imap.RemoveFlags, true), others, nil); err != nil {
+		return connectors.CtxErr(ctx, err)
+	}
+	if err := client.UidStore(seqset, imap.FormatFlagsOp(imap.AddFlags, true), []interface{}{keyword}, nil); err != nil {
+		return connectors.CtxErr(ctx, err)
+	}
+
+	folder := c.outcomeFolders[outcome]
+	if folder == "" || folder == mailbox {
+		return nil
+	}
+	if !c.created[folder] {
+		// Fails harmlessly when the folder already exists.
+		_ = client.Create(folder)
+		c.created[folder] = true
+	}
+	if err := client.UidMove(seqset, folder); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		// Some servers advertise MOVE but refuse it; go-imap only falls back
+		// when the capability is missing.
+		return connectors.CtxErr(ctx, copyAndExpunge(client, seqset, folder))
+	}
+	return nil
+}
+
+// outcomeClient returns a client with mailbox selected. Inside a Watch
+// callback that is the watch session; otherwise one session is dialled and
+// reused until EndOutcomes, so a write-back pass logs in once.
+func (c *Connector) outcomeClient(mailbox string) (*imapclient.Client, error) {
+	if c.session != nil && c.session.Mailbox() != nil && c.session.Mailbox().Name == mailbox {
+		return c.session, nil
+	}
+	if c.outcomeSession == nil {
+		client, err := c.dial()
+		if err != nil {
+			return nil, err
+		}
+		c.outcomeSession = client
+	}
+	client := c.outcomeSession
+	if client.Mailbox() == nil || client.Mailbox().Name != mailbox {
+		if _, err := client.Select(mailbox, false); err != nil {
+			if client.State() == imap.LogoutState {
+				c.outcomeSession = nil
+			}
+			return nil, err
+		}
+	}
+	return client, nil
+}
+
+// EndOutcomes logs out the session kept by WriteOutcome.
+func (c *Connector) EndOutcomes() {
+	if c.outcomeSession != nil {
+		_ = c.outcomeSession.Logout()
+		c.outcomeSession = nil
+	}
+}
+
+func copyAndExpunge(client *imapclient.Client, seqset *imap.SeqSet, folder string) error {
+	if err := client.UidCopy(seqset, folder); err != nil {
+		return err
+	}
+	item := imap.FormatFlagsOp(imap.AddFlags, true)
+	if err := client.UidStore(seqset, item, []interface{}{imap.DeletedFlag}, nil); err != nil {
+		return err
+	}
+	return client.Expunge(nil)
+}
+
+// formatSourceRef follows the RFC 5092 IMAP URL path form
+// "<mailbox>/;UIDVALIDITY=<v>/;UID=<uid>".
+func formatSourceRef(mailbox string, uidValidity, uid uint32) string {
+	return fmt.Sprintf("%s/;UIDVALIDITY=%d/;UID=%d", mailbox, uidValidity, uid)
+}
+
+func parseSourceRef(ref string) (string, uint32, uint32, error) {
+	i := strings.LastIndex(ref, "/;UIDVALIDITY=")
+	if i < 0 {
+		return "", 0, 0, fmt.Errorf("%w: bad imap source ref %q", connectors.ErrSourceGone, ref)
+	}
+	mailbox := ref[:i]
+	validityPart, uidPart, ok := strings.Cut(ref[i+len("/;UIDVALIDITY="):], "/;UID=")
+	if !ok {
+		return "", 0, 0, fmt.Errorf("%w: bad imap source ref %q", connectors.ErrSourceGone, ref)
+	}
+	validity, err1 := strconv.ParseUint(validityPart, 10, 32)
+	uid, err2 := strconv.ParseUint(uidPart, 10, 32)
+	if err1 != nil || err2 != nil {
+		return "", 0, 0, fmt.Errorf("%w: bad imap source ref %q", connectors.ErrSourceGone, ref)
+	}
+	return mailbox, uint32(validity), uint32(uid), nil
+}
+
+func (c *Connector) dial() (*imapclient.Client, error) {
+	addr := fmt.Sprintf("%s:%d", c.host, c.port)
+	var client *imapclient.Client
+	var err error
+	if c.secure {
+		client, err = imapclient.DialTLS(addr, &tls.Config{ServerName: c.host})
+	} else {
+		client, err = imapclient.Dial(addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := client.Login(c.user, c.password); err != nil {
+		_ = client.Logout()
 		return nil, err
 	}
+	return client, nil
+}
+
+func (c *Connector) cursorKey(label string) string {
+	return fmt.Sprintf("imap.cursor.%s@%s:%d/%s", c.user, c.host, c.port, label)
+}
+
+func (c *Connector) loadCursor(label string) (uidCursor, error) {
+	var cursor uidCursor
+	value, err := c.db.GetMetadata(c.cursorKey(label))
+	if err != nil || value == nil {
+		return cursor, err
+	}
+	if err := json.Unmarshal([]byte(*value), &cursor); err != nil {
+		// A corrupt cursor is treated like a UIDVALIDITY change: resync from scratch.
+		return uidCursor{}, nil
+	}
+	return cursor, nil
+}
 
-	return out, nil
+func (c *Connector) saveCursor(label string, cursor uidCursor) error {
+	blob, _ := json.Marshal(cursor)
+	return c.db.SetMetadata(c.cursorKey(label), string(blob))
 }
 
 func formatAddresses(addrs []*imap.Address) string {
@@ -0,0 +1,384 @@
+package imap
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/backend"
+	"github.com/emersion/go-imap/backend/memory"
+	imapserver "github.com/emersion/go-imap/server"
+
+	"elcom/internal"
+	"elcom/internal/config"
+	"elcom/internal/connectors"
+	"elcom/internal/connectors/conntest"
+)
+
+// testServer is an in-process IMAP server over the go-imap memory backend.
+// The backend gains an update channel so tests can push EXISTS to idling
+// clients after delivering a message.
+type testServer struct {
+	*memory.Backend
+	srv     *imapserver.Server
+	updates chan backend.Update
+	logins  atomic.Int32
+	host    string
+	port    int
+}
+
+func (s *testServer) Updates() <-chan backend.Update {
+	return s.updates
+}
+
+func (s *testServer) Login(info *imap.ConnInfo, username, password string) (backend.User, error) {
+	s.logins.Add(1)
+	return s.Backend.Login(info, username, password)
+}
+
+func startMemoryServer(t *testing.T) *testServer {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := &testServer{Backend: memory.New(), updates: make(chan backend.Update)}
+	ts.srv = imapserver.New(ts)
+	ts.srv.AllowInsecureAuth = true
+	go func() { _ = ts.srv.Serve(ln) }()
+	t.Cleanup(func() { _ = ts.srv.Close() })
+
+	host, portStr, _ := net.SplitHostPort(ln.Addr().String())
+	ts.host = host
+	ts.port, _ = strconv.Atoi(portStr)
+	return ts
+}
+
+// deliver appends a message to INBOX and notifies the sessions that have
+// it selected.
+func (s *testServer) deliver(raw string) error {
+	user, err := s.Backend.Login(nil, "username", "password")
+	if err != nil {
+		return err
+	}
+	mbox, err := user.GetMailbox("INBOX")
+	if err != nil {
+		return err
+	}
+	if err := mbox.CreateMessage(nil, time.Now(), bytes.NewBufferString(raw)); err != nil {
+		return err
+	}
+	status, err := mbox.Status([]imap.StatusItem{imap.StatusMessages})
+	if err != nil {
+		return err
+	}
+	update := &backend.MailboxUpdate{Update: backend.NewUpdate("username", "INBOX"), MailboxStatus: status}
+	// Done creates its channel lazily; create it before the server sees it.
+	done := update.Done()
+	s.updates <- update
+	<-done
+	return nil
+}
+
+func newTestConnector(t *testing.T) *Connector {
+	t.Helper()
+	conn, _ := newTestConnectorWithServer(t)
+	return conn
+}
+
+func newTestConnectorWithServer(t *testing.T) (*Connector, *testServer) {
+	t.Helper()
+	ts := startMemoryServer(t)
+
+	cfg, _ := config.Load()
+	cfg.IMAPHost = ts.host
+	cfg.IMAPPort = ts.port
+	cfg.IMAPSecure = false
+	cfg.IMAPUser = "username"
+	cfg.IMAPPassword = "password"
+	cfg.IMAPMarkSeen = false
+	conn, err := NewConnector(cfg, conntest.OpenDB(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn, ts
+}
+
+func TestFetchInboxUIDCursor(t *testing.T) {
+	conn := newTestConnector(t)
+
+	// The memory backend's only message is already \Seen; it must still be ingested.
+	first, err := conntest.Fetch(conn, "INBOX", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(first) != 1 || first[0].MessageID != "<0000000@localhost/>" {
+		t.Fatalf("first fetch=%+v", first)
+	}
+
+	second, err := conntest.Fetch(conn, "INBOX", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(second) != 0 {
+		t.Fatalf("second fetch len=%d", len(second))
+	}
+
+	// A different UIDVALIDITY forces a full resync.
+	if err := conn.saveCursor("INBOX", uidCursor{UIDValidity: 99, LastUID: 100}); err != nil {
+		t.Fatal(err)
+	}
+	third, err := conntest.Fetch(conn, "INBOX", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(third) != 1 {
+		t.Fatalf("resync len=%d", len(third))
+	}
+	cursor, err := conn.loadCursor("INBOX")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cursor.UIDValidity != 1 || cursor.LastUID != 6 {
+		t.Fatalf("cursor=%+v", cursor)
+	}
+}
+
+func TestWatchReusesSession(t *testing.T) {
+	conn := newTestConnector(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	calls := 0
+	err := conn.Watch(ctx, "INBOX", func() {
+		calls++
+		if conn.session == nil {
+			t.Error("onChange called without a live session")
+		}
+		msgs, err := conntest.Fetch(conn, "INBOX", 10)
+		if err != nil || len(msgs) != 1 {
+			t.Errorf("fetch in watch: len=%d err=%v", len(msgs), err)
+		}
+		cancel()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls != 1 {
+		t.Fatalf("calls=%d", calls)
+	}
+	if conn.session != nil {
+		t.Fatal("session not released")
+	}
+}
+
+func TestWatchWakesOnNewMessage(t *testing.T) {
+	conn, ts := newTestConnectorWithServer(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var got []string
+	err := conn.Watch(ctx, "INBOX", func() {
+		msgs, err := conntest.Fetch(conn, "INBOX", 10)
+		if err != nil {
+			t.Errorf("fetch in watch: %v", err)
+			cancel()
+			return
+		}
+		for _, m := range msgs {
+			got = append(got, m.MessageID)
+		}
+		if len(got) == 1 {
+			// Delivered while Watch idles; EXISTS must start another pass.
+			go func() {
+				if err := ts.deliver("Message-ID: <new@example.com>\r\nSubject: new\r\n\r\nbody\r\n"); err != nil {
+					t.Error(err)
+				}
+			}()
+			return
+		}
+		cancel()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(got, " ") != "<0000000@localhost/> <new@example.com>" {
+		t.Fatalf("got=%v", got)
+	}
+}
+
+func TestWatchReturnsErrorWhenConnectionDrops(t *testing.T) {
+	conn, ts := newTestConnectorWithServer(t)
+	done := make(chan error, 1)
+	go func() {
+		done <- conn.Watch(context.Background(), "INBOX", func() {
+			// Runs before IDLE starts; the server goes away while idling.
+			time.AfterFunc(50*time.Millisecond, func() { _ = ts.srv.Close() })
+		})
+	}()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("Watch returned nil after the connection dropped")
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Watch did not notice the dropped connection")
+	}
+}
+
+func TestFetchInboxHandlerErrorKeepsCursor(t *testing.T) {
+	conn := newTestConnector(t)
+	boom := errors.New("store failed")
+	err := conn.FetchInbox(context.Background(), "INBOX", 10, func(internal.FetchedMailMessage) error { return boom })
+	if !errors.Is(err, boom) {
+		t.Fatalf("err=%v", err)
+	}
+	msgs, err := conntest.Fetch(conn, "INBOX", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 1 {
+		t.Fatalf("message lost after handler error: len=%d", len(msgs))
+	}
+}
+
+func TestFetchInboxCancelled(t *testing.T) {
+	conn := newTestConnector(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := conn.FetchInbox(ctx, "INBOX", 10, func(internal.FetchedMailMessage) error { return nil })
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("err=%v", err)
+	}
+}
+
+func messageFlags(t *testing.T, conn *Connector, mailbox string) [][]string {
+	t.Helper()
+	client, err := conn.dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Logout()
+	status, err := client.Select(mailbox, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Messages == 0 {
+		return nil
+	}
+	seqset := new(imap.SeqSet)
+	seqset.AddRange(1, status.Messages)
+	messages := make(chan *imap.Message, status.Messages)
+	if err := client.Fetch(seqset, []imap.FetchItem{imap.FetchFlags}, messages); err != nil {
+		t.Fatal(err)
+	}
+	var out [][]string
+	for msg := range messages {
+		out = append(out, msg.Flags)
+	}
+	return out
+}
+
+// hasFlag compares case-insensitively: keywords are, and servers may fold them.
+func hasFlag(flags []string, flag string) bool {
+	for _, f := range flags {
+		if strings.EqualFold(f, flag) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestWriteOutcomeKeywordThenMove(t *testing.T) {
+	conn := newTestConnector(t)
+	conn.outcomeFolders = map[connectors.Outcome]string{connectors.OutcomeProcessed: "Elcom/Processed"}
+	ctx := context.Background()
+
+	msgs, err := conntest.Fetch(conn, "INBOX", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 1 || msgs[0].SourceRef == "" {
+		t.Fatalf("msgs=%+v", msgs)
+	}
+	ref := msgs[0].SourceRef
+
+	if err := conn.WriteOutcome(ctx, ref, connectors.OutcomeReview); err != nil {
+		t.Fatal(err)
+	}
+	flags := messageFlags(t, conn, "INBOX")
+	if len(flags) != 1 || !hasFlag(flags[0], "$ElcomReview") {
+		t.Fatalf("inbox flags=%v", flags)
+	}
+
+	// A configured folder moves the message; the previous keyword is replaced.
+	if err := conn.WriteOutcome(ctx, ref, connectors.OutcomeProcessed); err != nil {
+		t.Fatal(err)
+	}
+	if flags := messageFlags(t, conn, "INBOX"); len(flags) != 0 {
+		t.Fatalf("inbox not empty: %v", flags)
+	}
+	flags = messageFlags(t, conn, "Elcom/Processed")
+	if len(flags) != 1 || !hasFlag(flags[0], "$ElcomProcessed") || hasFlag(flags[0], "$ElcomReview") {
+		t.Fatalf("folder flags=%v", flags)
+	}
+}
+
+func TestWriteOutcomeUIDValidityChanged(t *testing.T) {
+	conn := newTestConnector(t)
+	mailbox, validity, uid, err := parseSourceRef(formatSourceRef("INBOX", 7, 42))
+	if err != nil || mailbox != "INBOX" || validity != 7 || uid != 42 {
+		t.Fatalf("parse=%s %d %d %v", mailbox, validity, uid, err)
+	}
+
+	err = conn.WriteOutcome(context.Background(), formatSourceRef("INBOX", 7, 1), connectors.OutcomeProcessed)
+	if !errors.Is(err, connectors.ErrSourceGone) {
+		t.Fatalf("err=%v", err)
+	}
+}
+
+func TestWritebackSyncLogsInOnce(t *testing.T) {
+	conn, ts := newTestConnectorWithServer(t)
+	for _, id := range []string{"a", "b"} {
+		if err := ts.deliver("Message-ID: <" + id + "@example.com>\r\nSubject: " + id + "\r\n\r\nbody\r\n"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	msgs, err := conntest.Fetch(conn, "INBOX", 10)
+	if err != nil || len(msgs) != 3 {
+		t.Fatalf("msgs=%d err=%v", len(msgs), err)
+	}
+	for _, msg := range msgs {
+		if _, err := conn.db.UpsertEmail("imap", msg.MessageID, msg.Subject, msg.From, msg.ReceivedAt, msg.MessageID, "", msg.SourceRef, "", "skipped"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	before := ts.logins.Load()
+	res, err := connectors.NewWritebackService(conn.db, conn, 3).Sync(context.Background(), "imap", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Written != 3 {
+		t.Fatalf("res=%+v", res)
+	}
+	if got := ts.logins.Load() - before; got != 1 {
+		t.Fatalf("logins=%d", got)
+	}
+	if conn.outcomeSession != nil {
+		t.Fatal("session kept after Sync")
+	}
+	for _, flags := range messageFlags(t, conn, "INBOX") {
+		if !hasFlag(flags, "$ElcomSkipped") {
+			t.Fatalf("flags=%v", flags)
+		}
+	}
+}
@@ -34,5 +34,5 @@ func (s *MailStoreService) Store(msg internal.FetchedMailMessage) (internal.Emai
 		}
 	}
 
-	return s.db.UpsertEmail(msg.Provider, msg.MessageID, msg.Subject, msg.From, msg.ReceivedAt, hash, rawPath, "fetched")
+	return s.db.UpsertEmail(msg.Provider, msg.MessageID, msg.Subject, msg.From, msg.ReceivedAt, hash, rawPath, msg.SourceRef, msg.Mailbox, "fetched")
 }
@@ -0,0 +1,45 @@
+package connectors
+
+import (
+	"context"
+	"errors"
+)
+
+// Outcome is the processing result written back to the source mailbox.
+type Outcome string
+
+const (
+	OutcomeProcessed Outcome = "processed"
+	OutcomeReview    Outcome = "review"
+	OutcomeSkipped   Outcome = "skipped"
+)
+
+// ErrSourceGone marks write-back failures that retrying cannot fix, such as
+// a deleted message or an IMAP mailbox whose UIDVALIDITY changed.
+var ErrSourceGone = errors.New("source message no longer addressable")
+
+// OutcomeWriter is implemented by connectors that can mark a message in the
+// source mailbox (label, keyword, folder). sourceRef is the value the
+// connector put into FetchedMailMessage.SourceRef. Writing the same outcome
+// twice must be harmless, and a new outcome replaces the previous one.
+type OutcomeWriter interface {
+	WriteOutcome(ctx context.Context, sourceRef string, outcome Outcome) error
+}
+
+// OutcomeSession is implemented by writers that keep a connection open
+// between WriteOutcome calls. WritebackService.Sync calls EndOutcomes after
+// its last write.
+type OutcomeSession interface {
+	EndOutcomes()
+}
+
+// OutcomeFor maps an email status to the outcome shown in the mailbox.
+func OutcomeFor(emailStatus string, hasReview bool) Outcome {
+	if emailStatus == "skipped" {
+		return OutcomeSkipped
+	}
+	if hasReview {
+		return OutcomeReview
+	}
+	return OutcomeProcessed
+}
@@ -0,0 +1,166 @@
+package pop3
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// errNoSTLS is returned when STARTTLS was requested but not offered.
+var errNoSTLS = errors.New("pop3 server does not offer STLS")
+
+// client speaks the subset of RFC 1939 / RFC 2449 / RFC 2595 the connector
+// needs. It is not safe for concurrent use.
+type client struct {
+	conn    net.Conn
+	text    *textproto.Conn
+	timeout time.Duration
+}
+
+// listing is one line of a UIDL response.
+type listing struct {
+	Num  int
+	UIDL string
+}
+
+// newClient reads the greeting. Every command, including its multi-line
+// response, must finish within timeout, so a stalled server or a plain
+// client talking to a TLS port fails instead of blocking forever.
+func newClient(conn net.Conn, timeout time.Duration) (*client, error) {
+	c := &client{conn: conn, text: textproto.NewConn(conn), timeout: timeout}
+	c.extendDeadline()
+	if _, err := c.readStatus(); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// startTLS upgrades a plain connection after checking CAPA for STLS.
+func (c *client) startTLS(cfg *tls.Config) error {
+	caps, err := c.capabilities()
+	if err != nil {
+		return err
+	}
+	if !caps["STLS"] {
+		return errNoSTLS
+	}
+	if _, err := c.cmd("STLS"); err != nil {
+		return err
+	}
+	tlsConn := tls.Client(c.conn, cfg)
+	c.extendDeadline()
+	if err := tlsConn.Handshake(); err != nil {
+		return err
+	}
+	c.conn = tlsConn
+	c.text = textproto.NewConn(tlsConn)
+	return nil
+}
+
+func (c *client) capabilities() (map[string]bool, error) {
+	if _, err := c.cmd("CAPA"); err != nil {
+		return nil, err
+	}
+	lines, err := c.text.ReadDotLines()
+	if err != nil {
+		return nil, err
+	}
+	caps := map[string]bool{}
+	for _, line := range lines {
+		if name, _, _ := strings.Cut(line, " "); name != "" {
+			caps[strings.ToUpper(name)] = true
+		}
+	}
+	return caps, nil
+}
+
+func (c *client) login(user, password string) error {
+	if _, err := c.cmd("USER %s", user); err != nil {
+		return err
+	}
+	_, err := c.cmd("PASS %s", password)
+	return err
+}
+
+// uidl lists every message with its unique id, in message-number order.
+func (c *client) uidl() ([]listing, error) {
+	if _, err := c.cmd("UIDL"); err != nil {
+		return nil, err
+	}
+	lines, err := c.text.ReadDotLines()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]listing, 0, len(lines))
+	for _, line := range lines {
+		numPart, uidl, ok := strings.Cut(strings.TrimSpace(line), " ")
+		num, err := strconv.Atoi(numPart)
+		if !ok || err != nil || uidl == "" {
+			return nil, fmt.Errorf("pop3: malformed UIDL line %q", line)
+		}
+		out = append(out, listing{Num: num, UIDL: uidl})
+	}
+	return out, nil
+}
+
+// retr downloads one message with dot-stuffing removed and CRLF preserved.
+func (c *client) retr(num int) ([]byte, error) {
+	if _, err := c.cmd("RETR %d", num); err != nil {
+		return nil, err
+	}
+	lines, err := c.text.ReadDotLines()
+	if err != nil {
+		return nil, err
+	}
+	var b strings.Builder
+	for _, line := range lines {
+		b.WriteString(line)
+		b.WriteString("\r\n")
+	}
+	return []byte(b.String()), nil
+}
+
+// dele marks a message; the server removes it only on a clean QUIT.
+func (c *client) dele(num int) error {
+	_, err := c.cmd("DELE %d", num)
+	return err
+}
+
+func (c *client) quit() error {
+	_, err := c.cmd("QUIT")
+	_ = c.conn.Close()
+	return err
+}
+
+func (c *client) close() error {
+	return c.conn.Close()
+}
+
+func (c *client) cmd(format string, args ...any) (string, error) {
+	c.extendDeadline()
+	if err := c.text.PrintfLine(format, args...); err != nil {
+		return "", err
+	}
+	return c.readStatus()
+}
+
+func (c *client) extendDeadline() {
+	_ = c.conn.SetDeadline(time.Now().Add(c.timeout))
+}
+
+func (c *client) readStatus() (string, error) {
+	line, err := c.text.ReadLine()
+	if err != nil {
+		return "", err
+	}
+	if rest, ok := strings.CutPrefix(line, "+OK"); ok {
+		return strings.TrimSpace(rest), nil
+	}
+	return "", fmt.Errorf("pop3: %s", strings.TrimSpace(strings.TrimPrefix(line, "-ERR")))
+}
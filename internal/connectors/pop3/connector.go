@@ -0,0 +1,231 @@
+package pop3
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"elcom/internal"
+	"elcom/internal/config"
+	"elcom/internal/connectors"
+	"elcom/internal/storage"
+)
+
+// Provider is the emails.provider value of POP3 mail.
+const Provider = "pop3"
+
+// Connection security modes for POP3_SECURITY.
+const (
+	SecurityTLS      = "tls"
+	SecuritySTARTTLS = "starttls"
+	SecurityPlain    = "plain"
+)
+
+const (
+	dialTimeout = 30 * time.Second
+	// commandTimeout bounds one command and its response, RETR included.
+	commandTimeout = 2 * time.Minute
+)
+
+type Connector struct {
+	db             *storage.DB
+	host           string
+	port           int
+	security       string
+	user           string
+	password       string
+	deleteAfterGet bool
+
+	// tlsConfig overrides the default verification, for tests.
+	tlsConfig *tls.Config
+	timeout   time.Duration
+}
+
+func NewConnector(cfg config.Config, db *storage.DB) (*Connector, error) {
+	if err := cfg.Require("POP3_HOST", cfg.POP3Host); err != nil {
+		return nil, err
+	}
+	if err := cfg.Require("POP3_USER", cfg.POP3User); err != nil {
+		return nil, err
+	}
+	if err := cfg.Require("POP3_PASSWORD", cfg.POP3Password); err != nil {
+		return nil, err
+	}
+	security := strings.ToLower(strings.TrimSpace(cfg.POP3Security))
+	switch security {
+	case SecurityTLS, SecuritySTARTTLS, SecurityPlain:
+	default:
+		return nil, fmt.Errorf("unsupported POP3_SECURITY: %s", cfg.POP3Security)
+	}
+
+	return &Connector{
+		db:             db,
+		host:           cfg.POP3Host,
+		port:           cfg.POP3Port,
+		security:       security,
+		user:           cfg.POP3User,
+		password:       cfg.POP3Password,
+		deleteAfterGet: cfg.POP3DeleteAfterStore,
+		timeout:        commandTimeout,
+	}, nil
+}
+
+// FetchInbox downloads every message whose UIDL was not ingested before, in
+// server order. A UIDL is recorded only after the handler stored the message,
+// so each message is ingested exactly once. With delete enabled, stored
+// messages are marked for deletion and removed by the final QUIT; a broken
+// session leaves them on the server to be deleted next time. POP3 has a
+// single mailbox, so label is ignored.
+func (c *Connector) FetchInbox(ctx context.Context, label string, max int, handle connectors.MessageHandler) error {
+	cl, err := c.dial(ctx)
+	if err != nil {
+		return err
+	}
+	stop := context.AfterFunc(ctx, func() { _ = cl.close() })
+	defer stop()
+
+	if err := c.fetch(ctx, cl, max, handle); err != nil {
+		_ = cl.close()
+		return connectors.CtxErr(ctx, err)
+	}
+	return connectors.CtxErr(ctx, cl.quit())
+}
+
+func (c *Connector) fetch(ctx context.Context, cl *client, max int, handle connectors.MessageHandler) error {
+	listings, err := cl.uidl()
+	if err != nil {
+		return err
+	}
+	seen, err := c.db.ListUIDLs(c.account())
+	if err != nil {
+		return err
+	}
+	if err := c.forgetMissing(listings, seen); err != nil {
+		return err
+	}
+
+	fetched := 0
+	for _, l := range listings {
+		if seen[l.UIDL] {
+			if c.deleteAfterGet {
+				if err := cl.dele(l.Num); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if max > 0 && fetched >= max {
+			continue
+		}
+
+		raw, err := cl.retr(l.Num)
+		if err != nil {
+			return err
+		}
+		if err := handle(c.message(l.UIDL, raw)); err != nil {
+			return err
+		}
+		if err := c.db.AddUIDL(c.account(), l.UIDL); err != nil {
+			return err
+		}
+		fetched++
+		if c.deleteAfterGet {
+			if err := cl.dele(l.Num); err != nil {
+				return err
+			}
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// forgetMissing drops UIDLs the server no longer lists so the table only
+// tracks what is still in the maildrop.
+func (c *Connector) forgetMissing(listings []listing, seen map[string]bool) error {
+	present := make(map[string]bool, len(listings))
+	for _, l := range listings {
+		present[l.UIDL] = true
+	}
+	for uidl := range seen {
+		if !present[uidl] {
+			if err := c.db.DeleteUIDL(c.account(), uidl); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (c *Connector) message(uidl string, raw []byte) internal.FetchedMailMessage {
+	headers, _ := connectors.ParseMessageHeaders(raw)
+
+	received := time.Now().UTC()
+	if !headers.Date.IsZero() {
+		received = headers.Date.UTC()
+	}
+	messageID := headers.MessageID
+	if messageID == "" {
+		messageID = fmt.Sprintf("<pop3-%s@%s>", uidl, c.host)
+	}
+
+	return internal.FetchedMailMessage{
+		Provider:   Provider,
+		MessageID:  messageID,
+		Subject:    headers.Subject,
+		From:       headers.From,
+		ReceivedAt: received.Format(time.RFC3339),
+		Raw:        raw,
+		SourceRef:  uidl,
+	}
+}
+
+func (c *Connector) dial(ctx context.Context) (*client, error) {
+	addr := net.JoinHostPort(c.host, fmt.Sprintf("%d", c.port))
+	dialer := &net.Dialer{Timeout: dialTimeout}
+
+	var (
+		conn net.Conn
+		err  error
+	)
+	if c.security == SecurityTLS {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: c.tls()}).DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	cl, err := newClient(conn, c.timeout)
+	if err != nil {
+		return nil, err
+	}
+	if c.security == SecuritySTARTTLS {
+		if err := cl.startTLS(c.tls()); err != nil {
+			_ = cl.close()
+			return nil, err
+		}
+	}
+	if err := cl.login(c.user, c.password); err != nil {
+		_ = cl.close()
+		return nil, err
+	}
+	return cl, nil
+}
+
+func (c *Connector) tls() *tls.Config {
+	if c.tlsConfig != nil {
+		return c.tlsConfig
+	}
+	return &tls.Config{ServerName: c.host}
+}
+
+// account identifies the maildrop in the uidls table.
+func (c *Connector) account() string {
+	return fmt.Sprintf("%s@%s:%d", c.user, c.host, c.port)
+}
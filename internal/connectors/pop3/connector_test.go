@@ -0,0 +1,131 @@
+package pop3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"elcom/internal"
+	"elcom/internal/config"
+	"elcom/internal/connectors/conntest"
+)
+
+// rawMessage ends with a line starting with a dot, which RETR must unstuff.
+func rawMessage(id string) string {
+	return conntest.RawMessage(id) + ".hidden line\r\n"
+}
+
+func newTestConnector(t *testing.T, fake *fakePOP3, security string, deleteAfter bool) *Connector {
+	t.Helper()
+	conn, err := NewConnector(config.Config{
+		POP3Host:             "127.0.0.1",
+		POP3Port:             fake.port(),
+		POP3Security:         security,
+		POP3User:             "branch",
+		POP3Password:         "secret",
+		POP3DeleteAfterStore: deleteAfter,
+	}, conntest.OpenDB(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.tlsConfig = fake.clientTLS(t)
+	return conn
+}
+
+func TestFetchInboxSTLSIngestsOnce(t *testing.T) {
+	fake := newFakePOP3(t, false)
+	fake.add("u1", rawMessage("m1"))
+	fake.add("u2", rawMessage("m2"))
+	conn := newTestConnector(t, fake, SecuritySTARTTLS, false)
+
+	msgs := conntest.MustFetch(t, conn, "INBOX", 1)
+	if len(msgs) != 1 || msgs[0].MessageID != "<m1@example.com>" || msgs[0].Subject != "Заявка m1" || msgs[0].Provider != Provider {
+		t.Fatalf("first=%+v", msgs)
+	}
+	if want := rawMessage("m1"); string(msgs[0].Raw) != want {
+		t.Fatalf("raw=%q", msgs[0].Raw)
+	}
+
+	fake.add("u3", rawMessage("m3"))
+	msgs = conntest.MustFetch(t, conn, "INBOX", 0)
+	if len(msgs) != 2 || msgs[0].SourceRef != "u2" || msgs[1].SourceRef != "u3" {
+		t.Fatalf("second=%+v", msgs)
+	}
+	if msgs = conntest.MustFetch(t, conn, "INBOX", 0); len(msgs) != 0 {
+		t.Fatalf("refetched %d messages", len(msgs))
+	}
+	if fake.retrs != 3 {
+		t.Fatalf("retrs=%d", fake.retrs)
+	}
+	if got := fmt.Sprint(fake.uidls()); got != "[u1 u2 u3]" {
+		t.Fatalf("server kept %s", got)
+	}
+}
+
+func TestFetchInboxImplicitTLSDeletesStored(t *testing.T) {
+	fake := newFakePOP3(t, true)
+	fake.add("u1", rawMessage("m1"))
+	fake.add("u2", rawMessage("m2"))
+	conn := newTestConnector(t, fake, SecurityTLS, true)
+
+	// A failing handler leaves the message on the server and unrecorded.
+	boom := errors.New("store failed")
+	err := conn.FetchInbox(context.Background(), "INBOX", 0, func(msg internal.FetchedMailMessage) error {
+		if msg.SourceRef == "u2" {
+			return boom
+		}
+		return nil
+	})
+	if !errors.Is(err, boom) {
+		t.Fatalf("err=%v", err)
+	}
+	if got := fmt.Sprint(fake.uidls()); got != "[u1 u2]" {
+		t.Fatalf("deleted without QUIT: %s", got)
+	}
+
+	// u1 was recorded, so it is only deleted now; u2 is ingested and deleted.
+	msgs := conntest.MustFetch(t, conn, "INBOX", 0)
+	if len(msgs) != 1 || msgs[0].SourceRef != "u2" {
+		t.Fatalf("msgs=%+v", msgs)
+	}
+	if got := fake.uidls(); len(got) != 0 {
+		t.Fatalf("server kept %v", got)
+	}
+
+	// UIDLs of messages gone from the server are forgotten on the next run.
+	conntest.MustFetch(t, conn, "INBOX", 0)
+	seen, err := conn.db.ListUIDLs(conn.account())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(seen) != 0 {
+		t.Fatalf("uidls not pruned: %v", seen)
+	}
+}
+
+// A plain client on an implicit-TLS port never gets a greeting; the command
+// deadline turns the stall into an error.
+func TestFetchInboxPlainOnTLSPortTimesOut(t *testing.T) {
+	fake := newFakePOP3(t, true)
+	conn := newTestConnector(t, fake, SecurityPlain, false)
+	conn.timeout = 200 * time.Millisecond
+
+	err := conn.FetchInbox(context.Background(), "INBOX", 0, func(internal.FetchedMailMessage) error { return nil })
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Fatalf("err=%v", err)
+	}
+}
+
+func TestFetchInboxPlainLoginRefused(t *testing.T) {
+	fake := newFakePOP3(t, false)
+	conn := newTestConnector(t, fake, SecurityPlain, false)
+	err := conn.FetchInbox(context.Background(), "INBOX", 0, func(internal.FetchedMailMessage) error { return nil })
+	if err == nil || !strings.Contains(err.Error(), "use TLS") {
+		t.Fatalf("err=%v", err)
+	}
+}
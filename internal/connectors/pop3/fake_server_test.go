@@ -0,0 +1,237 @@
+package pop3
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakePOP3 is an in-process maildrop speaking USER/PASS, CAPA, STLS, UIDL,
+// RETR, DELE and QUIT. Deletions are applied only on QUIT, as in RFC 1939.
+type fakePOP3 struct {
+	t        *testing.T
+	ln       net.Listener
+	tls      *tls.Config
+	implicit bool
+
+	mu       sync.Mutex
+	messages []fakeMessage
+	retrs    int
+}
+
+type fakeMessage struct {
+	uidl string
+	raw  string
+}
+
+func newFakePOP3(t *testing.T, implicitTLS bool) *fakePOP3 {
+	f := &fakePOP3{t: t, tls: selfSignedTLS(t), implicit: implicitTLS}
+	var err error
+	if implicitTLS {
+		f.ln, err = tls.Listen("tcp", "127.0.0.1:0", f.tls)
+	} else {
+		f.ln, err = net.Listen("tcp", "127.0.0.1:0")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = f.ln.Close() })
+	go f.serve()
+	return f
+}
+
+func (f *fakePOP3) port() int {
+	return f.ln.Addr().(*net.TCPAddr).Port
+}
+
+func (f *fakePOP3) add(uidl, raw string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.messages = append(f.messages, fakeMessage{uidl: uidl, raw: raw})
+}
+
+func (f *fakePOP3) uidls() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	out := make([]string, 0, len(f.messages))
+	for _, m := range f.messages {
+		out = append(out, m.uidl)
+	}
+	return out
+}
+
+func (f *fakePOP3) serve() {
+	for {
+		conn, err := f.ln.Accept()
+		if err != nil {
+			return
+		}
+		go f.session(conn)
+	}
+}
+
+func (f *fakePOP3) session(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(format string, args ...any) {
+		_, _ = fmt.Fprintf(conn, format+"\r\n", args...)
+	}
+
+	reply("+OK fake pop3 ready")
+	authed, secure := false, f.implicit
+	deleted := map[int]bool{}
+
+	f.mu.Lock()
+	snapshot := append([]fakeMessage(nil), f.messages...)
+	f.mu.Unlock()
+
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		verb, arg, _ := strings.Cut(strings.TrimSpace(line), " ")
+		verb = strings.ToUpper(verb)
+
+		if !authed && verb != "CAPA" && verb != "STLS" && verb != "USER" && verb != "PASS" && verb != "QUIT" {
+			reply("-ERR authenticate first")
+			continue
+		}
+		num, _ := strconv.Atoi(arg)
+		valid := num >= 1 && num <= len(snapshot) && !deleted[num]
+
+		switch verb {
+		case "CAPA":
+			reply("+OK capability list follows")
+			reply("USER")
+			reply("UIDL")
+			if !secure {
+				reply("STLS")
+			}
+			reply(".")
+		case "STLS":
+			if secure {
+				reply("-ERR already secure")
+				continue
+			}
+			reply("+OK begin TLS")
+			tlsConn := tls.Server(conn, f.tls)
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+			conn, r, secure = tlsConn, bufio.NewReader(tlsConn), true
+		case "USER":
+			if !secure {
+				reply("-ERR use TLS")
+				continue
+			}
+			reply("+OK")
+		case "PASS":
+			if arg != "secret" {
+				reply("-ERR invalid password")
+				continue
+			}
+			authed = true
+			reply("+OK logged in")
+		case "UIDL":
+			reply("+OK")
+			for i, m := range snapshot {
+				if !deleted[i+1] {
+					reply("%d %s", i+1, m.uidl)
+				}
+			}
+			reply(".")
+		case "RETR":
+			if !valid {
+				reply("-ERR no such message")
+				continue
+			}
+			f.mu.Lock()
+			f.retrs++
+			f.mu.Unlock()
+			reply("+OK message follows")
+			for _, l := range strings.Split(strings.TrimSuffix(snapshot[num-1].raw, "\r\n"), "\r\n") {
+				if strings.HasPrefix(l, ".") {
+					l = "." + l
+				}
+				reply("%s", l)
+			}
+			reply(".")
+		case "DELE":
+			if !valid {
+				reply("-ERR no such message")
+				continue
+			}
+			deleted[num] = true
+			reply("+OK marked")
+		case "QUIT":
+			f.mu.Lock()
+			kept := f.messages[:0]
+			for _, m := range f.messages {
+				drop := false
+				for n := range deleted {
+					if snapshot[n-1].uidl == m.uidl {
+						drop = true
+					}
+				}
+				if !drop {
+					kept = append(kept, m)
+				}
+			}
+			f.messages = kept
+			f.mu.Unlock()
+			reply("+OK bye")
+			return
+		default:
+			reply("-ERR unknown command")
+		}
+	}
+}
+
+// selfSignedTLS returns a server config for 127.0.0.1 and keeps the client
+// side in clientTLS via the same certificate.
+func selfSignedTLS(t *testing.T) *tls.Config {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "fake pop3"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+}
+
+// clientTLS trusts the fake server's certificate.
+func (f *fakePOP3) clientTLS(t *testing.T) *tls.Config {
+	t.Helper()
+	cert, err := x509.ParseCertificate(f.tls.Certificates[0].Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &tls.Config{RootCAs: pool, ServerName: "127.0.0.1"}
+}
@@ -0,0 +1,192 @@
+package smtpd
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-smtp"
+
+	"elcom/internal"
+	"elcom/internal/config"
+	"elcom/internal/connectors"
+	"elcom/internal/storage"
+)
+
+// Provider is the emails.provider value for mail received by this server.
+const Provider = "smtp"
+
+var (
+	errUnknownRecipient = &smtp.SMTPError{Code: 550, EnhancedCode: smtp.EnhancedCode{5, 1, 1}, Message: "recipient not accepted here"}
+	errNoRecipients     = &smtp.SMTPError{Code: 554, EnhancedCode: smtp.EnhancedCode{5, 5, 1}, Message: "no valid recipients"}
+	// errTempStorage makes the sending MTA queue and retry instead of bouncing.
+	errTempStorage = &smtp.SMTPError{Code: 451, EnhancedCode: smtp.EnhancedCode{4, 3, 0}, Message: "temporary storage failure, try again later"}
+)
+
+// Server is an embedded SMTP (or LMTP) receiver for customers that can only
+// forward mail. Accepted messages go through MailStoreService like fetched
+// mail, tagged with mailbox; onStored fires after each stored message.
+type Server struct {
+	srv        *smtp.Server
+	store      *connectors.MailStoreService
+	mailbox    string
+	recipients []string
+	onStored   func()
+}
+
+func NewServer(cfg config.Config, db *storage.DB, mailbox string, onStored func()) (*Server, error) {
+	if len(cfg.SMTPInboundRecipients) == 0 {
+		return nil, fmt.Errorf("missing required env var: SMTP_INBOUND_RECIPIENTS")
+	}
+
+	s := &Server{
+		store:    connectors.NewMailStoreService(db, cfg.RawMailDir),
+		mailbox:  mailbox,
+		onStored: onStored,
+	}
+	for _, r := range cfg.SMTPInboundRecipients {
+		s.recipients = append(s.recipients, strings.ToLower(strings.TrimSpace(r)))
+	}
+
+	srv := smtp.NewServer(s)
+	srv.Addr = cfg.SMTPInboundAddr
+	srv.Domain = cfg.SMTPInboundDomain
+	srv.LMTP = cfg.SMTPInboundLMTP
+	srv.MaxMessageBytes = cfg.SMTPInboundMaxBytes
+	srv.MaxRecipients = 50
+	srv.ReadTimeout = time.Minute
+	srv.WriteTimeout = time.Minute
+	if cfg.SMTPInboundTLSCert != "" || cfg.SMTPInboundTLSKey != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.SMTPInboundTLSCert, cfg.SMTPInboundTLSKey)
+		if err != nil {
+			return nil, fmt.Errorf("load smtp inbound certificate: %w", err)
+		}
+		// Advertises STARTTLS; plain sessions remain allowed for internal relays.
+		srv.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+	}
+	s.srv = srv
+	return s, nil
+}
+
+// ListenAndServe listens on the configured address until ctx is done.
+func (s *Server) ListenAndServe(ctx context.Context) error {
+	ln, err := net.Listen("tcp", s.srv.Addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ctx, ln)
+}
+
+// Serve accepts connections on ln until ctx is done, then shuts down gracefully.
+func (s *Server) Serve(ctx context.Context, ln net.Listener) error {
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		_ = s.srv.Shutdown(shutdownCtx)
+	}()
+	err := s.srv.Serve(ln)
+	if errors.Is(err, smtp.ErrServerClosed) || ctx.Err() != nil {
+		return nil
+	}
+	return err
+}
+
+// NewSession implements smtp.Backend.
+func (s *Server) NewSession(_ *smtp.Conn) (smtp.Session, error) {
+	return &session{server: s}, nil
+}
+
+// accepts matches an address against the allowlist; entries starting with
+// "@" accept a whole domain.
+func (s *Server) accepts(addr string) bool {
+	addr = strings.ToLower(strings.TrimSpace(addr))
+	for _, r := range s.recipients {
+		if r == addr || (strings.HasPrefix(r, "@") && strings.HasSuffix(addr, r)) {
+			return true
+		}
+	}
+	return false
+}
+
+type session struct {
+	server *Server
+	from   string
+	rcpts  []string
+}
+
+func (s *session) Reset() {
+	s.from = ""
+	s.rcpts = nil
+}
+
+func (s *session) Logout() error { return nil }
+
+func (s *session) Mail(from string, _ *smtp.MailOptions) error {
+	s.Reset()
+	s.from = from
+	return nil
+}
+
+func (s *session) Rcpt(to string, _ *smtp.RcptOptions) error {
+	if !s.server.accepts(to) {
+		return errUnknownRecipient
+	}
+	s.rcpts = append(s.rcpts, to)
+	return nil
+}
+
+func (s *session) Data(r io.Reader) error {
+	if len(s.rcpts) == 0 {
+		return errNoRecipients
+	}
+	// The server caps the stream at MaxMessageBytes and answers 552 itself.
+	raw, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	if _, err := s.server.store.Store(toMessage(raw, s.from, s.server.mailbox)); err != nil {
+		fmt.Printf("smtp inbound store error: %v\n", err)
+		return errTempStorage
+	}
+	if s.server.onStored != nil {
+		s.server.onStored()
+	}
+	return nil
+}
+
+func toMessage(raw []byte, envelopeFrom, mailbox string) internal.FetchedMailMessage {
+	headers, _ := connectors.ParseMessageHeaders(raw)
+
+	messageID := headers.MessageID
+	if messageID == "" {
+		sum := sha256.Sum256(raw)
+		messageID = "smtp-" + hex.EncodeToString(sum[:12])
+	}
+	from := headers.From
+	if from == "" {
+		from = envelopeFrom
+	}
+	received := time.Now().UTC()
+	if !headers.Date.IsZero() {
+		received = headers.Date.UTC()
+	}
+
+	return internal.FetchedMailMessage{
+		Provider:   Provider,
+		MessageID:  messageID,
+		Subject:    headers.Subject,
+		From:       from,
+		ReceivedAt: received.Format(time.RFC3339),
+		Raw:        raw,
+		Mailbox:    mailbox,
+	}
+}
@@ -0,0 +1,139 @@
+package smtpd
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"path/filepath"
+	"testing"
+
+	"elcom/internal/config"
+	"elcom/internal/storage"
+)
+
+const quoteEML = "From: customer@example.com\r\nTo: orders@elcom.test\r\nSubject: quote\r\nMessage-ID: <m1@example.com>\r\nContent-Type: text/plain; charset=utf-8\r\n\r\nКабель ВВГнг 3x2.5 10 шт\r\n"
+
+func startServer(t *testing.T, db *storage.DB, onStored func()) string {
+	return startMailboxServer(t, db, "", onStored)
+}
+
+func startMailboxServer(t *testing.T, db *storage.DB, mailbox string, onStored func()) string {
+	t.Helper()
+	cfg := config.Config{
+		RawMailDir:            t.TempDir(),
+		SMTPInboundDomain:     "localhost",
+		SMTPInboundRecipients: []string{"orders@elcom.test", "@quotes.elcom.test"},
+		SMTPInboundMaxBytes:   1 << 20,
+	}
+	server, err := NewServer(cfg, db, mailbox, onStored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- server.Serve(ctx, ln) }()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("serve: %v", err)
+		}
+	})
+	return ln.Addr().String()
+}
+
+func openDB(t *testing.T) *storage.DB {
+	t.Helper()
+	db, err := storage.Open(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func smtpCode(err error) int {
+	var tpErr *textproto.Error
+	if errors.As(err, &tpErr) {
+		return tpErr.Code
+	}
+	return 0
+}
+
+func TestServerStoresAcceptedMail(t *testing.T) {
+	db := openDB(t)
+	defer db.Close()
+	stored := make(chan struct{}, 4)
+	addr := startServer(t, db, func() { stored <- struct{}{} })
+
+	if err := smtp.SendMail(addr, nil, "relay@example.com", []string{"orders@elcom.test"}, []byte(quoteEML)); err != nil {
+		t.Fatal(err)
+	}
+	<-stored
+
+	row, err := db.GetEmailByProviderMessageID(Provider, "<m1@example.com>")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if row == nil || row.Status != "fetched" || row.Subject != "quote" {
+		t.Fatalf("row=%+v", row)
+	}
+
+	// Domain entries accept any local part.
+	if err := smtp.SendMail(addr, nil, "relay@example.com", []string{"anyone@quotes.elcom.test"}, []byte(quoteEML)); err != nil {
+		t.Fatal(err)
+	}
+	<-stored
+}
+
+func TestServerTagsMailbox(t *testing.T) {
+	db := openDB(t)
+	defer db.Close()
+	stored := make(chan struct{}, 1)
+	addr := startMailboxServer(t, db, "supply", func() { stored <- struct{}{} })
+
+	if err := smtp.SendMail(addr, nil, "relay@example.com", []string{"orders@elcom.test"}, []byte(quoteEML)); err != nil {
+		t.Fatal(err)
+	}
+	<-stored
+
+	row, err := db.GetEmailByProviderMessageID(Provider, "<m1@example.com>")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if row == nil || row.Mailbox != "supply" {
+		t.Fatalf("row=%+v", row)
+	}
+}
+
+func TestServerRejectsUnknownRecipient(t *testing.T) {
+	db := openDB(t)
+	defer db.Close()
+	addr := startServer(t, db, nil)
+
+	err := smtp.SendMail(addr, nil, "relay@example.com", []string{"someone@else.test"}, []byte(quoteEML))
+	if code := smtpCode(err); code != 550 {
+		t.Fatalf("code=%d err=%v", code, err)
+	}
+}
+
+func TestServerTempFailsWhenStorageDown(t *testing.T) {
+	db := openDB(t)
+	addr := startServer(t, db, func() { t.Error("onStored called for a failed store") })
+	_ = db.Close()
+
+	err := smtp.SendMail(addr, nil, "relay@example.com", []string{"orders@elcom.test"}, []byte(quoteEML))
+	if code := smtpCode(err); code != 451 {
+		t.Fatalf("code=%d err=%v", code, err)
+	}
+}
+
+func TestNewServerRequiresRecipients(t *testing.T) {
+	if _, err := NewServer(config.Config{}, nil, "", nil); err == nil {
+		t.Fatal("expected error without recipients")
+	}
+}
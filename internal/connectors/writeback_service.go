@@ -0,0 +1,94 @@
+package connectors
+
+import (
+	"context"
+	"errors"
+
+	"elcom/internal"
+	"elcom/internal/storage"
+)
+
+// Write-back statuses stored in the writebacks table.
+const (
+	WritebackPending = "pending"
+	WritebackDone    = "done"
+	WritebackFailed  = "failed"
+)
+
+const writebackBatch = 200
+
+type WritebackService struct {
+	db          *storage.DB
+	writer      OutcomeWriter
+	maxAttempts int
+}
+
+type WritebackResult struct {
+	Written int
+	Failed  int
+	Pending int
+}
+
+func NewWritebackService(db *storage.DB, writer OutcomeWriter, maxAttempts int) *WritebackService {
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+	return &WritebackService{db: db, writer: writer, maxAttempts: maxAttempts}
+}
+
+// Sync writes the outcome of every finished email of provider (restricted to
+// mailbox unless it is empty) back to its source. Failed attempts stay
+// pending and are retried on the next Sync until maxAttempts is reached;
+// ErrSourceGone fails the row immediately.
+func (s *WritebackService) Sync(ctx context.Context, provider, mailbox string) (WritebackResult, error) {
+	var result WritebackResult
+	candidates, err := s.db.ListWritebackCandidates(provider, mailbox, writebackBatch)
+	if err != nil {
+		return result, err
+	}
+	if session, ok := s.writer.(OutcomeSession); ok {
+		defer session.EndOutcomes()
+	}
+
+	for _, c := range candidates {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+		outcome := OutcomeFor(c.EmailStatus, c.HasReview)
+		row := internal.WritebackRow{EmailID: c.EmailID, Outcome: string(outcome), EmailStatus: c.EmailStatus}
+		if prev := c.Writeback; prev != nil && prev.Outcome == row.Outcome {
+			if prev.Status != WritebackPending {
+				// Status changed without changing the outcome (e.g. exported):
+				// record the new status so the row is not selected again.
+				prev.EmailStatus = c.EmailStatus
+				if err := s.db.UpsertWriteback(*prev); err != nil {
+					return result, err
+				}
+				continue
+			}
+			row.Attempts = prev.Attempts
+		}
+
+		row.Attempts++
+		err := s.writer.WriteOutcome(ctx, c.SourceRef, outcome)
+		switch {
+		case err == nil:
+			row.Status = WritebackDone
+			result.Written++
+		case ctx.Err() != nil:
+			return result, ctx.Err()
+		case errors.Is(err, ErrSourceGone) || row.Attempts >= s.maxAttempts:
+			row.Status = WritebackFailed
+			row.LastError = err.Error()
+			result.Failed++
+		default:
+			row.Status = WritebackPending
+			row.LastError = err.Error()
+			result.Pending++
+		}
+		if err := s.db.UpsertWriteback(row); err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
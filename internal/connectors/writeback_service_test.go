@@ -0,0 +1,156 @@
+package connectors
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"elcom/internal"
+	"elcom/internal/storage"
+)
+
+type fakeWriter struct {
+	failures int
+	err      error
+	calls    []string
+}
+
+func (w *fakeWriter) WriteOutcome(_ context.Context, sourceRef string, outcome Outcome) error {
+	w.calls = append(w.calls, fmt.Sprintf("%s=%s", sourceRef, outcome))
+	if w.failures > 0 {
+		w.failures--
+		return w.err
+	}
+	return nil
+}
+
+func seedFinishedEmail(t *testing.T, db *storage.DB, messageID, sourceRef, status string, match internal.MatchStatus) int {
+	t.Helper()
+	email, err := db.UpsertEmail("imap", messageID, "s", "f", "2026-02-08T00:00:00Z", "h", "raw", sourceRef, "", "fetched")
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := db.InsertExtraction(email.ID, internal.ExtractionItem{LineNo: 1, Source: internal.SourceEmailText, RawLine: "x"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.InsertMatch(id, internal.MatchResult{Status: match, Reason: internal.ReasonNone}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.UpdateEmailStatus(email.ID, status); err != nil {
+		t.Fatal(err)
+	}
+	return email.ID
+}
+
+func TestWritebackRetriesThenGivesUp(t *testing.T) {
+	db, err := storage.Open(filepath.Join(t.TempDir(), "app.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	ctx := context.Background()
+
+	seedFinishedEmail(t, db, "<a>", "ref-a", "processed", internal.MatchReview)
+	seedFinishedEmail(t, db, "<b>", "ref-b", "skipped", internal.MatchNotFound)
+	seedFinishedEmail(t, db, "<c>", "", "processed", internal.MatchOK) // no source ref: never written
+
+	writer := &fakeWriter{failures: 2, err: errors.New("temporary")}
+	svc := NewWritebackService(db, writer, 2)
+
+	// First pass: both fail once and stay pending.
+	res, err := svc.Sync(ctx, "imap", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res != (WritebackResult{Pending: 2}) {
+		t.Fatalf("first=%+v", res)
+	}
+
+	res, err = svc.Sync(ctx, "imap", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res != (WritebackResult{Written: 2}) {
+		t.Fatalf("second=%+v calls=%v", res, writer.calls)
+	}
+	want := []string{"ref-a=review", "ref-b=skipped", "ref-a=review", "ref-b=skipped"}
+	if fmt.Sprint(writer.calls) != fmt.Sprint(want) {
+		t.Fatalf("calls=%v", writer.calls)
+	}
+
+	// Done rows are not written again.
+	res, err = svc.Sync(ctx, "imap", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res != (WritebackResult{}) || len(writer.calls) != 4 {
+		t.Fatalf("third=%+v calls=%v", res, writer.calls)
+	}
+}
+
+func TestWritebackFollowsStatusChangeWithinASecond(t *testing.T) {
+	db, err := storage.Open(filepath.Join(t.TempDir(), "app.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	ctx := context.Background()
+
+	id := seedFinishedEmail(t, db, "<a>", "ref-a", "processed", internal.MatchOK)
+	writer := &fakeWriter{}
+	svc := NewWritebackService(db, writer, 3)
+	if _, err := svc.Sync(ctx, "imap", ""); err != nil {
+		t.Fatal(err)
+	}
+	// Same outcome: recorded without writing again.
+	if err := db.UpdateEmailStatus(id, "exported"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := svc.Sync(ctx, "imap", ""); err != nil {
+		t.Fatal(err)
+	}
+	// New outcome in the same second as the last write-back.
+	if err := db.UpdateEmailStatus(id, "skipped"); err != nil {
+		t.Fatal(err)
+	}
+	res, err := svc.Sync(ctx, "imap", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res != (WritebackResult{Written: 1}) || fmt.Sprint(writer.calls) != "[ref-a=processed ref-a=skipped]" {
+		t.Fatalf("res=%+v calls=%v", res, writer.calls)
+	}
+	if res, _ := svc.Sync(ctx, "imap", ""); res != (WritebackResult{}) || len(writer.calls) != 2 {
+		t.Fatalf("written again: %+v calls=%v", res, writer.calls)
+	}
+}
+
+func TestWritebackSourceGoneFailsImmediately(t *testing.T) {
+	db, err := storage.Open(filepath.Join(t.TempDir(), "app.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	seedFinishedEmail(t, db, "<a>", "ref-a", "exported", internal.MatchOK)
+	writer := &fakeWriter{failures: 1, err: fmt.Errorf("%w: deleted", ErrSourceGone)}
+	svc := NewWritebackService(db, writer, 5)
+
+	res, err := svc.Sync(context.Background(), "imap", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res != (WritebackResult{Failed: 1}) {
+		t.Fatalf("res=%+v", res)
+	}
+	res, err = svc.Sync(context.Background(), "imap", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res != (WritebackResult{}) || len(writer.calls) != 1 {
+		t.Fatalf("retried a gone source: %+v calls=%v", res, writer.calls)
+	}
+}
@@ -2,15 +2,22 @@ package listener
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"elcom/internal/config"
 	"elcom/internal/connectors"
+	fileconnector "elcom/internal/connectors/file"
 	gmailconnector "elcom/internal/connectors/gmail"
+	graphconnector "elcom/internal/connectors/graph"
 	imapconnector "elcom/internal/connectors/imap"
+	pop3connector "elcom/internal/connectors/pop3"
+	"elcom/internal/connectors/smtpd"
+	"elcom/internal/outbound"
 	"elcom/internal/pipeline"
 	"elcom/internal/storage"
 )
@@ -18,89 +25,388 @@ import (
 type Service struct {
 	db  *storage.DB
 	cfg config.Config
+
+	// mu serialises processing, export and outcome write-back between the
+	// mailbox loops and the inbound SMTP trigger.
+	mu sync.Mutex
 }
 
 func NewService(db *storage.DB, cfg config.Config) *Service {
 	return &Service{db: db, cfg: cfg}
 }
 
+const (
+	idleBackoffMin = time.Second
+	idleBackoffMax = 5 * time.Minute
+)
+
+// mailboxRunner drives one configured mailbox. cfg is the global config with
+// the mailbox overrides applied.
+type mailboxRunner struct {
+	svc      *Service
+	name     string
+	provider string
+	labels   []string
+	cfg      config.Config
+}
+
+// Run starts one goroutine per configured mailbox and blocks until ctx is
+// done. A mailbox that fails, even by panicking, is logged and stopped
+// without affecting the others.
 func (s *Service) Run(ctx context.Context) error {
+	mailboxes, err := s.cfg.Mailboxes()
+	if err != nil {
+		return err
+	}
+
+	var (
+		wg      sync.WaitGroup
+		errMu   sync.Mutex
+		errs    []error
+		inbound bool
+	)
+	start := func(name string, run func() error) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := runIsolated(run)
+			if err == nil || ctx.Err() != nil {
+				return
+			}
+			fmt.Printf("listener mailbox=%s stopped: %v\n", name, err)
+			errMu.Lock()
+			errs = append(errs, fmt.Errorf("mailbox %s: %w", name, err))
+			errMu.Unlock()
+		}()
+	}
+
+	for _, mb := range mailboxes {
+		m := s.newRunner(mb)
+		if m.provider == smtpd.Provider {
+			inbound = true
+			start(m.logName(), func() error { return s.runInbound(ctx, m.cfg, m.name) })
+			continue
+		}
+		start(m.logName(), func() error { return m.run(ctx) })
+	}
+	if s.cfg.SMTPInboundEnabled && !inbound {
+		start(smtpd.Provider, func() error { return s.runInbound(ctx, s.cfg, "") })
+	}
+
+	wg.Wait()
+	if ctx.Err() != nil {
+		return nil
+	}
+	return errors.Join(errs...)
+}
+
+func (s *Service) newRunner(mb config.Mailbox) *mailboxRunner {
+	cfg := s.cfg.ForMailbox(mb)
+	return &mailboxRunner{
+		svc:      s,
+		name:     mb.Name,
+		provider: strings.ToLower(strings.TrimSpace(cfg.MailListenerProvider)),
+		labels:   mb.LabelsOrDefault(s.cfg),
+		cfg:      cfg,
+	}
+}
+
+// logName is the mailbox name for log lines; the unnamed legacy mailbox
+// shows as "default".
+func (m *mailboxRunner) logName() string {
+	if m.name == "" {
+		return "default"
+	}
+	return m.name
+}
+
+// runIsolated turns a panic into an error so one broken mailbox cannot take
+// the process down.
+func runIsolated(run func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return run()
+}
+
+func (m *mailboxRunner) run(ctx context.Context) error {
+	mode := strings.ToLower(strings.TrimSpace(m.cfg.MailListenerMode))
+	if mode == "idle" {
+		return m.runIdle(ctx)
+	}
+	return m.runPoll(ctx)
+}
+
+func (m *mailboxRunner) runPoll(ctx context.Context) error {
 	for {
-		if err := s.runCycle(ctx); err != nil {
-			fmt.Printf("listener cycle error: %v\n", err)
+		if err := m.runCycle(ctx); err != nil {
+			fmt.Printf("listener cycle error mailbox=%s: %v\n", m.logName(), err)
 		}
 
 		select {
 		case <-ctx.Done():
 			return nil
-		case <-time.After(time.Duration(s.cfg.MailListenerIntervalSec) * time.Second):
+		case <-time.After(time.Duration(m.cfg.MailListenerIntervalSec) * time.Second):
 		}
 	}
 }
 
-func (s *Service) runCycle(ctx context.Context) error {
-	provider := strings.ToLower(strings.TrimSpace(s.cfg.MailListenerProvider))
-	mailConnector, err := s.makeConnector(provider)
+// runIdle keeps one IMAP IDLE session per label and runs a cycle for that
+// label on every new message. Servers without IDLE fall back to polling.
+func (m *mailboxRunner) runIdle(ctx context.Context) error {
+	if m.provider != "imap" {
+		fmt.Printf("listener idle mode needs provider=imap, got %s for mailbox=%s; polling instead\n", m.provider, m.logName())
+		return m.runPoll(ctx)
+	}
+
+	errs := make(chan error, len(m.labels))
+	for _, label := range m.labels {
+		go func() { errs <- m.watchLabel(ctx, label) }()
+	}
+	var first error
+	for range m.labels {
+		if err := <-errs; err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// watchLabel reconnects the IDLE session with exponential backoff.
+func (m *mailboxRunner) watchLabel(ctx context.Context, label string) error {
+	conn, err := imapconnector.NewConnector(m.cfg, m.svc.db)
 	if err != nil {
 		return err
 	}
 
-	fetchService := connectors.NewFetchService(s.db, s.cfg.RawMailDir, mailConnector)
-	fetchResult, err := fetchService.FetchAndStore(s.cfg.MailListenerLabel, s.cfg.MailListenerFetchMax)
+	backoff := idleBackoffMin
+	for {
+		started := time.Now()
+		err := conn.Watch(ctx, label, func() {
+			if err := m.runCycleWith(ctx, conn, []string{label}); err != nil {
+				fmt.Printf("listener cycle error mailbox=%s: %v\n", m.logName(), err)
+			}
+		})
+		if ctx.Err() != nil {
+			return nil
+		}
+		if errors.Is(err, imapconnector.ErrIdleUnsupported) {
+			fmt.Printf("listener: server of mailbox=%s has no IDLE support, polling instead\n", m.logName())
+			return m.pollLabel(ctx, conn, label)
+		}
+		if time.Since(started) > idleBackoffMax {
+			backoff = idleBackoffMin
+		}
+		fmt.Printf("listener idle session error mailbox=%s label=%s: %v (reconnect in %s)\n", m.logName(), label, err, backoff)
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > idleBackoffMax {
+			backoff = idleBackoffMax
+		}
+	}
+}
+
+func (m *mailboxRunner) pollLabel(ctx context.Context, conn connectors.MailConnector, label string) error {
+	for {
+		if err := m.runCycleWith(ctx, conn, []string{label}); err != nil {
+			fmt.Printf("listener cycle error mailbox=%s: %v\n", m.logName(), err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(time.Duration(m.cfg.MailListenerIntervalSec) * time.Second):
+		}
+	}
+}
+
+// runInbound serves the embedded SMTP receiver and processes received mail
+// as soon as it is stored, tagged with mailbox. Bursts of deliveries collapse
+// into one pass.
+func (s *Service) runInbound(ctx context.Context, cfg config.Config, mailbox string) error {
+	trigger := make(chan struct{}, 1)
+	server, err := smtpd.NewServer(cfg, s.db, mailbox, func() {
+		select {
+		case trigger <- struct{}{}:
+		default:
+		}
+	})
 	if err != nil {
 		return err
 	}
 
-	processor := pipeline.NewProcessingService(s.db, s.cfg)
-	processedEmails, _, err := processor.ProcessPending(s.cfg.MailListenerProcessBatch, provider)
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-trigger:
+				processed, err := s.processAndExport(cfg, smtpd.Provider, mailbox)
+				if err != nil {
+					fmt.Printf("listener smtp cycle error: %v\n", err)
+					continue
+				}
+				fmt.Printf("listener smtp cycle done processed=%d\n", processed)
+			}
+		}
+	}()
+
+	fmt.Printf("listener smtp inbound on %s lmtp=%t\n", cfg.SMTPInboundAddr, cfg.SMTPInboundLMTP)
+	return server.ListenAndServe(ctx)
+}
+
+func (m *mailboxRunner) runCycle(ctx context.Context) error {
+	mailConnector, err := makeConnector(m.cfg, m.svc.db, m.provider)
 	if err != nil {
 		return err
 	}
+	return m.runCycleWith(ctx, mailConnector, m.labels)
+}
 
-	if s.cfg.MailListenerAutoExport {
-		if err := s.exportProcessed(provider); err != nil {
-			return err
+func (m *mailboxRunner) runCycleWith(ctx context.Context, mailConnector connectors.MailConnector, labels []string) error {
+	fetchService := connectors.NewFetchService(m.svc.db, m.cfg.RawMailDir, m.name, mailConnector)
+	var fetched, stored int
+	for _, label := range labels {
+		fetchResult, err := fetchService.FetchAndStore(ctx, label, m.cfg.MailListenerFetchMax)
+		fetched += fetchResult.Fetched
+		stored += fetchResult.Stored
+		if err != nil {
+			return fmt.Errorf("fetch interrupted mailbox=%s label=%s fetched=%d stored=%d: %w", m.logName(), label, fetchResult.Fetched, fetchResult.Stored, err)
 		}
 	}
 
-	fmt.Printf("listener cycle done provider=%s fetched=%d stored=%d processed=%d\n", provider, fetchResult.Fetched, fetchResult.Stored, processedEmails)
-	_ = ctx
+	processedEmails, err := m.svc.processAndExport(m.cfg, m.provider, m.name)
+	if err != nil {
+		return err
+	}
+	m.writeBack(ctx, mailConnector)
+
+	fmt.Printf("listener cycle done mailbox=%s provider=%s fetched=%d stored=%d processed=%d\n", m.logName(), m.provider, fetched, stored, processedEmails)
 	return nil
 }
 
-func (s *Service) exportProcessed(provider string) error {
-	emails, err := s.db.ListEmailsByStatus("processed", 200)
+// processAndExport runs the pipeline with cfg, so per-mailbox thresholds
+// apply, over the pending emails of provider and mailbox. Replies to the
+// exported emails are sent after the service mutex is released, so a slow
+// relay does not hold up the other mailboxes.
+func (s *Service) processAndExport(cfg config.Config, provider, mailbox string) (int, error) {
+	processedEmails, exported, err := s.processLocked(cfg, provider, mailbox)
+	if cfg.MailListenerAutoReply {
+		s.replyExported(cfg, exported)
+	}
+	return processedEmails, err
+}
+
+// processLocked processes and exports under the service mutex and returns
+// the ids of the emails exported, also when a later export failed.
+func (s *Service) processLocked(cfg config.Config, provider, mailbox string) (int, []int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	processor := pipeline.NewProcessingService(s.db, cfg)
+	processedEmails, _, err := processor.ProcessPending(cfg.MailListenerProcessBatch, provider, mailbox)
 	if err != nil {
-		return err
+		return 0, nil, err
+	}
+	if !cfg.MailListenerAutoExport {
+		return processedEmails, nil, nil
 	}
+	exported, err := s.exportProcessed(cfg, provider, mailbox)
+	return processedEmails, exported, err
+}
 
-	for _, email := range emails {
-		if email.Provider != provider {
+// writeBack mirrors outcomes into the source mailbox when enabled and the
+// connector supports it. Failed writes are retried by later cycles. It holds
+// the service mutex: in idle mode every label has its own watcher, and they
+// would otherwise write back the same candidates of the mailbox concurrently.
+func (m *mailboxRunner) writeBack(ctx context.Context, mailConnector connectors.MailConnector) {
+	if !m.cfg.OutcomeWriteback {
+		return
+	}
+	writer, ok := mailConnector.(connectors.OutcomeWriter)
+	if !ok {
+		return
+	}
+	m.svc.mu.Lock()
+	defer m.svc.mu.Unlock()
+
+	res, err := connectors.NewWritebackService(m.svc.db, writer, m.cfg.OutcomeWritebackMaxAttempts).Sync(ctx, m.provider, m.name)
+	if err != nil {
+		fmt.Printf("listener writeback error mailbox=%s: %v\n", m.logName(), err)
+		return
+	}
+	if res.Written+res.Failed+res.Pending > 0 {
+		fmt.Printf("listener writeback mailbox=%s written=%d pending=%d failed=%d\n", m.logName(), res.Written, res.Pending, res.Failed)
+	}
+}
+
+// replyExported answers freshly exported emails. Failures are recorded in the
+// replies table and logged; they never fail the cycle.
+func (s *Service) replyExported(cfg config.Config, emailIDs []int) {
+	if len(emailIDs) == 0 {
+		return
+	}
+	replies, err := outbound.NewReplyService(cfg, s.db)
+	if err != nil {
+		fmt.Printf("listener reply disabled: %v\n", err)
+		return
+	}
+	for _, id := range emailIDs {
+		res, err := replies.Reply(id, false)
+		if err != nil {
+			fmt.Printf("listener reply error emailId=%d: %v\n", id, err)
 			continue
 		}
+		fmt.Printf("listener reply emailId=%d status=%s to=%s\n", id, res.Status, res.Recipient)
+	}
+}
+
+func (s *Service) exportProcessed(cfg config.Config, provider, mailbox string) ([]int, error) {
+	emails, err := s.db.ListEmailsByStatus("processed", provider, mailbox, 200)
+	if err != nil {
+		return nil, err
+	}
+
+	var exported []int
+
+	for _, email := range emails {
 		rows, err := s.db.GetExportRows(email.ID)
 		if err != nil {
-			return err
+			return exported, err
 		}
 		if len(rows) == 0 {
 			continue
 		}
 		filename := fmt.Sprintf("%d_%s.xlsx", email.ID, sanitizeMessageID(email.MessageID))
-		outputPath := filepath.Join(s.cfg.OutputDir, "listener", filename)
+		outputPath := filepath.Join(cfg.OutputDir, "listener", filename)
 		if err := pipeline.ExportRowsToXLSX(rows, outputPath); err != nil {
-			return err
+			return exported, err
 		}
 		_ = s.db.UpdateEmailStatus(email.ID, "exported")
+		exported = append(exported, email.ID)
 	}
-	return nil
+	return exported, nil
 }
 
-func (s *Service) makeConnector(provider string) (connectors.MailConnector, error) {
+func makeConnector(cfg config.Config, db *storage.DB, provider string) (connectors.MailConnector, error) {
 	switch provider {
 	case "gmail":
-		return gmailconnector.NewConnector(s.cfg)
+		return gmailconnector.NewConnector(cfg, db)
 	case "imap":
-		return imapconnector.NewConnector(s.cfg)
+		return imapconnector.NewConnector(cfg, db)
+	case graphconnector.Provider:
+		return graphconnector.NewConnector(cfg, db)
+	case pop3connector.Provider:
+		return pop3connector.NewConnector(cfg, db)
+	case "file":
+		return fileconnector.NewConnector(cfg, db)
 	default:
 		return nil, fmt.Errorf("unsupported listener provider: %s", provider)
 	}
@@ -0,0 +1,140 @@
+package listener
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"elcom/internal/config"
+	"elcom/internal/connectors"
+	fileconnector "elcom/internal/connectors/file"
+	"elcom/internal/storage"
+)
+
+const quoteEML = "From: customer@example.com\r\nSubject: =?UTF-8?B?0JfQsNGP0LLQutCw?=\r\nDate: Sun, 08 Feb 2026 10:00:00 +0300\r\nMessage-ID: <%s@example.com>\r\nContent-Type: text/plain; charset=utf-8\r\n\r\nПросьба выставить КП:\r\nКабель ВВГнг 3x2.5 10 шт\r\nПровод ПВС 2x1.5 5 м\r\n"
+
+func writeEML(t *testing.T, dir, id string) {
+	t.Helper()
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, id+".eml"), []byte(fmt.Sprintf(quoteEML, id)), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// newTestService returns a listener over a fresh database. Non-nil mailboxes
+// are written to a mailboxes file, as MAIL_LISTENER_MAILBOXES_FILE would be.
+func newTestService(t *testing.T, mailboxes []config.Mailbox) (*Service, string) {
+	t.Helper()
+	tmp := t.TempDir()
+	db, err := storage.Open(filepath.Join(tmp, "app.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+
+	cfg, _ := config.Load()
+	cfg.RawMailDir = filepath.Join(tmp, "raw")
+	cfg.OutputDir = filepath.Join(tmp, "out")
+	cfg.IMAPHost, cfg.IMAPUser, cfg.IMAPPassword = "", "", ""
+	cfg.MailListenerAutoExport = true
+	cfg.MailListenerAutoReply = false
+	cfg.OutcomeWriteback = false
+	cfg.SMTPInboundEnabled = false
+	if mailboxes != nil {
+		blob, _ := json.Marshal(mailboxes)
+		cfg.MailListenerMailboxesFile = filepath.Join(tmp, "mailboxes.json")
+		if err := os.WriteFile(cfg.MailListenerMailboxesFile, blob, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return NewService(db, cfg), tmp
+}
+
+func TestRunKeepsHealthyMailboxesGoing(t *testing.T) {
+	tmp := t.TempDir()
+	writeEML(t, filepath.Join(tmp, "good"), "good")
+	s, _ := newTestService(t, []config.Mailbox{
+		{Name: "good", Provider: "file", FileMailRoot: filepath.Join(tmp, "good"), IntervalSec: 1},
+		// No IMAP credentials: the idle watcher fails at once.
+		{Name: "broken", Provider: "imap", Mode: "idle"},
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- s.Run(ctx) }()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		email, err := s.db.GetEmailByProviderMessageID("file", "<good@example.com>")
+		if err == nil && email != nil && email.Status == "exported" {
+			if email.Mailbox != "good" {
+				t.Fatalf("mailbox=%q", email.Mailbox)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("good mailbox not exported: %+v err=%v", email, err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("Run after cancel: %v", err)
+	}
+}
+
+func TestRunReportsStoppedMailboxes(t *testing.T) {
+	s, _ := newTestService(t, []config.Mailbox{{Name: "broken", Provider: "imap", Mode: "idle"}})
+
+	err := s.Run(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "mailbox broken") || !strings.Contains(err.Error(), "IMAP_HOST") {
+		t.Fatalf("err=%v", err)
+	}
+}
+
+func TestMailboxesAreTaggedAndProcessedSeparately(t *testing.T) {
+	s, tmp := newTestService(t, nil)
+	for _, name := range []string{"sales", "supply"} {
+		writeEML(t, filepath.Join(tmp, name), name)
+		cfg := s.cfg.ForMailbox(config.Mailbox{Name: name, Provider: "file", FileMailRoot: filepath.Join(tmp, name)})
+		conn, err := fileconnector.NewConnector(cfg, s.db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := connectors.NewFetchService(s.db, cfg.RawMailDir, name, conn).FetchAndStore(context.Background(), "INBOX", 10); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A threshold above any score skips every email of that mailbox only.
+	strict := s.cfg.ForMailbox(config.Mailbox{Name: "supply", QuoteDetectThreshold: 2})
+	if _, err := s.processAndExport(strict, "file", "supply"); err != nil {
+		t.Fatal(err)
+	}
+	sales := s.cfg
+	sales.OutputDir = filepath.Join(tmp, "sales-out")
+	if _, err := s.processAndExport(sales, "file", "sales"); err != nil {
+		t.Fatal(err)
+	}
+	if files, _ := filepath.Glob(filepath.Join(sales.OutputDir, "listener", "*.xlsx")); len(files) != 1 {
+		t.Fatalf("sales exports=%v", files)
+	}
+
+	for name, status := range map[string]string{"sales": "exported", "supply": "skipped"} {
+		email, err := s.db.MustEmailByProviderMessageID("file", "<"+name+"@example.com>")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if email.Mailbox != name || email.Status != status {
+			t.Fatalf("%s: mailbox=%s status=%s", name, email.Mailbox, email.Status)
+		}
+	}
+}
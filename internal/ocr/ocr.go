@@ -0,0 +1,158 @@
+package ocr
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"elcom/internal/config"
+)
+
+// Line is one recognised text line with the engine's confidence, 0..100.
+type Line struct {
+	Text       string
+	Confidence float64
+}
+
+// Engine recognises text in scans. PDFPage is called for pages of a PDF
+// that have no text layer; page numbers start at 1.
+type Engine interface {
+	Image(image []byte) ([]Line, error)
+	PDFPage(pdf []byte, page int) ([]Line, error)
+}
+
+// Tesseract runs the tesseract CLI, and pdftoppm from poppler-utils to
+// render PDF pages, as subprocesses.
+type Tesseract struct {
+	Path         string
+	PDFToPPMPath string
+	Languages    string
+	Timeout      time.Duration
+}
+
+func NewTesseract(cfg config.Config) *Tesseract {
+	return &Tesseract{
+		Path:         cfg.OCRTesseractPath,
+		PDFToPPMPath: cfg.OCRPDFToPPMPath,
+		Languages:    cfg.OCRLanguages,
+		Timeout:      time.Duration(cfg.OCRTimeoutSec) * time.Second,
+	}
+}
+
+func (t *Tesseract) Image(image []byte) ([]Line, error) {
+	ctx, cancel := t.context()
+	defer cancel()
+	args := []string{"stdin", "stdout"}
+	if t.Languages != "" {
+		args = append(args, "-l", t.Languages)
+	}
+	args = append(args, "tsv")
+	out, err := run(ctx, t.Path, image, args...)
+	if err != nil {
+		return nil, err
+	}
+	return parseTSV(out)
+}
+
+func (t *Tesseract) PDFPage(pdf []byte, page int) ([]Line, error) {
+	dir, err := os.MkdirTemp("", "elcom-ocr-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	in := filepath.Join(dir, "in.pdf")
+	if err := os.WriteFile(in, pdf, 0o600); err != nil {
+		return nil, err
+	}
+	ctx, cancel := t.context()
+	n := strconv.Itoa(page)
+	_, err = run(ctx, t.PDFToPPMPath, nil, "-f", n, "-l", n, "-r", "300", "-png", "-singlefile", in, filepath.Join(dir, "page"))
+	cancel()
+	if err != nil {
+		return nil, err
+	}
+	image, err := os.ReadFile(filepath.Join(dir, "page.png"))
+	if err != nil {
+		return nil, err
+	}
+	return t.Image(image)
+}
+
+func (t *Tesseract) context() (context.Context, context.CancelFunc) {
+	if t.Timeout <= 0 {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithTimeout(context.Background(), t.Timeout)
+}
+
+func run(ctx context.Context, path string, stdin []byte, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, path, args...)
+	if stdin != nil {
+		cmd.Stdin = bytes.NewReader(stdin)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			return nil, fmt.Errorf("%s: %w", filepath.Base(path), err)
+		}
+		return nil, fmt.Errorf("%s: %w: %s", filepath.Base(path), err, msg)
+	}
+	return stdout.Bytes(), nil
+}
+
+// parseTSV joins the words of tesseract's tsv output into lines. A line's
+// confidence is the mean of its words'; layout rows carry -1 and are left
+// out.
+func parseTSV(out []byte) ([]Line, error) {
+	rows := strings.Split(strings.ReplaceAll(string(out), "\r\n", "\n"), "\n")
+	if len(rows) == 0 || !strings.HasPrefix(rows[0], "level\t") {
+		return nil, errors.New("tesseract: unexpected tsv output")
+	}
+
+	type key struct{ page, block, par, line string }
+	var (
+		lines []Line
+		cur   key
+		words []string
+		sum   float64
+	)
+	flush := func() {
+		if len(words) > 0 {
+			lines = append(lines, Line{Text: strings.Join(words, " "), Confidence: sum / float64(len(words))})
+		}
+		words, sum = nil, 0
+	}
+	for _, row := range rows[1:] {
+		f := strings.Split(row, "\t")
+		if len(f) < 12 || f[0] != "5" {
+			continue
+		}
+		conf, err := strconv.ParseFloat(f[10], 64)
+		text := strings.TrimSpace(f[11])
+		if err != nil || conf < 0 || text == "" {
+			continue
+		}
+		k := key{f[1], f[2], f[3], f[4]}
+		if k != cur {
+			flush()
+			cur = k
+		}
+		words = append(words, text)
+		sum += conf
+	}
+	flush()
+	return lines, nil
+}
@@ -0,0 +1,67 @@
+package ocr
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const sampleTSV = "level\tpage_num\tblock_num\tpar_num\tline_num\tword_num\tleft\ttop\twidth\theight\tconf\ttext\n" +
+	"1\t1\t0\t0\t0\t0\t0\t0\t2480\t3508\t-1\t\n" +
+	"4\t1\t1\t1\t1\t0\t100\t100\t900\t40\t-1\t\n" +
+	"5\t1\t1\t1\t1\t1\t100\t100\t200\t40\t96.5\tКабель\n" +
+	"5\t1\t1\t1\t1\t2\t320\t100\t200\t40\t91.5\tВВГнг\n" +
+	"5\t1\t1\t1\t1\t3\t540\t100\t80\t40\t89\t150\n" +
+	"5\t1\t1\t1\t1\t4\t640\t100\t40\t40\t95\tм\n" +
+	"5\t1\t1\t1\t2\t1\t100\t160\t200\t40\t41\tЛамла\n" +
+	"5\t1\t1\t1\t2\t2\t320\t160\t60\t40\t-1\t \n" +
+	"5\t1\t1\t1\t2\t3\t400\t160\t60\t40\t55\t40\n"
+
+func TestParseTSV(t *testing.T) {
+	lines, err := parseTSV([]byte(sampleTSV))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lines) != 2 {
+		t.Fatalf("lines=%+v", lines)
+	}
+	if lines[0].Text != "Кабель ВВГнг 150 м" || lines[0].Confidence != 93 {
+		t.Fatalf("line 0=%+v", lines[0])
+	}
+	if lines[1].Text != "Ламла 40" || lines[1].Confidence != 48 {
+		t.Fatalf("line 1=%+v", lines[1])
+	}
+	if _, err := parseTSV([]byte("Error opening data file\n")); err == nil {
+		t.Fatal("expected error for non-tsv output")
+	}
+}
+
+func TestTesseractImageRunsSubprocess(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "tsv"), []byte(sampleTSV), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	// The fake engine checks its arguments and the image on stdin.
+	script := "#!/bin/sh\n" +
+		`[ "$1 $2 $3 $4 $5" = "stdin stdout -l rus+eng tsv" ] || { echo "bad args: $*" >&2; exit 1; }` + "\n" +
+		`[ "$(cat)" = "IMAGE" ] || { echo "bad stdin" >&2; exit 1; }` + "\n" +
+		`cat "$(dirname "$0")/tsv"` + "\n"
+	path := filepath.Join(dir, "tesseract")
+	if err := os.WriteFile(path, []byte(script), 0o700); err != nil {
+		t.Fatal(err)
+	}
+
+	engine := &Tesseract{Path: path, Languages: "rus+eng"}
+	lines, err := engine.Image([]byte("IMAGE"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lines) != 2 || lines[0].Text != "Кабель ВВГнг 150 м" {
+		t.Fatalf("lines=%+v", lines)
+	}
+
+	engine.Languages = "deu"
+	if _, err := engine.Image([]byte("IMAGE")); err == nil || err.Error() != "tesseract: exit status 1: bad args: stdin stdout -l deu tsv" {
+		t.Fatalf("err=%v", err)
+	}
+}
@@ -0,0 +1,355 @@
+package outbound
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/jhillyerd/enmime"
+
+	"elcom/internal"
+	"elcom/internal/config"
+	"elcom/internal/pipeline"
+	"elcom/internal/storage"
+)
+
+// Reply policies (REPLY_POLICY).
+const (
+	// PolicyAuto sends every reply immediately.
+	PolicyAuto = "auto"
+	// PolicyHoldOnReview holds replies that contain REVIEW rows for approval.
+	PolicyHoldOnReview = "hold_on_review"
+	// PolicyHold holds every reply until it is approved.
+	PolicyHold = "hold"
+)
+
+// Reply statuses stored in the replies table.
+const (
+	StatusHeld   = "held"
+	StatusSent   = "sent"
+	StatusFailed = "failed"
+	// StatusSuppressed marks originals that were themselves sent
+	// automatically; answering them risks a mail loop.
+	StatusSuppressed = "suppressed"
+)
+
+const xlsxContentType = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+
+const defaultTemplate = `Здравствуйте!
+
+Во вложении подбор по вашему запросу «{{.Subject}}».
+
+Позиций в запросе: {{.Summary.Total}}
+Подобрано: {{.Summary.OK}}
+Требует уточнения: {{.Summary.Review}}
+Не найдено: {{.Summary.NotFound}}
+`
+
+// Summary counts export rows by match status.
+type Summary struct {
+	Total    int
+	OK       int
+	Review   int
+	NotFound int
+}
+
+type ReplyResult struct {
+	EmailID   int
+	Status    string
+	Recipient string
+	Summary   Summary
+}
+
+// ReplyService answers a processed email with its quote workbook, threaded
+// under the original message.
+type ReplyService struct {
+	db     *storage.DB
+	cfg    config.Config
+	sender enmime.Sender
+	body   *template.Template
+}
+
+func NewReplyService(cfg config.Config, db *storage.DB) (*ReplyService, error) {
+	if err := cfg.Require("SMTP_OUT_HOST", cfg.SMTPOutHost); err != nil {
+		return nil, err
+	}
+	if err := cfg.Require("SMTP_OUT_FROM", cfg.SMTPOutFrom); err != nil {
+		return nil, err
+	}
+	switch cfg.ReplyPolicy {
+	case PolicyAuto, PolicyHoldOnReview, PolicyHold:
+	default:
+		return nil, fmt.Errorf("unsupported REPLY_POLICY: %s", cfg.ReplyPolicy)
+	}
+
+	text := defaultTemplate
+	if cfg.ReplyTemplatePath != "" {
+		b, err := os.ReadFile(cfg.ReplyTemplatePath)
+		if err != nil {
+			return nil, err
+		}
+		text = string(b)
+	}
+	body, err := template.New("reply").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("parse reply template: %w", err)
+	}
+
+	// net/smtp upgrades with STARTTLS whenever the server offers it and
+	// refuses PLAIN auth on an unencrypted remote connection.
+	var auth smtp.Auth
+	if cfg.SMTPOutUser != "" {
+		auth = smtp.PlainAuth("", cfg.SMTPOutUser, cfg.SMTPOutPassword, cfg.SMTPOutHost)
+	}
+	addr := net.JoinHostPort(cfg.SMTPOutHost, strconv.Itoa(cfg.SMTPOutPort))
+
+	return &ReplyService{
+		db:     db,
+		cfg:    cfg,
+		sender: enmime.NewSMTP(addr, auth),
+		body:   body,
+	}, nil
+}
+
+// Reply builds and sends the reply for emailID, or records it as held when
+// the policy requires approval and as suppressed when the original was sent
+// automatically. approve bypasses both. A reply that was already sent is
+// never sent twice.
+func (s *ReplyService) Reply(emailID int, approve bool) (ReplyResult, error) {
+	result := ReplyResult{EmailID: emailID}
+
+	existing, err := s.db.GetReply(emailID)
+	if err != nil {
+		return result, err
+	}
+	if existing != nil && existing.Status == StatusSent {
+		result.Status = StatusSent
+		result.Recipient = existing.Recipient
+		return result, nil
+	}
+
+	email, err := s.db.GetEmailByID(emailID)
+	if err != nil {
+		return result, err
+	}
+	if email == nil {
+		return result, fmt.Errorf("email not found: id=%d", emailID)
+	}
+	rows, err := s.db.GetExportRows(emailID)
+	if err != nil {
+		return result, err
+	}
+	if len(rows) == 0 {
+		return result, fmt.Errorf("no export rows for emailId=%d", emailID)
+	}
+	result.Summary = summarize(rows)
+
+	raw, err := os.ReadFile(email.RawRef)
+	if err != nil {
+		return result, err
+	}
+	original, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return result, fmt.Errorf("parse original message: %w", err)
+	}
+	recipient, err := replyRecipient(original.Header, email.Sender)
+	if err != nil {
+		return result, err
+	}
+	result.Recipient = recipient.Address
+
+	originalSubject := firstNonEmpty(enmime.DecodeRFC2047(original.Header.Get("Subject")), email.Subject)
+	subject := replySubject(originalSubject)
+	reply := internal.ReplyRow{
+		EmailID:   emailID,
+		Recipient: recipient.Address,
+		Subject:   subject,
+	}
+
+	if !approve && isAutomated(original.Header) {
+		reply.Status = StatusSuppressed
+		result.Status = StatusSuppressed
+		return result, s.db.UpsertReply(reply)
+	}
+	if !approve && s.mustHold(result.Summary) {
+		reply.Status = StatusHeld
+		result.Status = StatusHeld
+		return result, s.db.UpsertReply(reply)
+	}
+
+	attachmentName := fmt.Sprintf("quote_%d.xlsx", emailID)
+	attachmentPath := filepath.Join(s.cfg.OutputDir, "replies", attachmentName)
+	if err := pipeline.ExportRowsToXLSX(rows, attachmentPath); err != nil {
+		return result, err
+	}
+	attachment, err := os.ReadFile(attachmentPath)
+	if err != nil {
+		return result, err
+	}
+	reply.AttachmentRef = attachmentPath
+
+	var body bytes.Buffer
+	data := struct {
+		Subject string
+		Sender  string
+		Summary Summary
+	}{Subject: originalSubject, Sender: recipient.String(), Summary: result.Summary}
+	if err := s.body.Execute(&body, data); err != nil {
+		return result, fmt.Errorf("render reply body: %w", err)
+	}
+
+	reply.MessageID = newMessageID(s.cfg.SMTPOutFrom)
+	builder := enmime.Builder().
+		From(s.cfg.SMTPOutFromName, s.cfg.SMTPOutFrom).
+		ToAddrs([]mail.Address{*recipient}).
+		Subject(subject).
+		Header("Message-ID", reply.MessageID).
+		Header("Auto-Submitted", "auto-replied").
+		Text(body.Bytes()).
+		AddAttachment(attachment, xlsxContentType, attachmentName)
+	if parent := strings.TrimSpace(original.Header.Get("Message-ID")); parent != "" {
+		builder = builder.
+			Header("In-Reply-To", parent).
+			Header("References", references(original.Header.Get("References"), parent))
+	}
+
+	if err := builder.Send(s.sender); err != nil {
+		reply.Status = StatusFailed
+		reply.Error = err.Error()
+		if dbErr := s.db.UpsertReply(reply); dbErr != nil {
+			return result, errors.Join(err, dbErr)
+		}
+		return result, fmt.Errorf("send reply emailId=%d: %w", emailID, err)
+	}
+
+	reply.Status = StatusSent
+	reply.SentAt = time.Now().UTC().Format(time.RFC3339)
+	result.Status = StatusSent
+	return result, s.db.UpsertReply(reply)
+}
+
+// RetryFailed sends the replies whose last attempt failed again. They were
+// already cleared by the policy, so they are not held a second time.
+func (s *ReplyService) RetryFailed(limit int) ([]ReplyResult, error) {
+	failed, err := s.db.ListRepliesByStatus(StatusFailed, limit)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		results []ReplyResult
+		errs    []error
+	)
+	for _, r := range failed {
+		res, err := s.Reply(r.EmailID, true)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		results = append(results, res)
+	}
+	return results, errors.Join(errs...)
+}
+
+// isAutomated reports mail that must not be answered automatically: any
+// Auto-Submitted value but "no" (RFC 3834 2) and bulk or list precedence.
+func isAutomated(header mail.Header) bool {
+	autoSubmitted, _, _ := strings.Cut(header.Get("Auto-Submitted"), ";")
+	if v := strings.ToLower(strings.TrimSpace(autoSubmitted)); v != "" && v != "no" {
+		return true
+	}
+	switch strings.ToLower(strings.TrimSpace(header.Get("Precedence"))) {
+	case "bulk", "junk", "list":
+		return true
+	}
+	return false
+}
+
+func (s *ReplyService) mustHold(summary Summary) bool {
+	switch s.cfg.ReplyPolicy {
+	case PolicyAuto:
+		return false
+	case PolicyHoldOnReview:
+		return summary.Review > 0
+	default:
+		return true
+	}
+}
+
+func summarize(rows []internal.MatchExportRow) Summary {
+	summary := Summary{Total: len(rows)}
+	for _, row := range rows {
+		switch internal.MatchStatus(row.MatchStatus) {
+		case internal.MatchOK:
+			summary.OK++
+		case internal.MatchReview:
+			summary.Review++
+		case internal.MatchNotFound:
+			summary.NotFound++
+		}
+	}
+	return summary
+}
+
+// replyRecipient honours Reply-To before From and falls back to the sender
+// recorded at fetch time.
+func replyRecipient(header mail.Header, storedSender string) (*mail.Address, error) {
+	for _, name := range []string{"Reply-To", "From"} {
+		if list, err := header.AddressList(name); err == nil && len(list) > 0 {
+			return list[0], nil
+		}
+	}
+	if addr, err := mail.ParseAddress(storedSender); err == nil {
+		return addr, nil
+	}
+	return nil, errors.New("original message has no usable sender address")
+}
+
+func replySubject(subject string) string {
+	subject = strings.TrimSpace(subject)
+	lower := strings.ToLower(subject)
+	if strings.HasPrefix(lower, "re:") || strings.HasPrefix(lower, "re ") {
+		return subject
+	}
+	return "Re: " + subject
+}
+
+// references appends the parent Message-ID to its References chain (RFC 5322 3.6.4).
+func references(existing, parent string) string {
+	fields := strings.Fields(existing)
+	for _, id := range fields {
+		if id == parent {
+			return strings.Join(fields, " ")
+		}
+	}
+	return strings.Join(append(fields, parent), " ")
+}
+
+func newMessageID(from string) string {
+	domain := "localhost"
+	if at := strings.LastIndex(from, "@"); at >= 0 && at < len(from)-1 {
+		domain = from[at+1:]
+	}
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return fmt.Sprintf("<%d.%s@%s>", time.Now().UnixNano(), hex.EncodeToString(b), domain)
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if strings.TrimSpace(v) != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,303 @@
+package outbound
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"net/mail"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/emersion/go-smtp"
+	"github.com/jhillyerd/enmime"
+
+	"elcom/internal"
+	"elcom/internal/config"
+	"elcom/internal/connectors"
+	"elcom/internal/storage"
+	"elcom/internal/util"
+)
+
+const requestEML = "From: Ivan <ivan@customer.test>\r\nSubject: =?UTF-8?B?0JfQsNGP0LLQutCw?=\r\nMessage-ID: <req-2@customer.test>\r\nReferences: <req-1@customer.test>\r\nContent-Type: text/plain; charset=utf-8\r\n\r\nКабель ВВГнг 3x2.5 10 шт\r\n"
+
+// sink is a local SMTP server that keeps every delivered message.
+type sink struct {
+	mu       sync.Mutex
+	messages [][]byte
+	rcpts    []string
+}
+
+func (s *sink) NewSession(_ *smtp.Conn) (smtp.Session, error) { return &sinkSession{sink: s}, nil }
+
+type sinkSession struct{ sink *sink }
+
+func (s *sinkSession) Mail(string, *smtp.MailOptions) error { return nil }
+func (s *sinkSession) Rcpt(to string, _ *smtp.RcptOptions) error {
+	s.sink.mu.Lock()
+	defer s.sink.mu.Unlock()
+	s.sink.rcpts = append(s.sink.rcpts, to)
+	return nil
+}
+func (s *sinkSession) Data(r io.Reader) error {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	s.sink.mu.Lock()
+	defer s.sink.mu.Unlock()
+	s.sink.messages = append(s.sink.messages, b)
+	return nil
+}
+func (s *sinkSession) Reset()        {}
+func (s *sinkSession) Logout() error { return nil }
+
+func (s *sink) received() [][]byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([][]byte(nil), s.messages...)
+}
+
+func startSink(t *testing.T) (*sink, string, int) {
+	t.Helper()
+	backend := &sink{}
+	srv := smtp.NewServer(backend)
+	srv.Domain = "localhost"
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() { _ = srv.Serve(ln) }()
+	t.Cleanup(func() { _ = srv.Shutdown(context.Background()) })
+
+	host, port, _ := net.SplitHostPort(ln.Addr().String())
+	portNum, _ := strconv.Atoi(port)
+	return backend, host, portNum
+}
+
+// seedEmail stores requestEML with one OK and one REVIEW match.
+func seedEmail(t *testing.T, db *storage.DB, cfg config.Config) int {
+	t.Helper()
+	return seedRawEmail(t, db, cfg, requestEML)
+}
+
+func seedRawEmail(t *testing.T, db *storage.DB, cfg config.Config, raw string) int {
+	t.Helper()
+	store := connectors.NewMailStoreService(db, cfg.RawMailDir)
+	email, err := store.Store(internal.FetchedMailMessage{
+		Provider:  "file",
+		MessageID: "<req-2@customer.test>",
+		Subject:   "Заявка",
+		From:      "Ivan <ivan@customer.test>",
+		Raw:       []byte(raw),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, status := range []internal.MatchStatus{internal.MatchOK, internal.MatchReview} {
+		id, err := db.InsertExtraction(email.ID, internal.ExtractionItem{
+			LineNo:     i + 1,
+			Source:     internal.SourceEmailText,
+			RawLine:    "Кабель ВВГнг 3x2.5 10 шт " + string(status),
+			NameOrCode: util.StringPtr("Кабель ВВГнг 3x2.5"),
+			Qty:        util.FloatPtr(10),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := db.InsertMatch(id, internal.MatchResult{Status: status, Confidence: 0.8, Reason: internal.ReasonFuzzy}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return email.ID
+}
+
+func TestReplyHoldOnReviewThenApprove(t *testing.T) {
+	box, host, port := startSink(t)
+	dir := t.TempDir()
+	cfg := config.Config{
+		RawMailDir:  filepath.Join(dir, "raw"),
+		OutputDir:   filepath.Join(dir, "out"),
+		SMTPOutHost: host,
+		SMTPOutPort: port,
+		SMTPOutFrom: "quotes@elcom.test",
+		ReplyPolicy: PolicyHoldOnReview,
+	}
+	db, err := storage.Open(filepath.Join(dir, "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	emailID := seedEmail(t, db, cfg)
+
+	svc, err := NewReplyService(cfg, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := svc.Reply(emailID, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Status != StatusHeld || res.Summary != (Summary{Total: 2, OK: 1, Review: 1}) {
+		t.Fatalf("result=%+v", res)
+	}
+	if len(box.received()) != 0 {
+		t.Fatal("held reply was sent")
+	}
+
+	res, err = svc.Reply(emailID, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Status != StatusSent || res.Recipient != "ivan@customer.test" {
+		t.Fatalf("result=%+v", res)
+	}
+	msgs := box.received()
+	if len(msgs) != 1 {
+		t.Fatalf("messages=%d", len(msgs))
+	}
+
+	env, err := enmime.ReadEnvelope(bytes.NewReader(msgs[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := env.GetHeader("In-Reply-To"); got != "<req-2@customer.test>" {
+		t.Fatalf("In-Reply-To=%q", got)
+	}
+	if got := env.GetHeader("References"); got != "<req-1@customer.test> <req-2@customer.test>" {
+		t.Fatalf("References=%q", got)
+	}
+	if got := env.GetHeader("Auto-Submitted"); got != "auto-replied" {
+		t.Fatalf("Auto-Submitted=%q", got)
+	}
+	if got := env.GetHeader("Subject"); got != "Re: Заявка" {
+		t.Fatalf("Subject=%q", got)
+	}
+	if !strings.Contains(env.Text, "Требует уточнения: 1") {
+		t.Fatalf("text=%q", env.Text)
+	}
+	if len(env.Attachments) != 1 || env.Attachments[0].FileName != "quote_"+strconv.Itoa(emailID)+".xlsx" {
+		t.Fatalf("attachments=%+v", env.Attachments)
+	}
+
+	stored, err := db.GetReply(emailID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored == nil || stored.Status != StatusSent || stored.MessageID == "" || stored.SentAt == "" {
+		t.Fatalf("stored=%+v", stored)
+	}
+
+	// A sent reply is not sent again.
+	if _, err := svc.Reply(emailID, true); err != nil {
+		t.Fatal(err)
+	}
+	if len(box.received()) != 1 {
+		t.Fatal("reply sent twice")
+	}
+}
+
+func TestReplySendFailureIsRecorded(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	host, port, _ := net.SplitHostPort(ln.Addr().String())
+	_ = ln.Close()
+	portNum, _ := strconv.Atoi(port)
+
+	dir := t.TempDir()
+	cfg := config.Config{
+		RawMailDir:  filepath.Join(dir, "raw"),
+		OutputDir:   filepath.Join(dir, "out"),
+		SMTPOutHost: host,
+		SMTPOutPort: portNum,
+		SMTPOutFrom: "quotes@elcom.test",
+		ReplyPolicy: PolicyAuto,
+	}
+	db, err := storage.Open(filepath.Join(dir, "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	emailID := seedEmail(t, db, cfg)
+
+	svc, err := NewReplyService(cfg, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := svc.Reply(emailID, false); err == nil {
+		t.Fatal("expected send error")
+	}
+	stored, err := db.GetReply(emailID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored == nil || stored.Status != StatusFailed || stored.Error == "" {
+		t.Fatalf("stored=%+v", stored)
+	}
+
+	// Once the server is reachable, the failed reply goes out on retry.
+	box, host, portNum := startSink(t)
+	cfg.SMTPOutHost, cfg.SMTPOutPort = host, portNum
+	svc, err = NewReplyService(cfg, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err := svc.RetryFailed(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Status != StatusSent || len(box.received()) != 1 {
+		t.Fatalf("results=%+v sent=%d", results, len(box.received()))
+	}
+	if failed, _ := db.ListRepliesByStatus(StatusFailed, 10); len(failed) != 0 {
+		t.Fatalf("still failed: %+v", failed)
+	}
+}
+
+func TestReplySuppressedForAutomatedMail(t *testing.T) {
+	for _, header := range []string{"Auto-Submitted: auto-replied", "Auto-Submitted: auto-generated; owner-email=\"x@customer.test\"", "Precedence: bulk", "Precedence: list"} {
+		t.Run(header, func(t *testing.T) {
+			box, host, port := startSink(t)
+			dir := t.TempDir()
+			cfg := config.Config{
+				RawMailDir:  filepath.Join(dir, "raw"),
+				OutputDir:   filepath.Join(dir, "out"),
+				SMTPOutHost: host,
+				SMTPOutPort: port,
+				SMTPOutFrom: "quotes@elcom.test",
+				ReplyPolicy: PolicyAuto,
+			}
+			db, err := storage.Open(filepath.Join(dir, "test.db"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			emailID := seedRawEmail(t, db, cfg, header+"\r\n"+requestEML)
+
+			svc, err := NewReplyService(cfg, db)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res, err := svc.Reply(emailID, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.Status != StatusSuppressed || len(box.received()) != 0 {
+				t.Fatalf("status=%s sent=%d", res.Status, len(box.received()))
+			}
+			if stored, _ := db.GetReply(emailID); stored == nil || stored.Status != StatusSuppressed {
+				t.Fatalf("stored=%+v", stored)
+			}
+		})
+	}
+
+	if isAutomated(mail.Header{"Auto-Submitted": {"no"}}) {
+		t.Fatal("Auto-Submitted: no treated as automated")
+	}
+}
@@ -10,7 +10,13 @@ type DetectResult struct {
 
 var detectKeywords = []string{"заявк", "кп", "коммерческ", "прошу", "нужно", "кол-во", "qty", "счет"}
 
-func DetectQuoteRequest(subject, text, html string, attachmentNames []string) DetectResult {
+// DefaultDetectThreshold is used when no QUOTE_DETECT_THRESHOLD is configured.
+const DefaultDetectThreshold = 0.45
+
+func DetectQuoteRequest(subject, text, html string, attachmentNames []string, threshold float64) DetectResult {
+	if threshold <= 0 {
+		threshold = DefaultDetectThreshold
+	}
 	subject = strings.ToLower(subject)
 	text = strings.ToLower(text)
 	html = strings.ToLower(html)
@@ -47,7 +53,7 @@ func DetectQuoteRequest(subject, text, html string, attachmentNames []string) De
 		score = 1
 	}
 
-	isQuote := score >= 0.45
+	isQuote := score >= threshold
 	reason := "rules_negative"
 	if isQuote {
 		reason = "rules_positive"
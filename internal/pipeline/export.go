@@ -14,8 +14,9 @@ func ExportRowsToXLSX(rows []internal.MatchExportRow, outputPath string) error {
 	sheet := f.GetSheetName(0)
 
 	headers := []string{
-		"input_line_no", "source", "raw_line", "parsed_name_or_code", "parsed_qty", "parsed_unit",
-		"match_status", "confidence", "match_reason",
+		"input_line_no", "source", "raw_line", "parsed_name_or_code", "parsed_code", "parsed_qty", "parsed_unit",
+		"converted_qty", "converted_unit", "orderable_qty",
+		"match_status", "confidence", "match_reason", "match_warnings",
 		"product_id", "product_syncUid", "product_header", "product_articul", "unitHeader",
 		"flat_elcom", "flat_manufacturer", "flat_raec", "flat_pc", "flat_etm",
 		"candidate2_header", "candidate2_score",
@@ -37,23 +38,28 @@ func ExportRowsToXLSX(rows []internal.MatchExportRow, outputPath string) error {
 		set(2, row.Source)
 		set(3, row.RawLine)
 		set(4, derefString(row.ParsedNameOrCode))
-		set(5, derefFloat(row.ParsedQty))
-		set(6, derefString(row.ParsedUnit))
-		set(7, row.MatchStatus)
-		set(8, row.Confidence)
-		set(9, row.MatchReason)
-		set(10, derefInt(row.ProductID))
-		set(11, derefString(row.ProductSyncUID))
-		set(12, derefString(row.ProductHeader))
-		set(13, derefString(row.ProductArticul))
-		set(14, derefString(row.UnitHeader))
-		set(15, derefString(row.FlatElcom))
-		set(16, derefString(row.FlatManufacturer))
-		set(17, derefString(row.FlatRaec))
-		set(18, derefString(row.FlatPC))
-		set(19, derefString(row.FlatEtm))
-		set(20, derefString(row.Candidate2Header))
-		set(21, derefFloat(row.Candidate2Score))
+		set(5, derefString(row.ParsedCode))
+		set(6, derefFloat(row.ParsedQty))
+		set(7, derefString(row.ParsedUnit))
+		set(8, derefFloat(row.ConvertedQty))
+		set(9, derefString(row.ConvertedUnit))
+		set(10, derefFloat(row.OrderableQty))
+		set(11, row.MatchStatus)
+		set(12, row.Confidence)
+		set(13, row.MatchReason)
+		set(14, row.MatchWarnings)
+		set(15, derefInt(row.ProductID))
+		set(16, derefString(row.ProductSyncUID))
+		set(17, derefString(row.ProductHeader))
+		set(18, derefString(row.ProductArticul))
+		set(19, derefString(row.UnitHeader))
+		set(20, derefString(row.FlatElcom))
+		set(21, derefString(row.FlatManufacturer))
+		set(22, derefString(row.FlatRaec))
+		set(23, derefString(row.FlatPC))
+		set(24, derefString(row.FlatEtm))
+		set(25, derefString(row.Candidate2Header))
+		set(26, derefFloat(row.Candidate2Score))
 	}
 
 	if err := os.MkdirAll(filepath.Dir(outputPath), 0o755); err != nil {
@@ -4,14 +4,17 @@ import (
 	"bytes"
 	"fmt"
 	"regexp"
+	"slices"
+	"strconv"
 	"strings"
 
 	"github.com/PuerkitoBio/goquery"
 	"github.com/jhillyerd/enmime"
-	pdf "github.com/ledongthuc/pdf"
 	"github.com/xuri/excelize/v2"
 
 	"elcom/internal"
+	"elcom/internal/config"
+	"elcom/internal/ocr"
 	"elcom/internal/util"
 )
 
@@ -24,73 +27,114 @@ var ignorePatterns = []*regexp.Regexp{
 	regexp.MustCompile(`(?i)^http`),
 }
 
-func ExtractItemsFromEmailRaw(raw []byte) ([]internal.ExtractionItem, string, string, []string, error) {
-	env, err := enmime.ReadEnvelope(bytes.NewReader(raw))
-	if err != nil {
-		return nil, "", "", nil, err
-	}
+// EmailExtraction is what ExtractItemsFromEmailRaw found in one message.
+type EmailExtraction struct {
+	Items       []internal.ExtractionItem
+	Subject     string
+	Text        string
+	Attachments []string
+	// Segments split the plain-text body into new content, signature and
+	// quoted history; only content segments are read by default.
+	Segments []BodySegment
+	// Failures lists attachments of a supported type that could not be
+	// parsed, so a skipped specification shows up in the run record.
+	Failures []AttachmentFailure
+}
+
+type AttachmentFailure struct {
+	Attachment string `json:"attachment"`
+	Error      string `json:"error"`
+}
+
+// ExtractOptions are the optional extraction stages and the limits on
+// unpacking. With a nil OCR engine, image attachments are ignored and
+// scanned PDF pages yield nothing; zero limits mean the defaults.
+type ExtractOptions struct {
+	OCR ocr.Engine
+	// MaxDepth is how many archives and forwarded messages may nest;
+	// MaxFiles and MaxUnpackedBytes cap what is unpacked from one message.
+	MaxDepth         int
+	MaxFiles         int
+	MaxUnpackedBytes int64
+	// SevenZipPath is the 7z binary used for .rar and .7z; empty skips them.
+	SevenZipPath string
+	// IncludeQuoted also reads the reply history quoted in the body.
+	IncludeQuoted bool
+}
 
-	items := make([]internal.ExtractionItem, 0)
-	if env.Text != "" {
-		items = append(items, parseEmailText(env.Text)...)
+func NewExtractOptions(cfg config.Config) ExtractOptions {
+	opts := ExtractOptions{
+		MaxDepth:         cfg.ArchiveMaxDepth,
+		MaxFiles:         cfg.ArchiveMaxFiles,
+		MaxUnpackedBytes: int64(cfg.ArchiveMaxUnpackedMB) << 20,
+		SevenZipPath:     cfg.Archive7zPath,
+		IncludeQuoted:    cfg.ExtractQuotedText,
 	}
-	if env.HTML != "" {
-		items = append(items, parseEmailHTMLTable(env.HTML)...)
+	if cfg.OCREnabled {
+		opts.OCR = ocr.NewTesseract(cfg)
 	}
+	return opts
+}
 
-	attachmentNames := make([]string, 0, len(env.Attachments))
-	for _, att := range env.Attachments {
-		filename := strings.TrimSpace(att.FileName)
-		if filename == "" {
-			filename = "attachment"
-		}
-		attachmentNames = append(attachmentNames, filename)
-		lower := strings.ToLower(filename)
-
-		if strings.HasSuffix(lower, ".xlsx") || strings.HasSuffix(lower, ".xls") {
-			extra, err := parseXLSX(att.Content)
-			if err == nil {
-				for i := range extra {
-					if extra[i].Meta == nil {
-						extra[i].Meta = map[string]any{}
-					}
-					extra[i].Meta["attachment"] = filename
-				}
-				items = append(items, extra...)
-			}
-		}
-		if strings.HasSuffix(lower, ".pdf") {
-			extra, err := parsePDF(att.Content)
-			if err == nil {
-				for i := range extra {
-					if extra[i].Meta == nil {
-						extra[i].Meta = map[string]any{}
-					}
-					extra[i].Meta["attachment"] = filename
-				}
-				items = append(items, extra...)
-			}
-		}
+func ExtractItemsFromEmailRaw(raw []byte, opts ExtractOptions) (EmailExtraction, error) {
+	env, err := enmime.ReadEnvelope(bytes.NewReader(raw))
+	if err != nil {
+		return EmailExtraction{}, err
 	}
 
-	items = dedupeItems(items)
+	res := EmailExtraction{Subject: env.GetHeader("Subject"), Text: env.Text, Attachments: []string{}}
+	w := newAttachmentWalker(opts, &res)
+	items := dedupeItems(w.envelope(env, "", 0))
 	for i := range items {
 		items[i].LineNo = i + 1
 	}
+	res.Items = items
+	return res, nil
+}
 
-	return items, env.GetHeader("Subject"), env.Text, attachmentNames, nil
+// attachmentParser picks the parser for an attachment by its extension, or
+// nil when the type is not supported. 1C and old mailers often name an
+// .xlsx ".xls" and the other way round, so spreadsheets are told apart by
+// their leading bytes.
+func attachmentParser(filename string, content []byte, opts ExtractOptions) func([]byte) ([]internal.ExtractionItem, error) {
+	lower := strings.ToLower(filename)
+	switch {
+	case strings.HasSuffix(lower, ".xlsx") || strings.HasSuffix(lower, ".xls"):
+		if bytes.HasPrefix(content, oleMagic) {
+			return parseXLS
+		}
+		return parseXLSX
+	case strings.HasSuffix(lower, ".csv") || strings.HasSuffix(lower, ".tsv"):
+		return parseCSV
+	case strings.HasSuffix(lower, ".docx"):
+		return parseDOCX
+	case strings.HasSuffix(lower, ".odt"):
+		return parseODT
+	case strings.HasSuffix(lower, ".pdf"):
+		return func(b []byte) ([]internal.ExtractionItem, error) { return parsePDF(b, opts.OCR) }
+	case isImageName(lower) && opts.OCR != nil:
+		return func(b []byte) ([]internal.ExtractionItem, error) { return parseImage(b, opts.OCR) }
+	}
+	return nil
 }
 
 func parseEmailText(text string) []internal.ExtractionItem {
-	lines := splitLines(text)
+	return parseTextLines(internal.SourceEmailText, text)
+}
+
+// parseTextLines reads one item per line, lines of a wrapped name joined
+// by groupLines.
+func parseTextLines(source internal.ItemSource, text string) []internal.ExtractionItem {
+	lines := groupLines(text)
 	out := make([]internal.ExtractionItem, 0, len(lines))
 	lineNo := 0
 	for _, line := range lines {
 		lineNo++
-		item := lineToExtractionItem(internal.SourceEmailText, lineNo, line)
+		item := lineToExtractionItem(source, lineNo, line.text)
 		if item == nil {
 			continue
 		}
+		item.Meta["lineSpan"] = []int{line.from, line.to}
 		hasLetters := regexp.MustCompile(`[A-Za-zА-Яа-я]`).MatchString(item.RawLine)
 		hasQty := item.Qty != nil
 		if !hasLetters || (!hasQty && len(item.RawLine) < 8) {
@@ -110,162 +154,165 @@ func parseEmailHTMLTable(html string) []internal.ExtractionItem {
 	out := []internal.ExtractionItem{}
 	globalLine := 0
 	doc.Find("table").Each(func(_ int, table *goquery.Selection) {
-		rows := table.Find("tr")
-		if rows.Length() < 2 {
+		// Layout tables are left to htmlBlockLines.
+		if !isDataTable(table.Get(0)) {
 			return
 		}
+		rows := table.Find("tr")
 
 		headers := []string{}
 		rows.First().Find("th,td").Each(func(_ int, cell *goquery.Selection) {
-			headers = append(headers, strings.ToLower(strings.TrimSpace(cell.Text())))
+			headers = append(headers, strings.TrimSpace(cell.Text()))
 		})
-
-		nameIdx := findHeaderIndex(headers, []string{"наименование", "товар", "позиция", "номенклатура", "name", "product"})
-		qtyIdx := findHeaderIndex(headers, []string{"кол", "qty", "кол-во", "количество", "quantity"})
-		unitIdx := findHeaderIndex(headers, []string{"ед", "unit", "изм"})
+		cols := detectTableColumns(headers)
 
 		rows.Slice(1, rows.Length()).Each(func(_ int, row *goquery.Selection) {
 			cells := []string{}
 			row.Find("th,td").Each(func(_ int, cell *goquery.Selection) {
 				cells = append(cells, normalizeSpaces(cell.Text()))
 			})
-			if len(cells) == 0 {
-				return
-			}
-
-			nameCell := pickCell(cells, nameIdx, 0)
-			qtyCell := ""
-			if qtyIdx >= 0 && qtyIdx < len(cells) {
-				qtyCell = cells[qtyIdx]
-			} else {
-				for _, c := range cells {
-					if regexp.MustCompile(`\d`).MatchString(c) {
-						qtyCell = c
-						break
-					}
-				}
-			}
-			unitCell := pickCell(cells, unitIdx, -1)
-
-			parsed := util.ParseQty(qtyCell)
-			rawLine := strings.Join(cells, " | ")
-			if strings.TrimSpace(nameCell) == "" || (parsed.Qty == nil && !regexp.MustCompile(`\d`).MatchString(rawLine)) {
+			item := tableRowItem(internal.SourceEmailHTMLTable, cells, cols)
+			if item == nil {
 				return
 			}
-
 			globalLine++
-			item := internal.ExtractionItem{
-				LineNo:     globalLine,
-				Source:     internal.SourceEmailHTMLTable,
-				RawLine:    rawLine,
-				NameOrCode: util.StringPtr(nameCell),
-				Qty:        parsed.Qty,
-				Unit:       parsed.Unit,
-				Meta:       map[string]any{"row": cells},
-			}
-			if unitCell != "" {
-				item.Unit = util.StringPtr(unitCell)
-			}
-			out = append(out, item)
+			item.LineNo = globalLine
+			out = append(out, *item)
 		})
 	})
 
 	return out
 }
 
-func parseXLSX(content []byte) ([]internal.ExtractionItem, error) {
-	f, err := excelize.OpenReader(bytes.NewReader(content))
-	if err != nil {
-		return nil, err
-	}
-	defer f.Close()
+// tableColumns are the column indexes found in a table header, -1 where
+// the header has no such column. code is an article/code column kept apart
+// from the name, number a "№ п/п" running number that is never data.
+type tableColumns struct {
+	name, qty, unit int
+	code, number    int
+}
 
-	lineNo := 0
-	out := []internal.ExtractionItem{}
-	for _, sheet := range f.GetSheetList() {
-		rows, err := f.GetRows(sheet)
-		if err != nil {
-			continue
+var (
+	nameHeaderProbes = []string{"наимен", "товар", "номенк", "позиц", "name", "product"}
+	qtyHeaderProbes  = []string{"кол", "qty", "quantity"}
+	unitHeaderProbes = []string{"ед", "unit", "изм"}
+	codeHeaderProbes = []string{"артикул", "арт.", "код", "code", "sku", "part no", "p/n", "каталожн"}
+	numberHeader     = regexp.MustCompile(`^(№|n|no|#)?\.?\s*(п/п|пп|п\.п\.)?\.?$`)
+)
+
+func detectTableColumns(headers []string) tableColumns {
+	norm := make([]string, 0, len(headers))
+	for _, h := range headers {
+		norm = append(norm, strings.ToLower(strings.TrimSpace(h)))
+	}
+	cols := tableColumns{name: -1, qty: -1, unit: -1, code: -1, number: -1}
+	for i, h := range norm {
+		if h != "" && numberHeader.MatchString(h) {
+			cols.number = i
+			break
 		}
-		if len(rows) == 0 {
+	}
+	// "Код товара" is a code column and "Наименование, артикул" a name
+	// column: whichever probe comes first in the cell wins.
+	for i, h := range norm {
+		if i == cols.number {
 			continue
 		}
+		code, name := probeAt(h, codeHeaderProbes), probeAt(h, nameHeaderProbes)
+		if code >= 0 && (name < 0 || code < name) {
+			cols.code = i
+			break
+		}
+	}
+	taken := []int{cols.number, cols.code}
+	cols.name = findHeaderIndexExcept(norm, nameHeaderProbes, taken)
+	taken = append(taken, cols.name)
+	cols.qty = findHeaderIndexExcept(norm, qtyHeaderProbes, taken)
+	taken = append(taken, cols.qty)
+	cols.unit = findHeaderIndexExcept(norm, unitHeaderProbes, taken)
+	return cols
+}
 
-		nameIdx, qtyIdx, unitIdx := -1, -1, -1
-		for i, row := range rows {
-			cells := normalizeCells(row)
-			if len(cells) == 0 {
-				continue
-			}
-			if i < 3 && nameIdx < 0 {
-				nameIdx, qtyIdx, unitIdx = inferXLSColumns(cells)
-				if nameIdx >= 0 || qtyIdx >= 0 {
-					continue
-				}
-			}
+// firstDataColumn is where a row's name is taken from without a name column.
+func (c tableColumns) firstDataColumn() int {
+	if c.number == 0 {
+		return 1
+	}
+	return 0
+}
 
-			if nameIdx < 0 {
-				nameIdx, qtyIdx, unitIdx = 0, 1, 2
-			}
-			name := pickCell(cells, nameIdx, 0)
-			qtyCell := pickCell(cells, qtyIdx, -1)
-			if qtyCell == "" {
-				qtyCell = strings.Join(cells, " ")
-			}
-			parsed := util.ParseQty(qtyCell)
-			if strings.TrimSpace(name) == "" || parsed.Qty == nil {
+// tableRowItem builds the item of one table row, or nil when the row has no
+// name or nothing that looks like a quantity. Without a qty column the first
+// cell with a digit is taken.
+func tableRowItem(source internal.ItemSource, cells []string, cols tableColumns) *internal.ExtractionItem {
+	if len(cells) == 0 {
+		return nil
+	}
+	nameCell := pickCell(cells, cols.name, cols.firstDataColumn())
+	codeCell := pickCell(cells, cols.code, -1)
+	if nameCell == "" {
+		nameCell = codeCell
+	}
+	qtyCell := ""
+	if cols.qty >= 0 && cols.qty < len(cells) {
+		qtyCell = cells[cols.qty]
+	} else {
+		for i, c := range cells {
+			if i == cols.number || i == cols.code {
 				continue
 			}
-
-			lineNo++
-			item := internal.ExtractionItem{
-				LineNo:     lineNo,
-				Source:     internal.SourceXLSX,
-				RawLine:    strings.Join(cells, " | "),
-				NameOrCode: util.StringPtr(name),
-				Qty:        parsed.Qty,
-				Unit:       parsed.Unit,
-				Meta:       map[string]any{"sheet": sheet, "rowNumber": i + 1},
-			}
-			if unit := pickCell(cells, unitIdx, -1); unit != "" {
-				item.Unit = util.StringPtr(unit)
+			if regexp.MustCompile(`\d`).MatchString(c) {
+				qtyCell = c
+				break
 			}
-			out = append(out, item)
 		}
 	}
+	unitCell := pickCell(cells, cols.unit, -1)
 
-	return out, nil
+	parsed := util.ParseQty(qtyCell)
+	rawLine := strings.Join(cells, " | ")
+	if strings.TrimSpace(nameCell) == "" || (parsed.Qty == nil && !regexp.MustCompile(`\d`).MatchString(rawLine)) {
+		return nil
+	}
+
+	item := &internal.ExtractionItem{
+		Source:  source,
+		RawLine: rawLine,
+		Qty:     parsed.Qty,
+		Unit:    parsed.Unit,
+		Meta:    map[string]any{"row": cells},
+	}
+	qtyMeta(item.Meta, parsed)
+	setNameAndCode(item, pickCell(cells, cols.name, cols.firstDataColumn()), codeCell)
+	if item.NameOrCode == nil {
+		item.NameOrCode = util.StringPtr(nameCell)
+	}
+	if unitCell != "" {
+		item.Unit = util.StringPtr(unitCell)
+	}
+	return item
 }
 
-func parsePDF(content []byte) ([]internal.ExtractionItem, error) {
-	r, err := pdf.NewReader(bytes.NewReader(content), int64(len(content)))
+func parseXLSX(content []byte) ([]internal.ExtractionItem, error) {
+	f, err := excelize.OpenReader(bytes.NewReader(content))
 	if err != nil {
 		return nil, err
 	}
+	defer f.Close()
 
-	out := []internal.ExtractionItem{}
 	lineNo := 0
-	for i := 1; i <= r.NumPage(); i++ {
-		p := r.Page(i)
-		if p.V.IsNull() {
-			continue
-		}
-		text, err := p.GetPlainText(nil)
+	out := []internal.ExtractionItem{}
+	for _, sheet := range f.GetSheetList() {
+		rows, err := f.GetRows(sheet)
 		if err != nil {
 			continue
 		}
-		for _, line := range splitLines(text) {
-			lineNo++
-			item := lineToExtractionItem(internal.SourcePDF, lineNo, line)
-			if item == nil {
-				continue
-			}
-			if item.NameOrCode == nil || item.Qty == nil {
-				continue
-			}
-			out = append(out, *item)
+		if merges, err := f.GetMergeCells(sheet); err == nil {
+			rows = fillMergedCells(rows, xlsxMergedRanges(merges))
 		}
+		out = append(out, sheetItems(internal.SourceXLSX, sheet, rows, &lineNo)...)
 	}
+
 	return out, nil
 }
 
@@ -301,26 +348,37 @@ func lineToExtractionItem(source internal.ItemSource, lineNo int, rawLine string
 	name = regexp.MustCompile(`[;|]+`).ReplaceAllString(name, " ")
 	name = normalizeSpaces(name)
 
-	nameOrCode := name
-	if len([]rune(nameOrCode)) <= 1 {
-		nameOrCode = compact
-	}
-
 	item := internal.ExtractionItem{
-		LineNo:     lineNo,
-		Source:     source,
-		RawLine:    compact,
-		NameOrCode: util.StringPtr(nameOrCode),
-		Qty:        parsed.Qty,
-		Unit:       parsed.Unit,
-		Meta:       map[string]any{},
+		LineNo:  lineNo,
+		Source:  source,
+		RawLine: compact,
+		Qty:     parsed.Qty,
+		Unit:    parsed.Unit,
+		Meta:    map[string]any{},
+	}
+	setNameAndCode(&item, name, "")
+	if item.NameOrCode == nil || len([]rune(*item.NameOrCode)) <= 1 {
+		item.NameOrCode = util.StringPtr(compact)
 	}
 	if parsed.QtyRaw != nil {
 		item.Meta["qtyRaw"] = *parsed.QtyRaw
 	}
+	qtyMeta(item.Meta, parsed)
 	return &item
 }
 
+// qtyMeta records what ParseQty found besides the quantity: the bounds of a
+// range and the packs it was asked in.
+func qtyMeta(meta map[string]any, parsed util.ParsedQty) {
+	if parsed.Min != nil && parsed.Max != nil {
+		meta["qtyMin"] = *parsed.Min
+		meta["qtyMax"] = *parsed.Max
+	}
+	if parsed.Packaging != nil {
+		meta["packaging"] = *parsed.Packaging
+	}
+}
+
 func normalizeSpaces(input string) string {
 	return strings.TrimSpace(regexp.MustCompile(`\s+`).ReplaceAllString(input, " "))
 }
@@ -352,17 +410,31 @@ func dedupeItems(items []internal.ExtractionItem) []internal.ExtractionItem {
 	return out
 }
 
-func findHeaderIndex(headers []string, probes []string) int {
+// findHeaderIndexExcept returns the first header containing a probe,
+// skipping the columns already taken.
+func findHeaderIndexExcept(headers []string, probes []string, taken []int) int {
 	for i, h := range headers {
-		for _, probe := range probes {
-			if strings.Contains(h, probe) {
-				return i
-			}
+		if slices.Contains(taken, i) {
+			continue
+		}
+		if probeAt(h, probes) >= 0 {
+			return i
 		}
 	}
 	return -1
 }
 
+// probeAt returns the earliest position of a probe in header, or -1.
+func probeAt(header string, probes []string) int {
+	at := -1
+	for _, probe := range probes {
+		if i := strings.Index(header, probe); i >= 0 && (at < 0 || i < at) {
+			at = i
+		}
+	}
+	return at
+}
+
 func pickCell(cells []string, idx int, fallback int) string {
 	if idx >= 0 && idx < len(cells) {
 		return strings.TrimSpace(cells[idx])
@@ -373,15 +445,15 @@ func pickCell(cells []string, idx int, fallback int) string {
 	return ""
 }
 
-func inferXLSColumns(headers []string) (nameIdx, qtyIdx, unitIdx int) {
-	norm := make([]string, 0, len(headers))
-	for _, h := range headers {
-		norm = append(norm, strings.ToLower(h))
+// looksLikeHeader rejects rows with a purely numeric cell, so a data row such
+// as "Колодка | 10 | шт" is not mistaken for a header because of "кол".
+func looksLikeHeader(cells []string) bool {
+	for _, c := range cells {
+		if _, err := strconv.ParseFloat(strings.ReplaceAll(c, ",", "."), 64); err == nil {
+			return false
+		}
 	}
-	nameIdx = findHeaderIndex(norm, []string{"наимен", "товар", "номенк", "позиц", "name", "product"})
-	qtyIdx = findHeaderIndex(norm, []string{"кол", "qty", "quantity"})
-	unitIdx = findHeaderIndex(norm, []string{"ед", "unit", "изм"})
-	return
+	return true
 }
 
 func normalizeCells(row []string) []string {
@@ -0,0 +1,398 @@
+package pipeline
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/jhillyerd/enmime"
+	"golang.org/x/text/encoding/charmap"
+
+	"elcom/internal"
+)
+
+// Specifications often arrive one level down: zipped with their drawings,
+// or as attachments of the customer's letter that a manager forwarded. The
+// walker descends into archives and message/rfc822 parts and hands every
+// file to attachmentParser under its full path, e.g.
+// fwd.eml/spec.zip/list.xlsx. Depth, file count and unpacked size are
+// capped per message, and members are read in memory through that cap, so a
+// zip bomb costs at most MaxUnpackedBytes.
+
+const (
+	defaultArchiveDepth    = 3
+	defaultArchiveFiles    = 200
+	defaultArchiveUnpacked = 100 << 20
+	sevenZipTimeout        = 2 * time.Minute
+)
+
+type attachmentWalker struct {
+	opts  ExtractOptions
+	res   *EmailExtraction
+	files int
+	// budget is how many more bytes may be unpacked.
+	budget int64
+}
+
+func newAttachmentWalker(opts ExtractOptions, res *EmailExtraction) *attachmentWalker {
+	if opts.MaxDepth <= 0 {
+		opts.MaxDepth = defaultArchiveDepth
+	}
+	if opts.MaxFiles <= 0 {
+		opts.MaxFiles = defaultArchiveFiles
+	}
+	if opts.MaxUnpackedBytes <= 0 {
+		opts.MaxUnpackedBytes = defaultArchiveUnpacked
+	}
+	return &attachmentWalker{opts: opts, res: res, budget: opts.MaxUnpackedBytes}
+}
+
+// envelope extracts the body and the attachments of a message; path is
+// empty for the message itself and the attachment path of a forwarded one.
+func (w *attachmentWalker) envelope(env *enmime.Envelope, path string, depth int) []internal.ExtractionItem {
+	items := []internal.ExtractionItem{}
+	segment := func(text string) string {
+		segs := segmentBody(text)
+		if path == "" {
+			w.res.Segments = segs
+		}
+		return segmentText(text, segs, w.opts.IncludeQuoted)
+	}
+	plain := hasPlainTextPart(env)
+	if env.Text != "" && plain {
+		items = append(items, parseEmailText(segment(env.Text))...)
+	}
+	if env.HTML != "" {
+		items = append(items, parseEmailHTMLTable(env.HTML)...)
+		if !plain {
+			items = append(items, parseEmailHTMLText(segment(strings.Join(htmlBlockLines(env.HTML), "\n")))...)
+		}
+	}
+	if path != "" {
+		tagAttachment(items, path)
+	}
+
+	for _, part := range attachmentParts(env) {
+		name := strings.TrimSpace(part.FileName)
+		switch {
+		case name == "" && part.ContentType == "message/rfc822":
+			name = "message.eml"
+		case name == "":
+			name = "attachment"
+		}
+		items = append(items, w.file(joinAttachmentPath(path, name), part.ContentType, part.Content, depth)...)
+	}
+	return items
+}
+
+// attachmentParts are the attachments plus forwarded messages, which
+// mailers also send inline or without a disposition.
+func attachmentParts(env *enmime.Envelope) []*enmime.Part {
+	parts := append([]*enmime.Part{}, env.Attachments...)
+	for _, group := range [][]*enmime.Part{env.Inlines, env.OtherParts} {
+		for _, p := range group {
+			if p.ContentType == "message/rfc822" {
+				parts = append(parts, p)
+			}
+		}
+	}
+	return parts
+}
+
+func (w *attachmentWalker) file(path, contentType string, content []byte, depth int) []internal.ExtractionItem {
+	w.res.Attachments = append(w.res.Attachments, path)
+
+	var (
+		items []internal.ExtractionItem
+		err   error
+	)
+	lower := strings.ToLower(path)
+	switch {
+	case contentType == "message/rfc822" || strings.HasSuffix(lower, ".eml"):
+		items, err = w.message(path, content, depth)
+	case strings.HasSuffix(lower, ".zip"):
+		items, err = w.zip(path, content, depth)
+	case strings.HasSuffix(lower, ".rar") || strings.HasSuffix(lower, ".7z"):
+		if w.opts.SevenZipPath == "" {
+			return nil
+		}
+		items, err = w.sevenZip(path, content, depth)
+	default:
+		parse := attachmentParser(path, content, w.opts)
+		if parse == nil {
+			return nil
+		}
+		items, err = parse(content)
+		tagAttachment(items, path)
+	}
+	if err != nil {
+		w.res.Failures = append(w.res.Failures, AttachmentFailure{Attachment: path, Error: err.Error()})
+	}
+	return items
+}
+
+func (w *attachmentWalker) message(path string, content []byte, depth int) ([]internal.ExtractionItem, error) {
+	if depth >= w.opts.MaxDepth {
+		return nil, fmt.Errorf("eml: nested deeper than %d levels", w.opts.MaxDepth)
+	}
+	env, err := enmime.ReadEnvelope(bytes.NewReader(content))
+	if err != nil {
+		return nil, fmt.Errorf("eml: %w", err)
+	}
+	return w.envelope(env, path, depth+1), nil
+}
+
+// zip reads the archive with archive/zip. Items found before a limit is hit
+// are kept.
+func (w *attachmentWalker) zip(path string, content []byte, depth int) ([]internal.ExtractionItem, error) {
+	if depth >= w.opts.MaxDepth {
+		return nil, fmt.Errorf("zip: nested deeper than %d levels", w.opts.MaxDepth)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(content), int64(len(content)))
+	if err != nil {
+		return nil, fmt.Errorf("zip: %w", err)
+	}
+	items := []internal.ExtractionItem{}
+	for _, f := range zr.File {
+		name := zipEntryName(f)
+		if f.FileInfo().IsDir() || strings.HasPrefix(name, "__MACOSX/") {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return items, fmt.Errorf("zip: %s: %w", name, err)
+		}
+		data, err := w.unpack(rc)
+		rc.Close()
+		if err != nil {
+			return items, fmt.Errorf("zip: %s: %w", name, err)
+		}
+		items = append(items, w.file(joinAttachmentPath(path, name), "", data, depth+1)...)
+	}
+	return items, nil
+}
+
+// zipEntryName decodes names written by Windows archivers, which use the
+// OEM code page (CP866 for Russian) without setting the UTF-8 flag.
+func zipEntryName(f *zip.File) string {
+	if !f.NonUTF8 || utf8.ValidString(f.Name) {
+		return f.Name
+	}
+	name, err := charmap.CodePage866.NewDecoder().String(f.Name)
+	if err != nil {
+		return f.Name
+	}
+	return name
+}
+
+// sevenZip reads .rar and .7z with the 7z binary. The listing only rejects
+// archives that admit to being too large: its sizes come from the archive
+// itself, so each member is then streamed from `7z e -so` through unpack and
+// nothing unpacked touches the disk.
+func (w *attachmentWalker) sevenZip(path string, content []byte, depth int) ([]internal.ExtractionItem, error) {
+	if depth >= w.opts.MaxDepth {
+		return nil, fmt.Errorf("7z: nested deeper than %d levels", w.opts.MaxDepth)
+	}
+	dir, err := os.MkdirTemp("", "elcom-archive-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	archive := filepath.Join(dir, "archive"+filepath.Ext(strings.ToLower(path)))
+	if err := os.WriteFile(archive, content, 0o600); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), sevenZipTimeout)
+	defer cancel()
+	listing, err := w.run7z(ctx, "l", "-slt", archive)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := parse7zListing(listing)
+	if err != nil {
+		return nil, err
+	}
+	var total int64
+	for _, e := range entries {
+		total += e.size
+	}
+	if w.files+len(entries) > w.opts.MaxFiles {
+		return nil, fmt.Errorf("7z: %w", errTooManyFiles(w.opts.MaxFiles))
+	}
+	if total > w.budget {
+		return nil, fmt.Errorf("7z: %w", errTooLarge(w.opts.MaxUnpackedBytes))
+	}
+
+	items := []internal.ExtractionItem{}
+	for _, e := range entries {
+		data, err := w.stream7z(ctx, archive, e.path)
+		if err != nil {
+			return items, fmt.Errorf("7z: %s: %w", e.path, err)
+		}
+		items = append(items, w.file(joinAttachmentPath(path, e.path), "", data, depth+1)...)
+	}
+	return items, nil
+}
+
+// stream7z reads one member of archive from the stdout of 7z. The process is
+// killed as soon as unpack stops reading, so a member larger than its listing
+// claims costs no more than the remaining budget.
+func (w *attachmentWalker) stream7z(ctx context.Context, archive, member string) ([]byte, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	// -spd takes the member name literally instead of as a wildcard.
+	cmd := exec.CommandContext(ctx, w.opts.SevenZipPath, "e", "-so", "-spd", "--", archive, filepath.FromSlash(member))
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	data, err := w.unpack(stdout)
+	if err != nil {
+		cancel()
+		_ = cmd.Wait()
+		return nil, err
+	}
+	if err := cmd.Wait(); err != nil {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%w: %s", err, msg)
+		}
+		return nil, err
+	}
+	return data, nil
+}
+
+func (w *attachmentWalker) run7z(ctx context.Context, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, w.opts.SevenZipPath, args...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("7z: %w: %s", err, msg)
+		}
+		return nil, fmt.Errorf("7z: %w", err)
+	}
+	return stdout.Bytes(), nil
+}
+
+type sevenZipEntry struct {
+	path string
+	size int64
+}
+
+// parse7zListing reads the files of `7z l -slt`: one "Key = value" block per
+// entry after the "----------" line, directories marked by a D attribute.
+func parse7zListing(listing []byte) ([]sevenZipEntry, error) {
+	var (
+		entries []sevenZipEntry
+		cur     sevenZipEntry
+		dir     bool
+		started bool
+	)
+	flush := func() {
+		if cur.path != "" && !dir {
+			entries = append(entries, cur)
+		}
+		cur, dir = sevenZipEntry{}, false
+	}
+	sc := bufio.NewScanner(bytes.NewReader(listing))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if !started {
+			started = line == "----------"
+			continue
+		}
+		key, value, ok := strings.Cut(line, " = ")
+		if !ok {
+			if line == "" {
+				flush()
+			}
+			continue
+		}
+		switch key {
+		case "Path":
+			flush()
+			cur.path = filepath.ToSlash(value)
+		case "Size":
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("7z: bad size %q for %s", value, cur.path)
+			}
+			cur.size = n
+		case "Folder":
+			dir = dir || value == "+"
+		case "Attributes":
+			dir = dir || strings.HasPrefix(value, "D")
+		}
+	}
+	flush()
+	if !started {
+		return nil, errors.New("7z: unexpected listing")
+	}
+	return entries, sc.Err()
+}
+
+// unpack reads one archive member, counting it against the file and size
+// limits of the message.
+func (w *attachmentWalker) unpack(r io.Reader) ([]byte, error) {
+	w.files++
+	if w.files > w.opts.MaxFiles {
+		return nil, errTooManyFiles(w.opts.MaxFiles)
+	}
+	data, err := io.ReadAll(io.LimitReader(r, w.budget+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > w.budget {
+		w.budget = 0
+		return nil, errTooLarge(w.opts.MaxUnpackedBytes)
+	}
+	w.budget -= int64(len(data))
+	return data, nil
+}
+
+func errTooManyFiles(limit int) error {
+	return fmt.Errorf("more than %d files unpacked from one message", limit)
+}
+
+func errTooLarge(limit int64) error {
+	return fmt.Errorf("more than %d bytes unpacked from one message", limit)
+}
+
+func joinAttachmentPath(parent, name string) string {
+	if parent == "" {
+		return name
+	}
+	return parent + "/" + name
+}
+
+func tagAttachment(items []internal.ExtractionItem, path string) {
+	for i := range items {
+		if items[i].Meta == nil {
+			items[i].Meta = map[string]any{}
+		}
+		items[i].Meta["attachment"] = path
+	}
+}
@@ -0,0 +1,190 @@
+package pipeline
+
+import (
+	"archive/zip"
+	"bytes"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"golang.org/x/text/encoding/charmap"
+)
+
+type zipEntry struct {
+	name    string
+	content []byte
+}
+
+func mkZipFiles(entries ...zipEntry) []byte {
+	buf := bytes.NewBuffer(nil)
+	zw := zip.NewWriter(buf)
+	for _, e := range entries {
+		w, _ := zw.Create(e.name)
+		_, _ = w.Write(e.content)
+	}
+	_ = zw.Close()
+	return buf.Bytes()
+}
+
+// mkForward wraps a message the way mail clients forward "as attachment":
+// a message/rfc822 part, here inline and named only by its content type.
+func mkForward(inner []byte) []byte {
+	var b bytes.Buffer
+	b.WriteString("From: manager@example.com\r\nSubject: Fwd: Zayavka\r\nMIME-Version: 1.0\r\nContent-Type: multipart/mixed; boundary=f\r\n\r\n")
+	b.WriteString("--f\r\nContent-Type: text/plain; charset=utf-8\r\n\r\nПосмотрите заявку клиента\r\n")
+	b.WriteString("--f\r\nContent-Type: message/rfc822; name=\"fwd.eml\"\r\n\r\n")
+	b.Write(inner)
+	b.WriteString("\r\n--f--\r\n")
+	return b.Bytes()
+}
+
+func itemAttachments(res EmailExtraction) string {
+	parts := []string{}
+	for _, item := range res.Items {
+		if att, ok := item.Meta["attachment"].(string); ok {
+			parts = append(parts, *item.NameOrCode+"@"+att)
+		}
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ", ")
+}
+
+func TestExtractForwardedMessageWithArchive(t *testing.T) {
+	spec := mkZipFiles(
+		zipEntry{"list.xlsx", mkXLSX([][]any{{"Наименование", "Кол-во", "Ед"}, {"Кабель ВВГнг 3x2.5", 150, "м"}})},
+		zipEntry{"__MACOSX/._list.xlsx", []byte("resource fork")},
+		zipEntry{"drawings/", nil},
+	)
+	raw := mkForward(mkMultipart(attachment{"spec.zip", spec}))
+
+	res, err := ExtractItemsFromEmailRaw(raw, ExtractOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := itemAttachments(res); got != "Кабель ВВГнг 3x2.5@fwd.eml/spec.zip/list.xlsx, Спецификация во вложении@fwd.eml" {
+		t.Fatalf("got %s", got)
+	}
+	if got := strings.Join(res.Attachments, ","); got != "fwd.eml,fwd.eml/spec.zip,fwd.eml/spec.zip/list.xlsx" {
+		t.Fatalf("attachments=%s", got)
+	}
+	if len(res.Failures) != 0 {
+		t.Fatalf("failures=%+v", res.Failures)
+	}
+}
+
+func TestExtractArchiveLimits(t *testing.T) {
+	csv := []byte("Наименование;Кол-во\nЛампа E27;40\n")
+	zeros := make([]byte, 1<<20)
+	nested := mkZipFiles(zipEntry{"b.zip", mkZipFiles(zipEntry{"c.zip", mkZipFiles(zipEntry{"deep.csv", csv})})})
+	raw := mkMultipart(
+		attachment{"bomb.zip", mkZipFiles(zipEntry{"a.csv", csv}, zipEntry{"zeros.csv", zeros})},
+		attachment{"nested.zip", nested},
+	)
+
+	res, err := ExtractItemsFromEmailRaw(raw, ExtractOptions{MaxDepth: 2, MaxUnpackedBytes: 64 << 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := itemAttachments(res); got != "Лампа E27@bomb.zip/a.csv" {
+		t.Fatalf("got %s", got)
+	}
+	failures := []string{}
+	for _, f := range res.Failures {
+		failures = append(failures, f.Attachment+": "+f.Error)
+	}
+	want := "bomb.zip: zip: zeros.csv: more than 65536 bytes unpacked from one message, " +
+		"nested.zip: zip: b.zip: more than 65536 bytes unpacked from one message"
+	if got := strings.Join(failures, ", "); got != want {
+		t.Fatalf("got  %s\nwant %s", got, want)
+	}
+
+	res, err = ExtractItemsFromEmailRaw(mkMultipart(attachment{"nested.zip", nested}), ExtractOptions{MaxDepth: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Failures) != 1 || res.Failures[0].Attachment != "nested.zip/b.zip/c.zip" || res.Failures[0].Error != "zip: nested deeper than 2 levels" {
+		t.Fatalf("failures=%+v", res.Failures)
+	}
+
+	many := mkZipFiles(zipEntry{"1.txt", nil}, zipEntry{"2.txt", nil}, zipEntry{"3.csv", csv})
+	res, err = ExtractItemsFromEmailRaw(mkMultipart(attachment{"many.zip", many}), ExtractOptions{MaxFiles: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Failures) != 1 || res.Failures[0].Error != "zip: 3.csv: more than 2 files unpacked from one message" {
+		t.Fatalf("failures=%+v", res.Failures)
+	}
+}
+
+func TestExtractZipWithCP866Names(t *testing.T) {
+	name, _ := charmap.CodePage866.NewEncoder().String("Заявка.csv")
+	buf := bytes.NewBuffer(nil)
+	zw := zip.NewWriter(buf)
+	w, _ := zw.CreateHeader(&zip.FileHeader{Name: name, NonUTF8: true, Method: zip.Deflate})
+	_, _ = w.Write([]byte("Наименование;Кол-во\nЛампа E27;40\n"))
+	_ = zw.Close()
+
+	res, err := ExtractItemsFromEmailRaw(mkMultipart(attachment{"spec.zip", buf.Bytes()}), ExtractOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := itemAttachments(res); got != "Лампа E27@spec.zip/Заявка.csv" {
+		t.Fatalf("got %s", got)
+	}
+}
+
+func TestExtractRarWithSevenZip(t *testing.T) {
+	dir := t.TempDir()
+	// The fake 7z lists one file with a folder and prints it on extraction;
+	// with FAKE7Z_FLOOD set it prints without end whatever the listing says.
+	script := `#!/bin/sh
+case "$1" in
+l) printf 'Listing archive: %s\n\n--\nPath = %s\nType = Rar5\n\n----------\nPath = spec\nFolder = +\nSize = 0\n\nPath = spec/list.csv\nFolder = -\nSize = 38\n\n' "$3" "$3" ;;
+e) [ "$6" = spec/list.csv ] || exit 2
+   [ -n "$FAKE7Z_FLOOD" ] && exec yes 'Лампа E27;40'
+   printf 'Наименование;Кол-во\nЛампа E27;40\n' ;;
+*) exit 2 ;;
+esac
+`
+	path := filepath.Join(dir, "7z")
+	if err := os.WriteFile(path, []byte(script), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	raw := mkMultipart(attachment{"spec.rar", []byte("Rar!\x1a\x07\x01\x00")})
+
+	res, err := ExtractItemsFromEmailRaw(raw, ExtractOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := itemAttachments(res); got != "" || len(res.Failures) != 0 {
+		t.Fatalf("rar read without 7z: %s %+v", got, res.Failures)
+	}
+
+	res, err = ExtractItemsFromEmailRaw(raw, ExtractOptions{SevenZipPath: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := itemAttachments(res); got != "Лампа E27@spec.rar/spec/list.csv" {
+		t.Fatalf("got %s failures=%+v", got, res.Failures)
+	}
+
+	res, err = ExtractItemsFromEmailRaw(raw, ExtractOptions{SevenZipPath: path, MaxUnpackedBytes: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Failures) != 1 || res.Failures[0].Error != "7z: more than 10 bytes unpacked from one message" {
+		t.Fatalf("failures=%+v", res.Failures)
+	}
+
+	// A member larger than its listing claims is cut off at the budget.
+	t.Setenv("FAKE7Z_FLOOD", "1")
+	res, err = ExtractItemsFromEmailRaw(raw, ExtractOptions{SevenZipPath: path, MaxUnpackedBytes: 1 << 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Failures) != 1 || res.Failures[0].Error != "7z: spec/list.csv: more than 1024 bytes unpacked from one message" {
+		t.Fatalf("failures=%+v", res.Failures)
+	}
+}
@@ -0,0 +1,122 @@
+package pipeline
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+
+	"elcom/internal"
+	"elcom/internal/util"
+)
+
+// A line names the product and often its article too: "Автомат ABB S201
+// C16 арт. 2CDS251001R0164". An article after a label is taken out of the
+// name; tokens shaped like manufacturer codes (EZ9F34116, MVA20-1-016-C) are
+// kept in the name and only tried as codes too, since a shape is a guess.
+
+var (
+	// labelledCode is a code after "арт.", "артикул", "код", "sku"...
+	labelledCode = regexp.MustCompile(`(?i)(?:^|[\s(,;])(артикул|арт|код(?:\s+товара)?|art|article|sku|p/n|part\s*no)(?:[.:№#]|\s)+([\p{L}\p{N}][\p{L}\p{N}./_-]*[\p{L}\p{N}])`)
+	// codeShape is a Latin token with letters and at least three digits, as
+	// manufacturers write their codes.
+	codeShape = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9./_-]{4,}[A-Za-z0-9]$`)
+	// dimensions are cable sections and sizes, "3x2.5", never codes.
+	dimensions = regexp.MustCompile(`(?i)^\d+([.,]\d+)?[xх*×]\d`)
+)
+
+// splitCodes takes the labelled articles out of text and returns the rest,
+// trimmed of separators left at its ends, with the codes found: labelled
+// ones first, then the code-shaped tokens left in the name.
+func splitCodes(text string) (string, []string) {
+	var codes []string
+	for _, m := range labelledCode.FindAllStringSubmatch(text, -1) {
+		if strings.IndexFunc(m[2], unicode.IsDigit) >= 0 {
+			codes = append(codes, m[2])
+			text = strings.Replace(text, strings.TrimLeft(m[0], " \t(,;"), " ", 1)
+		}
+	}
+	text = strings.Trim(normalizeSpaces(text), " ,;:—–-")
+	for _, token := range strings.Fields(text) {
+		token = strings.Trim(token, ",;()")
+		if looksLikeManufacturerCode(token) {
+			codes = append(codes, token)
+		}
+	}
+	return text, uniqueCodes(codes)
+}
+
+func looksLikeManufacturerCode(token string) bool {
+	if !codeShape.MatchString(token) || dimensions.MatchString(token) {
+		return false
+	}
+	digits, letters := 0, 0
+	for _, r := range token {
+		switch {
+		case unicode.IsDigit(r):
+			digits++
+		case unicode.IsLetter(r):
+			letters++
+		}
+	}
+	return digits >= 3 && letters >= 1
+}
+
+// uniqueCodes drops codes that normalise to one already listed.
+func uniqueCodes(codes []string) []string {
+	seen := map[string]bool{}
+	out := make([]string, 0, len(codes))
+	for _, c := range codes {
+		key := util.NormalizeCode(c)
+		if key == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		out = append(out, c)
+	}
+	return out
+}
+
+// setNameAndCode fills Name, Code and NameOrCode from the name text of an
+// item and the cell of a code column, if any. Codes beyond the first are
+// kept in Meta["codes"] for the matcher.
+func setNameAndCode(item *internal.ExtractionItem, name, codeCell string) {
+	rest, codes := splitCodes(name)
+	if codeCell = strings.TrimSpace(codeCell); codeCell != "" {
+		codes = uniqueCodes(append([]string{codeCell}, codes...))
+	}
+	item.Name, item.Code = nil, nil
+	if len(codes) > 0 {
+		item.Code = util.StringPtr(codes[0])
+		if len(codes) > 1 {
+			if item.Meta == nil {
+				item.Meta = map[string]any{}
+			}
+			item.Meta["codes"] = codes
+		}
+	}
+	// A name that is only a code is no name.
+	if rest != "" && (len(codes) == 0 || util.NormalizeCode(rest) != util.NormalizeCode(codes[0])) {
+		item.Name = util.StringPtr(rest)
+	}
+	switch {
+	case item.Name != nil:
+		item.NameOrCode = util.StringPtr(rest)
+	case item.Code != nil:
+		item.NameOrCode = item.Code
+	}
+}
+
+// itemCodes lists the codes to look up for an item, in order: the
+// extracted ones, then the whole name when it reads as a code.
+func itemCodes(item internal.ExtractionItem) []string {
+	var codes []string
+	if extra, ok := item.Meta["codes"].([]string); ok {
+		codes = append(codes, extra...)
+	} else if item.Code != nil {
+		codes = append(codes, *item.Code)
+	}
+	if item.NameOrCode != nil && util.LooksLikeCode(*item.NameOrCode) {
+		codes = append(codes, *item.NameOrCode)
+	}
+	return uniqueCodes(codes)
+}
@@ -0,0 +1,133 @@
+package pipeline
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/charmap"
+
+	"elcom/internal"
+)
+
+// csvSniffLines is how many leading lines decide the delimiter.
+const csvSniffLines = 20
+
+// csvDelimiters in order of preference when several fit equally well; ';'
+// comes first because Russian exports use ',' as the decimal separator.
+var csvDelimiters = []rune{';', ',', '\t', '|'}
+
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// cyrillicCharsets are tried, in order, for text that is not valid UTF-8.
+var cyrillicCharsets = []struct {
+	name    string
+	charmap *charmap.Charmap
+}{
+	{"windows-1251", charmap.Windows1251},
+	{"koi8-r", charmap.KOI8R},
+}
+
+// frequentCyrillic are the most common lowercase Russian letters. Decoding
+// with the wrong single-byte charset turns them into capitals or symbols,
+// so the right charset is the one producing most of them.
+const frequentCyrillic = "оеаинтсрвлкмдпу"
+
+// parseCSV reads a comma, semicolon, tab or pipe separated specification in
+// UTF-8, Windows-1251 or KOI8-R and runs the spreadsheet column inference
+// over its rows.
+func parseCSV(content []byte) ([]internal.ExtractionItem, error) {
+	text, encoding := decodeCyrillicText(content)
+	delimiter := sniffDelimiter(text)
+
+	r := csv.NewReader(strings.NewReader(text))
+	r.Comma = delimiter
+	r.FieldsPerRecord = -1
+	r.LazyQuotes = true
+	rows, err := r.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, errors.New("csv: no rows")
+	}
+
+	lineNo := 0
+	items := sheetItems(internal.SourceCSV, "", rows, &lineNo)
+	for i := range items {
+		items[i].Meta["encoding"] = encoding
+		items[i].Meta["delimiter"] = string(delimiter)
+	}
+	return items, nil
+}
+
+// decodeCyrillicText returns content as UTF-8 together with the name of the
+// encoding it was read from.
+func decodeCyrillicText(content []byte) (string, string) {
+	content = bytes.TrimPrefix(content, utf8BOM)
+	if utf8.Valid(content) {
+		return string(content), "utf-8"
+	}
+
+	best, bestName, bestScore := "", "", -1
+	for _, cs := range cyrillicCharsets {
+		decoded, err := cs.charmap.NewDecoder().Bytes(content)
+		if err != nil {
+			continue
+		}
+		score := 0
+		for _, r := range string(decoded) {
+			if strings.ContainsRune(frequentCyrillic, r) {
+				score++
+			}
+		}
+		if score > bestScore {
+			best, bestName, bestScore = string(decoded), cs.name, score
+		}
+	}
+	return best, bestName
+}
+
+// sniffDelimiter picks the delimiter that splits the most leading lines into
+// the same number of fields, more than one.
+func sniffDelimiter(text string) rune {
+	lines := make([]string, 0, csvSniffLines)
+	for _, line := range strings.Split(text, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		lines = append(lines, line)
+		if len(lines) == csvSniffLines {
+			break
+		}
+	}
+	sample := strings.Join(lines, "\n")
+
+	best, bestScore := csvDelimiters[0], 0
+	for _, d := range csvDelimiters {
+		r := csv.NewReader(strings.NewReader(sample))
+		r.Comma = d
+		r.FieldsPerRecord = -1
+		r.LazyQuotes = true
+		widths := map[int]int{}
+		for {
+			rec, err := r.Read()
+			if err != nil {
+				break
+			}
+			if len(rec) > 1 {
+				widths[len(rec)]++
+			}
+		}
+		score := 0
+		for _, n := range widths {
+			score = max(score, n)
+		}
+		if score > bestScore {
+			best, bestScore = d, score
+		}
+	}
+	return best
+}
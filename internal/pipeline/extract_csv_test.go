@@ -0,0 +1,61 @@
+package pipeline
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"golang.org/x/text/encoding/charmap"
+)
+
+func csvSummary(t *testing.T, content []byte) string {
+	t.Helper()
+	items, err := parseCSV(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := []string{}
+	for _, item := range items {
+		unit := ""
+		if item.Unit != nil {
+			unit = *item.Unit
+		}
+		parts = append(parts, fmt.Sprintf("%s/%g/%s", *item.NameOrCode, *item.Qty, unit))
+	}
+	return strings.Join(parts, ", ")
+}
+
+func TestParseCSVWindows1251Semicolon(t *testing.T) {
+	text := "Заявка ООО Ромашка от 01.02.2026\r\n\r\n" +
+		"№;Наименование;Ед. изм.;Кол-во\r\n" +
+		"1;Кабель ВВГнг 3x2,5;м;150,5\r\n" +
+		"2;\"Автомат ABB S201; C16\";шт;20\r\n"
+	blob, err := charmap.Windows1251.NewEncoder().Bytes([]byte(text))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := csvSummary(t, blob); got != "Кабель ВВГнг 3x2,5/150.5/м, Автомат ABB S201; C16/20/шт" {
+		t.Fatalf("got %s", got)
+	}
+	items, _ := parseCSV(blob)
+	if items[0].Source != "csv" || items[0].Meta["encoding"] != "windows-1251" || items[0].Meta["delimiter"] != ";" || items[0].Meta["rowNumber"] != 3 {
+		t.Fatalf("item=%+v", items[0])
+	}
+}
+
+func TestParseCSVKOI8RTab(t *testing.T) {
+	blob, err := charmap.KOI8R.NewEncoder().Bytes([]byte("наименование\tколичество\tед\nпровод ПВС 2x1.5\t5\tбухта\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := csvSummary(t, blob); got != "провод ПВС 2x1.5/5/бухта" {
+		t.Fatalf("got %s", got)
+	}
+}
+
+func TestParseCSVUTF8CommaWithBOM(t *testing.T) {
+	blob := append([]byte{0xEF, 0xBB, 0xBF}, "Товар,Количество\n\"Лампа LED 10W, E27\",40\nРозетка,12\n"...)
+	if got := csvSummary(t, blob); got != "Лампа LED 10W, E27/40/, Розетка/12/" {
+		t.Fatalf("got %s", got)
+	}
+}
@@ -0,0 +1,348 @@
+package pipeline
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"elcom/internal"
+)
+
+// Word (.docx) and OpenDocument (.odt) files are zip packages with the body
+// in one XML part. Only tables are read: specifications in tender documents
+// live there, while the surrounding prose would only add noise.
+
+// maxDocColumns caps repeated and spanned cells, which ODT writers sometimes
+// stretch to the full page grid.
+const maxDocColumns = 256
+
+// docTable is one document table with merged cells already spread over the
+// grid: a vertically merged cell repeats its text in every row it covers, a
+// horizontally merged one leaves the covered columns empty.
+type docTable [][]string
+
+func parseDOCX(content []byte) ([]internal.ExtractionItem, error) {
+	body, err := readZipPart(content, "word/document.xml")
+	if err != nil {
+		return nil, fmt.Errorf("docx: %w", err)
+	}
+	tables, err := readDOCXTables(body)
+	if err != nil {
+		return nil, fmt.Errorf("docx: %w", err)
+	}
+	return docTableItems(tables), nil
+}
+
+func parseODT(content []byte) ([]internal.ExtractionItem, error) {
+	body, err := readZipPart(content, "content.xml")
+	if err != nil {
+		return nil, fmt.Errorf("odt: %w", err)
+	}
+	tables, err := readODTTables(body)
+	if err != nil {
+		return nil, fmt.Errorf("odt: %w", err)
+	}
+	return docTableItems(tables), nil
+}
+
+func readZipPart(content []byte, name string) ([]byte, error) {
+	zr, err := zip.NewReader(bytes.NewReader(content), int64(len(content)))
+	if err != nil {
+		return nil, err
+	}
+	f, err := zr.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+// readDOCXTables reads the top-level w:tbl elements. Text of tables nested in
+// a cell is kept as part of that cell.
+func readDOCXTables(body []byte) ([]docTable, error) {
+	type docxCell struct {
+		text      string
+		span      int
+		continued bool
+	}
+	var (
+		tables []docTable
+		rows   [][]docxCell
+		row    []docxCell
+		cell   *strings.Builder
+		cur    docxCell
+		depth  int
+		inText bool
+	)
+	dec := xml.NewDecoder(bytes.NewReader(body))
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "tbl":
+				depth++
+				if depth == 1 {
+					rows = nil
+				}
+			case "tr":
+				if depth == 1 {
+					row = nil
+				}
+			case "tc":
+				if depth == 1 {
+					cell, cur = &strings.Builder{}, docxCell{span: 1}
+				}
+			case "gridSpan":
+				if depth == 1 {
+					cur.span = attrInt(t, "val", 1)
+				}
+			case "vMerge":
+				// <w:vMerge/> without val continues the cell above.
+				if depth == 1 {
+					cur.continued = attrValue(t, "val") != "restart"
+				}
+			case "t":
+				inText = true
+			case "p", "tab", "br":
+				if cell != nil && cell.Len() > 0 {
+					cell.WriteString(" ")
+				}
+			}
+		case xml.CharData:
+			if inText && cell != nil {
+				cell.Write(t)
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "t":
+				inText = false
+			case "tc":
+				if depth == 1 && cell != nil {
+					cur.text = normalizeSpaces(cell.String())
+					row = append(row, cur)
+					cell = nil
+				}
+			case "tr":
+				if depth == 1 {
+					rows = append(rows, row)
+				}
+			case "tbl":
+				if depth == 1 {
+					grid := make(docTable, 0, len(rows))
+					for r, cells := range rows {
+						line := []string{}
+						for _, c := range cells {
+							text := c.text
+							if c.continued && r > 0 && len(line) < len(grid[r-1]) {
+								text = grid[r-1][len(line)]
+							}
+							line = append(line, text)
+							for i := 1; i < c.span && len(line) < maxDocColumns; i++ {
+								line = append(line, "")
+							}
+						}
+						grid = append(grid, line)
+					}
+					tables = append(tables, grid)
+				}
+				depth--
+			}
+		}
+	}
+	return tables, nil
+}
+
+// readODTTables reads the top-level table:table elements. Cells covered by a
+// merge are table:covered-table-cell placeholders: those right of a spanning
+// cell stay empty, those below one take its text.
+func readODTTables(body []byte) ([]docTable, error) {
+	type spanDown struct {
+		text string
+		rows int
+	}
+	var (
+		tables  []docTable
+		grid    docTable
+		row     []string
+		cell    *strings.Builder
+		repeat  int
+		rowSpan int
+		colSpan int
+		right   int
+		down    map[int]spanDown
+		depth   int
+	)
+	dec := xml.NewDecoder(bytes.NewReader(body))
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "table":
+				depth++
+				if depth == 1 {
+					grid, down = nil, map[int]spanDown{}
+				}
+			case "table-row":
+				if depth == 1 {
+					row, right = nil, 0
+				}
+			case "table-cell":
+				if depth == 1 {
+					cell = &strings.Builder{}
+					repeat = attrInt(t, "number-columns-repeated", 1)
+					rowSpan = attrInt(t, "number-rows-spanned", 1)
+					colSpan = attrInt(t, "number-columns-spanned", 1)
+				}
+			case "covered-table-cell":
+				if depth != 1 {
+					continue
+				}
+				for n := attrInt(t, "number-columns-repeated", 1); n > 0 && len(row) < maxDocColumns; n-- {
+					col := len(row)
+					text := ""
+					if right > 0 {
+						right--
+					} else if d := down[col]; d.rows > 0 {
+						text = d.text
+						down[col] = spanDown{text: d.text, rows: d.rows - 1}
+					}
+					row = append(row, text)
+				}
+			case "p", "h", "s", "tab", "line-break":
+				if cell != nil && cell.Len() > 0 {
+					cell.WriteString(" ")
+				}
+			}
+		case xml.CharData:
+			if cell != nil {
+				cell.Write(t)
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "table-cell":
+				if depth == 1 && cell != nil {
+					text := normalizeSpaces(cell.String())
+					for ; repeat > 0 && len(row) < maxDocColumns; repeat-- {
+						if rowSpan > 1 {
+							down[len(row)] = spanDown{text: text, rows: rowSpan - 1}
+							for c := 1; c < colSpan; c++ {
+								down[len(row)+c] = spanDown{rows: rowSpan - 1}
+							}
+						}
+						row = append(row, text)
+					}
+					right = colSpan - 1
+					cell = nil
+				}
+			case "table-row":
+				if depth == 1 {
+					grid = append(grid, trimTrailingEmpty(row))
+				}
+			case "table":
+				if depth == 1 {
+					tables = append(tables, grid)
+				}
+				depth--
+			}
+		}
+	}
+	return tables, nil
+}
+
+// numberingRow matches the "1 | 2 | 3 | 4" row tender forms put under the
+// header to number the columns.
+var numberingRow = regexp.MustCompile(`^\d+( \| \d+)+$`)
+
+// docTableItems runs the HTML table header detection over each table. A
+// table whose first row is not a header but that is as wide as the previous
+// one continues it (a table split across pages), as does a table that
+// repeats the previous header.
+func docTableItems(tables []docTable) []internal.ExtractionItem {
+	out := []internal.ExtractionItem{}
+	var (
+		header []string
+		cols   tableColumns
+		width  int
+	)
+	for ti, table := range tables {
+		if len(table) == 0 {
+			continue
+		}
+		first := 1
+		if !isTableHeader(table[0]) && header != nil && len(table[0]) == width {
+			first = 0
+		} else {
+			header, cols, width = table[0], detectTableColumns(table[0]), len(table[0])
+		}
+
+		for ri := first; ri < len(table); ri++ {
+			cells := table[ri]
+			joined := strings.Join(cells, " | ")
+			if strings.Join(header, " | ") == joined || numberingRow.MatchString(joined) {
+				continue
+			}
+			item := tableRowItem(internal.SourceDocTable, cells, cols)
+			if item == nil {
+				continue
+			}
+			item.LineNo = len(out) + 1
+			item.Meta["table"] = ti + 1
+			item.Meta["rowNumber"] = ri + 1
+			out = append(out, *item)
+		}
+	}
+	return out
+}
+
+// isTableHeader reports whether a row names a name or quantity column.
+func isTableHeader(cells []string) bool {
+	if !looksLikeHeader(cells) {
+		return false
+	}
+	cols := detectTableColumns(cells)
+	return cols.name >= 0 || cols.qty >= 0
+}
+
+func trimTrailingEmpty(cells []string) []string {
+	for len(cells) > 0 && cells[len(cells)-1] == "" {
+		cells = cells[:len(cells)-1]
+	}
+	return cells
+}
+
+func attrValue(el xml.StartElement, local string) string {
+	for _, a := range el.Attr {
+		if a.Name.Local == local {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+func attrInt(el xml.StartElement, local string, fallback int) int {
+	n, err := strconv.Atoi(attrValue(el, local))
+	if err != nil || n < 1 {
+		return fallback
+	}
+	return min(n, maxDocColumns)
+}
@@ -0,0 +1,94 @@
+package pipeline
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"elcom/internal"
+)
+
+func mkZip(name, body string) []byte {
+	buf := bytes.NewBuffer(nil)
+	zw := zip.NewWriter(buf)
+	w, _ := zw.Create(name)
+	_, _ = w.Write([]byte(body))
+	_ = zw.Close()
+	return buf.Bytes()
+}
+
+func docItemsSummary(items []internal.ExtractionItem) string {
+	parts := []string{}
+	for _, item := range items {
+		unit := ""
+		if item.Unit != nil {
+			unit = *item.Unit
+		}
+		parts = append(parts, fmt.Sprintf("%s/%g/%s@%v:%v", *item.NameOrCode, *item.Qty, unit, item.Meta["table"], item.Meta["rowNumber"]))
+	}
+	return strings.Join(parts, ", ")
+}
+
+func TestParseDOCXTables(t *testing.T) {
+	tc := func(text, props string) string {
+		return `<w:tc><w:tcPr>` + props + `</w:tcPr><w:p><w:r><w:t>` + text + `</w:t></w:r></w:p></w:tc>`
+	}
+	tr := func(cells ...string) string { return `<w:tr>` + strings.Join(cells, "") + `</w:tr>` }
+	body := `<?xml version="1.0" encoding="UTF-8"?>
+<w:document xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main"><w:body>
+<w:p><w:r><w:t>Техническое задание, 12 позиций</w:t></w:r></w:p>
+<w:tbl>` +
+		tr(tc("№", ""), tc("Наименование", ""), tc("Ед. изм.", ""), tc("Кол-во", "")) +
+		tr(tc("1", ""), tc("2", ""), tc("3", ""), tc("4", "")) +
+		tr(tc("Освещение", `<w:gridSpan w:val="4"/>`)) +
+		tr(tc("1", ""), tc("Светильник LED 36W", ""), tc("шт", `<w:vMerge w:val="restart"/>`), tc("12", "")) +
+		tr(tc("2", ""), tc("Лампа E27 11W", ""), tc("", `<w:vMerge/>`), tc("40", "")) +
+		`</w:tbl>
+<w:p><w:r><w:t>Продолжение таблицы</w:t></w:r></w:p>
+<w:tbl>` +
+		tr(tc("3", ""), tc("Кабель ВВГнг 3x2.5", ""), tc("м", ""), tc("150", "")) +
+		`</w:tbl></w:body></w:document>`
+
+	items, err := parseDOCX(mkZip("word/document.xml", body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "Светильник LED 36W/12/шт@1:4, Лампа E27 11W/40/шт@1:5, Кабель ВВГнг 3x2.5/150/м@2:1"
+	if got := docItemsSummary(items); got != want {
+		t.Fatalf("got  %s\nwant %s", got, want)
+	}
+	if items[0].Source != internal.SourceDocTable {
+		t.Fatalf("source=%s", items[0].Source)
+	}
+}
+
+func TestParseODTTables(t *testing.T) {
+	cell := func(text, attrs string) string {
+		return `<table:table-cell ` + attrs + `><text:p>` + text + `</text:p></table:table-cell>`
+	}
+	covered := `<table:covered-table-cell/>`
+	tr := func(cells ...string) string {
+		return `<table:table-row>` + strings.Join(cells, "") + `</table:table-row>`
+	}
+	body := `<?xml version="1.0" encoding="UTF-8"?>
+<office:document-content xmlns:office="urn:oasis:names:tc:opendocument:xmlns:office:1.0" xmlns:table="urn:oasis:names:tc:opendocument:xmlns:table:1.0" xmlns:text="urn:oasis:names:tc:opendocument:xmlns:text:1.0"><office:body><office:text>
+<table:table><table:table-header-rows>` +
+		tr(cell("Товар", ""), cell("Количество", ""), cell("Ед.", "")) +
+		`</table:table-header-rows>` +
+		tr(cell("Силовые автоматы", `table:number-columns-spanned="3"`), covered, covered) +
+		tr(cell("Автомат ABB S201 C16", ""), cell("20", ""), cell("шт", `table:number-rows-spanned="2"`)) +
+		tr(cell("Автомат ABB S201 C25", ""), cell("10", ""), covered) +
+		tr(cell("", `table:number-columns-repeated="3"`)) +
+		`</table:table></office:text></office:body></office:document-content>`
+
+	items, err := parseODT(mkZip("content.xml", body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "Автомат ABB S201 C16/20/шт@1:3, Автомат ABB S201 C25/10/шт@1:4"
+	if got := docItemsSummary(items); got != want {
+		t.Fatalf("got  %s\nwant %s", got, want)
+	}
+}
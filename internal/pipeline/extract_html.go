@@ -0,0 +1,140 @@
+package pipeline
+
+import (
+	"strings"
+
+	"github.com/jhillyerd/enmime"
+	"golang.org/x/net/html"
+
+	"elcom/internal"
+)
+
+// HTML-only mail keeps its items in lists, paragraphs and <br> lines as
+// often as in tables. htmlBlockLines turns the body into one line per block
+// so they go through the plain-text line rules, with blockquotes marked by
+// "> " like a plain-text reply so that body segmentation drops them. Data
+// tables are left to parseEmailHTMLTable; tables used for layout are read
+// row by row.
+
+// htmlBlockTags end the current line when they open or close.
+var htmlBlockTags = map[string]bool{
+	"address": true, "article": true, "blockquote": true, "br": true, "dd": true, "div": true,
+	"dl": true, "dt": true, "footer": true, "h1": true, "h2": true, "h3": true, "h4": true,
+	"h5": true, "h6": true, "header": true, "hr": true, "li": true, "ol": true, "p": true,
+	"pre": true, "section": true, "tr": true, "ul": true,
+}
+
+// htmlSkipTags hold no body text.
+var htmlSkipTags = map[string]bool{"head": true, "script": true, "style": true, "title": true}
+
+func htmlBlockLines(body string) []string {
+	doc, err := html.Parse(strings.NewReader(body))
+	if err != nil {
+		return nil
+	}
+	var (
+		lines []string
+		cur   strings.Builder
+		quote int
+		pre   int
+	)
+	flush := func() {
+		text := normalizeSpaces(cur.String())
+		cur.Reset()
+		if text == "" {
+			return
+		}
+		lines = append(lines, strings.Repeat("> ", quote)+text)
+	}
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		switch n.Type {
+		case html.TextNode:
+			if pre > 0 {
+				parts := strings.Split(n.Data, "\n")
+				for i, part := range parts {
+					if i > 0 {
+						flush()
+					}
+					cur.WriteString(part)
+				}
+				return
+			}
+			cur.WriteString(n.Data)
+			return
+		case html.ElementNode:
+			tag := n.Data
+			switch {
+			case htmlSkipTags[tag]:
+				return
+			case tag == "table" && isDataTable(n):
+				flush()
+				return
+			case tag == "td" || tag == "th":
+				cur.WriteString(" ")
+			}
+			if htmlBlockTags[tag] {
+				flush()
+			}
+			if tag == "blockquote" {
+				quote++
+				defer func() { quote-- }()
+			}
+			if tag == "pre" {
+				pre++
+				defer func() { pre-- }()
+			}
+			defer func() {
+				if htmlBlockTags[tag] {
+					flush()
+				}
+			}()
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+	flush()
+	return lines
+}
+
+// isDataTable reports whether parseEmailHTMLTable reads the table: at least
+// two rows and no nested table, which marks a layout table.
+func isDataTable(table *html.Node) bool {
+	rows := 0
+	var count func(n *html.Node) bool
+	count = func(n *html.Node) bool {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type != html.ElementNode {
+				continue
+			}
+			switch c.Data {
+			case "table":
+				return false
+			case "tr":
+				rows++
+			}
+			if !count(c) {
+				return false
+			}
+		}
+		return true
+	}
+	return count(table) && rows >= 2
+}
+
+// hasPlainTextPart tells a real text/plain body from the one enmime renders
+// out of the HTML when a message has none.
+func hasPlainTextPart(env *enmime.Envelope) bool {
+	if env.Root == nil {
+		return true
+	}
+	return env.Root.DepthMatchFirst(func(p *enmime.Part) bool {
+		return p.ContentType == "text/plain" && p.Disposition != "attachment"
+	}) != nil
+}
+
+func parseEmailHTMLText(text string) []internal.ExtractionItem {
+	return parseTextLines(internal.SourceEmailHTMLText, text)
+}
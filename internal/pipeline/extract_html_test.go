@@ -1,6 +1,10 @@
 package pipeline
 
-import "testing"
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
 
 func TestParseEmailHTMLTable(t *testing.T) {
 	html := `<table><tr><th>Наименование</th><th>Кол-во</th><th>Ед</th></tr><tr><td>ВВГнг 3х2.5</td><td>10</td><td>шт</td></tr></table>`
@@ -12,3 +16,69 @@ func TestParseEmailHTMLTable(t *testing.T) {
 		t.Fatalf("qty bad")
 	}
 }
+
+func TestExtractHTMLNestedTables(t *testing.T) {
+	raw := []byte("From: customer@example.com\r\nSubject: Zayavka\r\nContent-Type: text/html; charset=utf-8\r\n\r\n" +
+		"<table><tr><td>Наименование</td><td>Кол-во</td></tr>" +
+		"<tr><td>Кабель ВВГнг 3x2.5 150 м</td><td></td></tr>" +
+		"<tr><td><table><tr><th>Наименование</th><th>Кол-во</th></tr><tr><td>Щит ЩРН-12</td><td>2</td></tr></table></td><td></td></tr>" +
+		"</table>")
+	res, err := ExtractItemsFromEmailRaw(raw, ExtractOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Rows of the outer layout table are read once, as text lines.
+	parts := []string{}
+	for _, item := range res.Items {
+		qty := "-"
+		if item.Qty != nil {
+			qty = fmt.Sprint(*item.Qty)
+		}
+		parts = append(parts, fmt.Sprintf("%s/%s@%s", *item.NameOrCode, qty, item.Source))
+	}
+	want := "Щит ЩРН-12/2@email_html_table, Наименование Кол-во/-@email_html_text, Кабель ВВГнг 3x2.5/150@email_html_text"
+	if got := strings.Join(parts, ", "); got != want {
+		t.Fatalf("got  %s\nwant %s", got, want)
+	}
+}
+
+func TestHTMLBlockLines(t *testing.T) {
+	html := `<html><head><style>p{margin:0}</style></head><body>
+<p>Добрый день!</p><p>Прошу счёт:<br>Кабель ВВГнг 3x2.5 &mdash; 150 м<br/>Лампа <b>E27</b> 40 шт</p>
+<ul><li>Автомат ABB S201 C16 20 шт</li><li>Розетка 12 шт</li></ul>
+<table><tr><th>Наименование</th><th>Кол-во</th></tr><tr><td>Щит ЩРН-12</td><td>2</td></tr></table>
+<table><tr><td><div>Провод ПВС 2x1.5 5 бухт</div></td></tr></table>
+<pre>Гофра 16 мм
+50 м</pre>
+<div class="gmail_quote"><div class="gmail_attr">пн, 3 мар. 2025 г. в 10:15, Менеджер &lt;sales@example.ru&gt;:</div>
+<blockquote><p>Кабель 10 м</p></blockquote></div>
+</body></html>`
+	got := strings.Join(htmlBlockLines(html), "\n")
+	want := "Добрый день!\nПрошу счёт:\nКабель ВВГнг 3x2.5 — 150 м\nЛампа E27 40 шт\nАвтомат ABB S201 C16 20 шт\nРозетка 12 шт\n" +
+		"Провод ПВС 2x1.5 5 бухт\nГофра 16 мм\n50 м\nпн, 3 мар. 2025 г. в 10:15, Менеджер <sales@example.ru>:\n> Кабель 10 м"
+	if got != want {
+		t.Fatalf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestExtractHTMLOnlyBody(t *testing.T) {
+	raw := []byte("From: customer@example.com\r\nSubject: Zayavka\r\nContent-Type: text/html; charset=utf-8\r\n\r\n" +
+		"<p>Прошу счёт:</p><ol><li>Автомат ABB S201 C16 20 шт</li><li>Лампа E27 40 шт</li></ol>" +
+		"<table><tr><th>Наименование</th><th>Кол-во</th></tr><tr><td>Щит ЩРН-12</td><td>2</td></tr></table>" +
+		"<p>С уважением, Иван<br>ИНН 7701234567</p><blockquote>Кабель 10 м</blockquote>")
+	res, err := ExtractItemsFromEmailRaw(raw, ExtractOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := []string{}
+	for _, item := range res.Items {
+		if item.Qty == nil {
+			continue
+		}
+		parts = append(parts, fmt.Sprintf("%s/%g@%s", *item.NameOrCode, *item.Qty, item.Source))
+	}
+	want := "Щит ЩРН-12/2@email_html_table, Автомат ABB S201 C16/20@email_html_text, Лампа E27/40@email_html_text"
+	if got := strings.Join(parts, ", "); got != want {
+		t.Fatalf("got  %s\nwant %s", got, want)
+	}
+}
@@ -0,0 +1,132 @@
+package pipeline
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"elcom/internal/util"
+)
+
+// Long names wrap: "Автомат ABB S201\nC16 — 20 шт" is one position. groupLines
+// joins a line without a quantity with the lines that continue it before the
+// line with the quantity, so the item gets the whole name and the span of
+// source lines it came from.
+
+// maxGroupLines caps how many lines one position may wrap over.
+const maxGroupLines = 3
+
+var (
+	listMarker = regexp.MustCompile(`^(\d{1,3}[.)]|[-•*–])\s+`)
+	// openEnd marks a line that goes on below: a trailing comma, hyphen,
+	// slash, opening bracket or conjunction.
+	openEnd = regexp.MustCompile(`(?i)([,/(+–-]|\sи|\sс|\sдля)$`)
+	// closedEnd marks a sentence or a lead-in such as "Прошу счёт на:".
+	closedEnd = regexp.MustCompile(`[:.!?]$`)
+	// trailingNumber is a bare quantity ending a line, "... — 20", unlike the
+	// digits of "S201" or "3x2.5".
+	trailingNumber = regexp.MustCompile(`(^|[\s:;—–-])\d+([.,]\d+)?$`)
+)
+
+// logicalLine is one or more source lines read as one position; from and to
+// are 1-based line numbers.
+type logicalLine struct {
+	text     string
+	from, to int
+}
+
+func groupLines(text string) []logicalLine {
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	var (
+		out    []logicalLine
+		cur    *logicalLine
+		indent int
+		listed bool
+		parts  []string
+	)
+	flush := func() {
+		if cur != nil {
+			cur.text = normalizeSpaces(strings.Join(parts, " "))
+			out = append(out, *cur)
+		}
+		cur, parts = nil, nil
+	}
+	for i, raw := range lines {
+		line := strings.TrimSpace(raw)
+		if line == "" {
+			flush()
+			continue
+		}
+		marker := listMarker.MatchString(line)
+		if cur != nil && len(parts) < maxGroupLines && continuesLine(parts[len(parts)-1], line, leadingSpace(raw) > indent, listed && !marker) {
+			parts = append(parts, line)
+			cur.to = i + 1
+			continue
+		}
+		flush()
+		cur = &logicalLine{from: i + 1, to: i + 1}
+		indent, listed = leadingSpace(raw), marker
+		parts = []string{listMarker.ReplaceAllString(line, "")}
+	}
+	flush()
+	return out
+}
+
+// continuesLine reports whether next belongs to the position whose last
+// line is prev. A line with a quantity, a lead-in or noise ends a position;
+// after that, lowercase, indentation, an open end, an unmarked line under a
+// list item or a short tail carrying the quantity continue it.
+func continuesLine(prev, next string, indented, underListItem bool) bool {
+	if _, ok := lineQty(prev); ok || closedEnd.MatchString(prev) || isLikelyNoise(prev) || isLikelyNoise(next) {
+		return false
+	}
+	if listMarker.MatchString(next) {
+		return false
+	}
+	switch {
+	case startsLower(next), indented, openEnd.MatchString(prev), underListItem:
+		return true
+	}
+	raw, ok := lineQty(next)
+	if !ok {
+		return false
+	}
+	rest := strings.Fields(strings.Replace(next, raw, " ", 1))
+	return len(rest) <= 2
+}
+
+// lineQty returns the quantity of a line when it reads as one: a number
+// with a unit or a bare number at the end. util.ParseQty alone takes any
+// last number, model numbers included.
+func lineQty(line string) (string, bool) {
+	parsed := util.ParseQty(line)
+	if parsed.QtyRaw == nil {
+		return "", false
+	}
+	raw := *parsed.QtyRaw
+	if strings.IndexFunc(raw, unicode.IsLetter) >= 0 || trailingNumber.MatchString(line) {
+		return raw, true
+	}
+	return "", false
+}
+
+func startsLower(s string) bool {
+	r, _ := utf8.DecodeRuneInString(s)
+	return unicode.IsLower(r)
+}
+
+func leadingSpace(s string) int {
+	n := 0
+	for _, r := range s {
+		switch r {
+		case ' ':
+			n++
+		case '\t':
+			n += 4
+		default:
+			return n
+		}
+	}
+	return n
+}
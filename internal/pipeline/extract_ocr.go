@@ -0,0 +1,52 @@
+package pipeline
+
+import (
+	"strings"
+
+	"elcom/internal"
+	"elcom/internal/ocr"
+)
+
+// Phone photos and scans have no text to read, so when OCR is enabled
+// their recognised lines go through the same line parser as the email body.
+// Every item keeps the line's confidence, which the matcher uses to hold
+// back doubtful lines (see Matcher.adjustForOCR).
+
+var imageExtensions = []string{".jpg", ".jpeg", ".png", ".tif", ".tiff"}
+
+func isImageName(lower string) bool {
+	for _, ext := range imageExtensions {
+		if strings.HasSuffix(lower, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+func parseImage(content []byte, engine ocr.Engine) ([]internal.ExtractionItem, error) {
+	lines, err := engine.Image(content)
+	if err != nil {
+		return nil, err
+	}
+	lineNo := 0
+	return ocrItems(lines, 0, &lineNo), nil
+}
+
+// ocrItems keeps the lines that read as an item with a quantity; page is
+// recorded when it is not 0.
+func ocrItems(lines []ocr.Line, page int, lineNo *int) []internal.ExtractionItem {
+	out := []internal.ExtractionItem{}
+	for _, line := range lines {
+		*lineNo++
+		item := lineToExtractionItem(internal.SourceOCR, *lineNo, line.Text)
+		if item == nil || item.NameOrCode == nil || item.Qty == nil {
+			continue
+		}
+		item.Meta["ocrConfidence"] = line.Confidence
+		if page > 0 {
+			item.Meta["page"] = page
+		}
+		out = append(out, *item)
+	}
+	return out
+}
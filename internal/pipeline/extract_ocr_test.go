@@ -0,0 +1,85 @@
+package pipeline
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"elcom/internal"
+	"elcom/internal/ocr"
+)
+
+// fakeOCR answers every image with the same lines and records the PDF pages
+// it was asked to read.
+type fakeOCR struct {
+	lines []ocr.Line
+	pages []int
+	err   error
+}
+
+func (f *fakeOCR) Image([]byte) ([]ocr.Line, error) { return f.lines, f.err }
+
+func (f *fakeOCR) PDFPage(_ []byte, page int) ([]ocr.Line, error) {
+	f.pages = append(f.pages, page)
+	return f.lines, f.err
+}
+
+func TestExtractImageAttachmentWithOCR(t *testing.T) {
+	raw := mkMultipart(attachment{name: "IMG_2041.JPG", content: []byte("\xff\xd8\xff\xe0")})
+	engine := &fakeOCR{lines: []ocr.Line{
+		{Text: "Заявка на поставку", Confidence: 90},
+		{Text: "Кабель ВВГнг 3x2.5 150 м", Confidence: 88.5},
+		{Text: "Лампа E27 40 шт", Confidence: 47},
+	}}
+
+	res, err := ExtractItemsFromEmailRaw(raw, ExtractOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, item := range res.Items {
+		if item.Source == internal.SourceOCR {
+			t.Fatalf("image read without OCR: %+v", item)
+		}
+	}
+
+	res, err = ExtractItemsFromEmailRaw(raw, ExtractOptions{OCR: engine})
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := []string{}
+	for _, item := range res.Items {
+		if item.Source == internal.SourceOCR {
+			parts = append(parts, fmt.Sprintf("%s/%g@%v", *item.NameOrCode, *item.Qty, item.Meta["ocrConfidence"]))
+		}
+	}
+	if got := strings.Join(parts, ", "); got != "Кабель ВВГнг 3x2.5/150@88.5, Лампа E27/40@47" {
+		t.Fatalf("got %s", got)
+	}
+}
+
+func TestParsePDFRunsOCROnScannedPages(t *testing.T) {
+	blob := mkPDF(
+		[]pdfText{{40, 760, "Cable VVG 3x2.5 10 pcs"}},
+		nil, // a scanned page: no text layer
+	)
+	engine := &fakeOCR{lines: []ocr.Line{{Text: "Breaker ABB S201 C16 20 pcs", Confidence: 81}}}
+	items, err := parsePDF(blob, engine)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(engine.pages) != "[2]" || len(items) != 2 {
+		t.Fatalf("pages=%v items=%+v", engine.pages, items)
+	}
+	if items[1].Source != internal.SourceOCR || items[1].Meta["page"] != 2 || items[1].LineNo != 2 {
+		t.Fatalf("item=%+v", items[1])
+	}
+
+	engine = &fakeOCR{err: errors.New("tesseract: exit status 1")}
+	if _, err := parsePDF(mkPDF(nil), engine); err == nil || err.Error() != "ocr page 1: tesseract: exit status 1" {
+		t.Fatalf("err=%v", err)
+	}
+	if items, err := parsePDF(blob, engine); err != nil || len(items) != 1 {
+		t.Fatalf("items=%+v err=%v", items, err)
+	}
+}
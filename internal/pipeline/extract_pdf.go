@@ -0,0 +1,312 @@
+package pipeline
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	pdf "github.com/ledongthuc/pdf"
+
+	"elcom/internal"
+	"elcom/internal/ocr"
+	"elcom/internal/util"
+)
+
+// PDF specifications are usually tables, but the text layer only knows
+// glyphs and their positions. parsePDF rebuilds rows from the glyph
+// baselines and cells from the gaps between glyphs, finds a header row and
+// cuts every following row into the header's columns. Pages without a
+// header, and no header carried over from an earlier page, are read line
+// by line as before. Pages with no text at all are scans and go to the OCR
+// engine when there is one.
+
+const (
+	// pdfRowTolerance is how far, in font sizes, baselines of one row may drift.
+	pdfRowTolerance = 0.4
+	// pdfWordGap and pdfCellGap are the horizontal gaps, in font sizes, that
+	// separate words and cells.
+	pdfWordGap = 0.15
+	pdfCellGap = 1.0
+)
+
+// pdfSegment is a run of glyphs on one row without a cell-sized gap.
+type pdfSegment struct {
+	x0, x1 float64
+	text   string
+}
+
+type pdfRow struct {
+	y        float64
+	segments []pdfSegment
+}
+
+// pdfColumns is a header row laid out on the page: the cell texts, the
+// columns found in them and the x positions between neighbouring columns.
+type pdfColumns struct {
+	header []string
+	cols   tableColumns
+	bounds []float64
+}
+
+func parsePDF(content []byte, engine ocr.Engine) ([]internal.ExtractionItem, error) {
+	r, err := pdf.NewReader(bytes.NewReader(content), int64(len(content)))
+	if err != nil {
+		return nil, err
+	}
+
+	out := []internal.ExtractionItem{}
+	lineNo := 0
+	var (
+		layout *pdfColumns
+		ocrErr error
+	)
+	for i := 1; i <= r.NumPage(); i++ {
+		p := r.Page(i)
+		if p.V.IsNull() {
+			continue
+		}
+
+		var rows []pdfRow
+		if texts, err := pageContent(p); err == nil {
+			rows = pdfRows(texts)
+		}
+		start := 0
+		for ri, row := range rows {
+			if hdr := pdfHeader(row); hdr != nil {
+				layout, start = hdr, ri+1
+				break
+			}
+		}
+		if layout != nil && len(rows) > 0 {
+			out = append(out, layout.items(rows, start, i, &lineNo)...)
+			continue
+		}
+
+		// An unreadable text layer is treated like a missing one.
+		text, err := p.GetPlainText(nil)
+		if err != nil {
+			text = ""
+		}
+		if len(rows) == 0 && strings.TrimSpace(text) == "" {
+			if engine == nil {
+				continue
+			}
+			lines, err := engine.PDFPage(content, i)
+			if err != nil {
+				if ocrErr == nil {
+					ocrErr = fmt.Errorf("ocr page %d: %w", i, err)
+				}
+				continue
+			}
+			out = append(out, ocrItems(lines, i, &lineNo)...)
+			continue
+		}
+		for _, line := range groupLines(text) {
+			lineNo++
+			item := lineToExtractionItem(internal.SourcePDF, lineNo, line.text)
+			if item == nil {
+				continue
+			}
+			if item.NameOrCode == nil || item.Qty == nil {
+				continue
+			}
+			item.Meta["page"] = i
+			item.Meta["lineSpan"] = []int{line.from, line.to}
+			out = append(out, *item)
+		}
+	}
+	// A failed page only fails the file when nothing else was found in it.
+	if len(out) == 0 && ocrErr != nil {
+		return nil, ocrErr
+	}
+	return out, nil
+}
+
+// pageContent returns the positioned glyphs of a page; the reader panics on
+// content streams it cannot interpret.
+func pageContent(p pdf.Page) (texts []pdf.Text, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			texts, err = nil, fmt.Errorf("pdf: %v", r)
+		}
+	}()
+	return p.Content().Text, nil
+}
+
+// pdfRows groups glyphs into rows from top to bottom and each row into
+// segments from left to right.
+func pdfRows(texts []pdf.Text) []pdfRow {
+	glyphs := make([]pdf.Text, 0, len(texts))
+	for _, t := range texts {
+		if t.S != "" && t.FontSize > 0 {
+			glyphs = append(glyphs, t)
+		}
+	}
+	sort.SliceStable(glyphs, func(a, b int) bool { return glyphs[a].Y > glyphs[b].Y })
+
+	var rows [][]pdf.Text
+	for _, g := range glyphs {
+		n := len(rows)
+		if n > 0 && math.Abs(rows[n-1][0].Y-g.Y) <= pdfRowTolerance*g.FontSize {
+			rows[n-1] = append(rows[n-1], g)
+			continue
+		}
+		rows = append(rows, []pdf.Text{g})
+	}
+
+	out := make([]pdfRow, 0, len(rows))
+	for _, glyphs := range rows {
+		sort.SliceStable(glyphs, func(a, b int) bool { return glyphs[a].X < glyphs[b].X })
+		row := pdfRow{y: glyphs[0].Y}
+		var cur *pdfSegment
+		space := false
+		for _, g := range glyphs {
+			if strings.TrimSpace(g.S) == "" {
+				space = true
+				continue
+			}
+			w := g.W
+			if w <= 0 {
+				// Fonts without /Widths: assume an average glyph.
+				w = g.FontSize / 2
+			}
+			gap := 0.0
+			if cur != nil {
+				gap = g.X - cur.x1
+			}
+			switch {
+			case cur == nil || gap > pdfCellGap*g.FontSize:
+				row.segments = append(row.segments, pdfSegment{x0: g.X})
+				cur = &row.segments[len(row.segments)-1]
+			case space || gap > pdfWordGap*g.FontSize:
+				cur.text += " "
+			}
+			cur.text += g.S
+			cur.x1 = math.Max(cur.x1, g.X+w)
+			space = false
+		}
+		if len(row.segments) > 0 {
+			out = append(out, row)
+		}
+	}
+	return out
+}
+
+// pdfHeader returns the columns of row when it reads as a table header with
+// at least two cells.
+func pdfHeader(row pdfRow) *pdfColumns {
+	if len(row.segments) < 2 {
+		return nil
+	}
+	header := make([]string, len(row.segments))
+	for i, seg := range row.segments {
+		header[i] = normalizeSpaces(seg.text)
+	}
+	if !isTableHeader(header) {
+		return nil
+	}
+	bounds := make([]float64, 0, len(row.segments)-1)
+	for i := 1; i < len(row.segments); i++ {
+		bounds = append(bounds, (row.segments[i-1].x1+row.segments[i].x0)/2)
+	}
+	return &pdfColumns{header: header, cols: detectTableColumns(header), bounds: bounds}
+}
+
+// cells places each segment of row in the column its centre falls into, so
+// numbers centred or right-aligned under a short header still land there.
+func (c *pdfColumns) cells(row pdfRow) []string {
+	cells := make([]string, len(c.bounds)+1)
+	for _, seg := range row.segments {
+		col := sort.SearchFloat64s(c.bounds, (seg.x0+seg.x1)/2)
+		cells[col] = strings.TrimSpace(cells[col] + " " + normalizeSpaces(seg.text))
+	}
+	return cells
+}
+
+// items reads the rows of a page below the header from rows[start] on. A
+// name wrapped inside its cell comes as rows with only the name filled: one
+// starting in lowercase continues the item above, otherwise it is the start
+// of the name of the next row with a quantity. Name rows beyond
+// maxGroupLines, or left at the end of the page, become an item of their own
+// without a quantity. lineSpan holds the 1-based rows of the page an item was
+// read from.
+func (c *pdfColumns) items(rows []pdfRow, start, page int, lineNo *int) []internal.ExtractionItem {
+	out := []internal.ExtractionItem{}
+	header := strings.Join(c.header, " | ")
+	var (
+		pending  []string
+		from, to int
+		prev     *internal.ExtractionItem
+	)
+	emit := func(item *internal.ExtractionItem) {
+		*lineNo++
+		item.LineNo = *lineNo
+		item.Meta["page"] = page
+		item.Meta["columns"] = c.header
+		item.Meta["lineSpan"] = []int{from, to}
+		out = append(out, *item)
+		prev = &out[len(out)-1]
+	}
+	flush := func() {
+		name := strings.Join(pending, " ")
+		item := &internal.ExtractionItem{Source: internal.SourcePDFTable, RawLine: name, Meta: map[string]any{}}
+		setNameAndCode(item, name, "")
+		emit(item)
+		pending = nil
+	}
+	for ri := start; ri < len(rows); ri++ {
+		cells := c.cells(rows[ri])
+		joined := strings.Join(cells, " | ")
+		if joined == header || numberingRow.MatchString(joined) {
+			continue
+		}
+		if name := c.nameOnly(cells); name != "" {
+			switch {
+			case len(pending) == 0 && prev != nil && startsLower(name):
+				prev.NameOrCode = util.StringPtr(*prev.NameOrCode + " " + name)
+				prev.RawLine += " " + name
+				prev.Meta["lineSpan"].([]int)[1] = ri + 1
+			default:
+				if len(pending) == maxGroupLines-1 {
+					flush()
+				}
+				if len(pending) == 0 {
+					from = ri + 1
+				}
+				pending, to = append(pending, name), ri+1
+			}
+			continue
+		}
+		if len(pending) > 0 && c.cols.name >= 0 {
+			cells[c.cols.name] = strings.TrimSpace(strings.Join(pending, " ") + " " + cells[c.cols.name])
+		} else {
+			from = ri + 1
+		}
+		pending, to = nil, ri+1
+		item := tableRowItem(internal.SourcePDFTable, cells, c.cols)
+		if item == nil {
+			prev = nil
+			continue
+		}
+		emit(item)
+	}
+	if len(pending) > 0 {
+		flush()
+	}
+	return out
+}
+
+// nameOnly returns the name cell of a row that has nothing else, or "".
+func (c *pdfColumns) nameOnly(cells []string) string {
+	if c.cols.name < 0 || c.cols.name >= len(cells) {
+		return ""
+	}
+	for i, cell := range cells {
+		if i != c.cols.name && cell != "" {
+			return ""
+		}
+	}
+	return cells[c.cols.name]
+}
@@ -0,0 +1,159 @@
+package pipeline
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// pdfText is one string drawn at a position on a page.
+type pdfText struct {
+	x, y float64
+	s    string
+}
+
+// mkPDF writes a PDF with one page per entry, all text in 10pt Helvetica
+// with every glyph 5pt wide.
+func mkPDF(pages ...[]pdfText) []byte {
+	n := len(pages)
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"", // pages, filled in below
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /FirstChar 32 /LastChar 126 /Widths [" + strings.TrimSpace(strings.Repeat("500 ", 95)) + "] >>",
+	}
+	kids := []string{}
+	for i, texts := range pages {
+		var stream strings.Builder
+		for _, t := range texts {
+			fmt.Fprintf(&stream, "BT /F1 10 Tf 1 0 0 1 %g %g Tm (%s) Tj ET\n", t.x, t.y, t.s)
+		}
+		pageObj := 4 + 2*i
+		kids = append(kids, fmt.Sprintf("%d 0 R", pageObj))
+		objects = append(objects,
+			fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 595 842] /Resources << /Font << /F1 3 0 R >> >> /Contents %d 0 R >>", pageObj+1),
+			fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", stream.Len(), stream.String()),
+		)
+	}
+	objects[1] = fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), n)
+
+	var b bytes.Buffer
+	b.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, obj := range objects {
+		offsets[i] = b.Len()
+		fmt.Fprintf(&b, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+	xref := b.Len()
+	fmt.Fprintf(&b, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&b, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&b, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	return b.Bytes()
+}
+
+func TestParsePDFTableAcrossPages(t *testing.T) {
+	blob := mkPDF(
+		[]pdfText{
+			{40, 780, "Request for quotation 2026-02"},
+			{40, 700, "No"}, {80, 700, "Name"}, {300, 700, "Unit"}, {360, 700, "Qty"},
+			{40, 685, "1"}, {80, 685, "Cable VVG 3x2.5"}, {300, 685, "m"}, {362, 685, "150"},
+			{40, 670, "2"}, {80, 670, "Lamp LED E27"}, {300, 670, "pcs"}, {365, 670, "40"},
+		},
+		// The table goes on without repeating its header.
+		[]pdfText{
+			{40, 800, "3"}, {80, 800, "Breaker ABB S201 C16"}, {300, 800, "pcs"}, {365, 800, "20"},
+		},
+	)
+	items, err := parsePDF(blob, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := []string{}
+	for _, item := range items {
+		parts = append(parts, fmt.Sprintf("%s/%g/%s@%v", *item.NameOrCode, *item.Qty, *item.Unit, item.Meta["page"]))
+	}
+	want := "Cable VVG 3x2.5/150/m@1, Lamp LED E27/40/pcs@1, Breaker ABB S201 C16/20/pcs@2"
+	if got := strings.Join(parts, ", "); got != want {
+		t.Fatalf("got  %s\nwant %s", got, want)
+	}
+	if items[0].Source != "pdf_table" || fmt.Sprint(items[0].Meta["row"]) != "[1 Cable VVG 3x2.5 m 150]" {
+		t.Fatalf("item=%+v", items[0])
+	}
+}
+
+func TestParsePDFFallsBackToLines(t *testing.T) {
+	blob := mkPDF([]pdfText{
+		{40, 780, "Please quote:"},
+		{40, 760, "Cable VVG 3x2.5 10 pcs"},
+	})
+	items, err := parsePDF(blob, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 || items[0].Source != "pdf" || *items[0].Qty != 10 {
+		t.Fatalf("items=%+v", items)
+	}
+}
+
+func TestParsePDFWrappedNames(t *testing.T) {
+	table := mkPDF([]pdfText{
+		{40, 700, "No"}, {80, 700, "Name"}, {300, 700, "Unit"}, {360, 700, "Qty"},
+		// Quantity on the last line of the name, and a continuation below it.
+		{80, 685, "Circuit breaker ABB"},
+		{40, 673, "1"}, {80, 673, "S201 C16"}, {300, 673, "pcs"}, {365, 673, "20"},
+		{40, 658, "2"}, {80, 658, "Cable VVG 3x2.5"}, {300, 658, "m"}, {362, 658, "150"},
+		{80, 646, "fire resistant"},
+	})
+	lines := mkPDF([]pdfText{
+		{40, 780, "Please quote:"},
+		{40, 760, "Circuit breaker ABB S201"},
+		{40, 748, "C16 - 20 pcs"},
+	})
+	want := map[string]string{
+		"table": "Circuit breaker ABB S201 C16/20@[2 3], Cable VVG 3x2.5 fire resistant/150@[4 5]",
+		"lines": "Circuit breaker ABB S201 C16/20@[3 4]",
+	}
+	for name, blob := range map[string][]byte{"table": table, "lines": lines} {
+		items, err := parsePDF(blob, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		parts := []string{}
+		for _, item := range items {
+			parts = append(parts, fmt.Sprintf("%s/%g@%v", *item.NameOrCode, *item.Qty, item.Meta["lineSpan"]))
+		}
+		if got := strings.Join(parts, ", "); got != want[name] {
+			t.Errorf("%s:\ngot  %s\nwant %s", name, got, want[name])
+		}
+	}
+}
+
+func TestParsePDFNameWrappedPastLimit(t *testing.T) {
+	blob := mkPDF([]pdfText{
+		{40, 700, "No"}, {80, 700, "Name"}, {300, 700, "Unit"}, {360, 700, "Qty"},
+		{80, 685, "Cable VVGng"},
+		{80, 673, "LS 3x2.5"},
+		{80, 661, "Power Cable"},
+		{80, 649, "Copper"},
+		{40, 637, "1"}, {80, 637, "GOST 31996"}, {300, 637, "m"}, {362, 637, "100"},
+		{80, 622, "Note on delivery"},
+	})
+	items, err := parsePDF(blob, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := []string{}
+	for _, item := range items {
+		qty := "-"
+		if item.Qty != nil {
+			qty = fmt.Sprint(*item.Qty)
+		}
+		parts = append(parts, fmt.Sprintf("%s/%s@%v", *item.NameOrCode, qty, item.Meta["lineSpan"]))
+	}
+	want := "Cable VVGng LS 3x2.5/-@[2 3], Power Cable Copper GOST 31996/100@[4 6], Note on delivery/-@[7 7]"
+	if got := strings.Join(parts, ", "); got != want {
+		t.Fatalf("got  %s\nwant %s", got, want)
+	}
+}
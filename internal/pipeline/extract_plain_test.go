@@ -1,6 +1,12 @@
 package pipeline
 
-import "testing"
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"elcom/internal/util"
+)
 
 func TestParseEmailText(t *testing.T) {
 	text := "\nВВГнг 3х2.5 100 шт\nКабель NYM 10 м\n"
@@ -25,3 +31,55 @@ func TestParseEmailTextCodeLike(t *testing.T) {
 		t.Fatalf("nameOrCode=%v", items[0].NameOrCode)
 	}
 }
+
+func TestParseEmailTextWrappedLines(t *testing.T) {
+	text := "Прошу счёт на:\n1. Автомат ABB S201\n   C16 — 20 шт\n2. Кабель ВВГнг-LS 3x2.5\nнегорючий, 150 м\n\nЛампа E27 40 шт\nВыключатель Legrand Valena\nбелый 10 шт\n"
+	items := parseEmailText(text)
+	got := []string{}
+	for _, item := range items {
+		if item.Qty == nil {
+			continue
+		}
+		got = append(got, fmt.Sprintf("%s/%g@%v", *item.NameOrCode, *item.Qty, item.Meta["lineSpan"]))
+	}
+	want := "Автомат ABB S201 C16/20@[2 3], Кабель ВВГнг-LS 3x2.5 негорючий/150@[4 5], Лампа E27/40@[7 7], Выключатель Legrand Valena белый/10@[8 9]"
+	if strings.Join(got, ", ") != want {
+		t.Fatalf("got  %s\nwant %s", strings.Join(got, ", "), want)
+	}
+}
+
+func TestParseEmailTextCodes(t *testing.T) {
+	items := parseEmailText("Автомат ABB S201 C16 арт. 2CDS251001R0164 — 20 шт\nВыключатель Schneider EZ9F34116 10 шт\nКабель ВВГнг 3x2.5 150 м\nКод товара: ЭК-12345, 5 шт")
+	got := []string{}
+	for _, item := range items {
+		got = append(got, fmt.Sprintf("%s|%s|%s", derefString(item.Name), derefString(item.Code), *item.NameOrCode))
+	}
+	want := []string{
+		"Автомат ABB S201 C16|2CDS251001R0164|Автомат ABB S201 C16",
+		"Выключатель Schneider EZ9F34116|EZ9F34116|Выключатель Schneider EZ9F34116",
+		"Кабель ВВГнг 3x2.5||Кабель ВВГнг 3x2.5",
+		"|ЭК-12345|ЭК-12345",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("got\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestParseEmailTextQtyGrammar(t *testing.T) {
+	items := parseEmailText("Кабель ВВГнг 3x2.5 две бухты по 100 м\nЛампа E27 5-6 шт\nРозетка Legrand десять штук")
+	got := []string{}
+	for _, item := range items {
+		part := fmt.Sprintf("%s/%g/%s", *item.NameOrCode, *item.Qty, *item.Unit)
+		if item.Meta["qtyMin"] != nil {
+			part += fmt.Sprintf(" %v-%v", item.Meta["qtyMin"], item.Meta["qtyMax"])
+		}
+		if pack, ok := item.Meta["packaging"].(util.Packaging); ok {
+			part += fmt.Sprintf(" %g %s по %g", pack.Count, pack.Unit, *pack.Size)
+		}
+		got = append(got, part)
+	}
+	want := "Кабель ВВГнг 3x2.5/200/м 2 бухта по 100, Лампа E27/6/шт 5-6, Розетка Legrand/10/шт"
+	if strings.Join(got, ", ") != want {
+		t.Fatalf("got  %s\nwant %s", strings.Join(got, ", "), want)
+	}
+}
@@ -0,0 +1,289 @@
+package pipeline
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/xuri/excelize/v2"
+
+	"elcom/internal"
+	"elcom/internal/util"
+)
+
+// Real specifications put a letterhead, the customer and a title above the
+// table, split the header over two rows with merged cells, number the rows
+// in a "№ п/п" column and end with totals and signatures. sheetItems looks
+// for the header anywhere in the sheet, joins a second header row into the
+// first, and stops at the first totals or footer row.
+
+// footerRow matches the first cell of totals and signature rows.
+var footerRow = regexp.MustCompile(`(?i)^(итого|итог|всего|в том числе|в т\.\s?ч\.|сумма ндс|ндс|total|subtotal|исполнитель|ответственный|руководитель|главный бухгалтер|подпись|м\.\s?п\.)([\s:.,(]|$)`)
+
+// cellRange is a merged block of cells, 0-based and inclusive.
+type cellRange struct {
+	row0, col0, row1, col1 int
+}
+
+// sheetItems turns the rows of one spreadsheet into items, numbering them
+// from *lineNo on. Rows above the header are skipped; without a header,
+// columns 0, 1 and 2 (shifted past a running-number column) are taken as
+// name, qty and unit.
+func sheetItems(source internal.ItemSource, sheet string, rows [][]string, lineNo *int) []internal.ExtractionItem {
+	norm := make([][]string, len(rows))
+	for i, row := range rows {
+		norm[i] = normalizeCells(row)
+	}
+	header, first, cols := findSheetHeader(norm)
+	if header == nil {
+		first = 0
+		cols = tableColumns{name: 0, qty: 1, unit: 2, code: -1, number: -1}
+		if isNumberingColumn(norm, 0) {
+			cols = tableColumns{name: 1, qty: 2, unit: 3, code: -1, number: 0}
+		}
+	}
+	headerLine := strings.Join(header, " | ")
+
+	out := []internal.ExtractionItem{}
+	for i := first; i < len(norm); i++ {
+		cells := norm[i]
+		if isEmptyRow(cells) {
+			continue
+		}
+		if isFooterRow(cells) {
+			break
+		}
+		joined := strings.Join(trimTrailingEmpty(cells), " | ")
+		if joined == headerLine || numberingRow.MatchString(joined) {
+			continue
+		}
+
+		name := pickCell(cells, cols.name, cols.firstDataColumn())
+		code := pickCell(cells, cols.code, -1)
+		if name == "" {
+			name = code
+		}
+		qtyCell := pickCell(cells, cols.qty, -1)
+		if qtyCell == "" {
+			rest := []string{}
+			for c, cell := range cells {
+				if c != cols.number && c != cols.code {
+					rest = append(rest, cell)
+				}
+			}
+			qtyCell = strings.Join(rest, " ")
+		}
+		parsed := util.ParseQty(qtyCell)
+		if strings.TrimSpace(name) == "" || parsed.Qty == nil {
+			continue
+		}
+
+		*lineNo++
+		item := internal.ExtractionItem{
+			LineNo:  *lineNo,
+			Source:  source,
+			RawLine: strings.Join(cells, " | "),
+			Qty:     parsed.Qty,
+			Unit:    parsed.Unit,
+			Meta:    map[string]any{"rowNumber": i + 1},
+		}
+		qtyMeta(item.Meta, parsed)
+		setNameAndCode(&item, pickCell(cells, cols.name, cols.firstDataColumn()), code)
+		if item.NameOrCode == nil {
+			item.NameOrCode = util.StringPtr(name)
+		}
+		if sheet != "" {
+			item.Meta["sheet"] = sheet
+		}
+		if unit := pickCell(cells, cols.unit, -1); unit != "" {
+			item.Unit = util.StringPtr(unit)
+		}
+		out = append(out, item)
+	}
+	return out
+}
+
+// findSheetHeader returns the header cells, the first row below the header
+// and its columns, or a nil header. The row naming the most columns wins,
+// the topmost among equals; a second header row directly below or above it
+// is joined in.
+func findSheetHeader(rows [][]string) (header []string, first int, cols tableColumns) {
+	best, bestScore := -1, 0
+	for i, cells := range rows {
+		if !isHeaderCandidate(cells) {
+			continue
+		}
+		c := detectTableColumns(cells)
+		if c.name < 0 && c.qty < 0 {
+			continue
+		}
+		if score := c.score(); score > bestScore {
+			best, bestScore = i, score
+		}
+	}
+	if best < 0 {
+		return nil, 0, tableColumns{}
+	}
+
+	header, first = rows[best], best+1
+	switch {
+	case best+1 < len(rows) && isSubHeader(rows[best], rows[best+1]):
+		header = combineHeaderRows(rows[best], rows[best+1])
+		first = best + 2
+	case best > 0 && isHeaderCandidate(rows[best-1]) && isSubHeader(rows[best-1], rows[best]):
+		if joined := combineHeaderRows(rows[best-1], rows[best]); detectTableColumns(joined).score() > bestScore {
+			header = joined
+		}
+	}
+	return header, first, detectTableColumns(header)
+}
+
+func (c tableColumns) score() int {
+	n := 0
+	for _, idx := range []int{c.name, c.qty, c.unit, c.code} {
+		if idx >= 0 {
+			n++
+		}
+	}
+	return n
+}
+
+// isHeaderCandidate rejects rows with numbers and titles: a header names at
+// least two different columns, while a merged title repeats one text.
+func isHeaderCandidate(cells []string) bool {
+	if !looksLikeHeader(cells) {
+		return false
+	}
+	distinct := map[string]bool{}
+	for _, c := range cells {
+		if c != "" {
+			distinct[c] = true
+		}
+	}
+	return len(distinct) >= 2
+}
+
+// isSubHeader reports whether next continues the header top: a row without
+// numbers with a cell under an empty or merged top cell, or repeating a
+// vertically merged one. A cell starting with a digit ("10 шт") makes next
+// a data row.
+func isSubHeader(top, next []string) bool {
+	if !looksLikeHeader(next) || isEmptyRow(next) || isFooterRow(next) {
+		return false
+	}
+	for _, cell := range next {
+		if cell != "" && cell[0] >= '0' && cell[0] <= '9' {
+			return false
+		}
+	}
+	for c, cell := range next {
+		if cell == "" {
+			continue
+		}
+		above := cellAt(top, c)
+		if above == "" || above == cell || (c > 0 && cellAt(top, c-1) == above) || cellAt(top, c+1) == above {
+			return true
+		}
+	}
+	return false
+}
+
+// combineHeaderRows joins two header rows column by column, so "Количество"
+// over "заказ" becomes "Количество заказ".
+func combineHeaderRows(top, next []string) []string {
+	out := make([]string, max(len(top), len(next)))
+	for c := range out {
+		a, b := cellAt(top, c), cellAt(next, c)
+		switch {
+		case b == "" || b == a:
+			out[c] = a
+		case a == "":
+			out[c] = b
+		default:
+			out[c] = a + " " + b
+		}
+	}
+	return out
+}
+
+func isFooterRow(cells []string) bool {
+	for _, c := range cells {
+		if c != "" {
+			return footerRow.MatchString(c)
+		}
+	}
+	return false
+}
+
+// isNumberingColumn reports whether col holds 1, 2, 3... in the rows that
+// have it, i.e. a running number rather than a name.
+func isNumberingColumn(rows [][]string, col int) bool {
+	want := 1
+	for _, row := range rows {
+		cell := cellAt(row, col)
+		if cell == "" {
+			continue
+		}
+		n, err := strconv.Atoi(strings.TrimSuffix(cell, "."))
+		if err != nil || n != want {
+			return false
+		}
+		want++
+	}
+	return want > 2
+}
+
+func isEmptyRow(cells []string) bool {
+	for _, c := range cells {
+		if c != "" {
+			return false
+		}
+	}
+	return true
+}
+
+func cellAt(cells []string, c int) string {
+	if c >= 0 && c < len(cells) {
+		return cells[c]
+	}
+	return ""
+}
+
+// fillMergedCells copies the value of each merged block into all its cells,
+// as readers see it, growing rows where needed.
+func fillMergedCells(rows [][]string, merges []cellRange) [][]string {
+	for _, m := range merges {
+		if m.row0 >= len(rows) || m.col0 >= len(rows[m.row0]) {
+			continue
+		}
+		value := rows[m.row0][m.col0]
+		if value == "" {
+			continue
+		}
+		for r := m.row0; r <= m.row1 && r < len(rows); r++ {
+			for c := m.col0; c <= m.col1 && c < maxDocColumns; c++ {
+				for len(rows[r]) <= c {
+					rows[r] = append(rows[r], "")
+				}
+				rows[r][c] = value
+			}
+		}
+	}
+	return rows
+}
+
+func xlsxMergedRanges(merges []excelize.MergeCell) []cellRange {
+	out := make([]cellRange, 0, len(merges))
+	for _, m := range merges {
+		c0, r0, err := excelize.CellNameToCoordinates(m.GetStartAxis())
+		if err != nil {
+			continue
+		}
+		c1, r1, err := excelize.CellNameToCoordinates(m.GetEndAxis())
+		if err != nil {
+			continue
+		}
+		out = append(out, cellRange{row0: r0 - 1, col0: c0 - 1, row1: r1 - 1, col1: c1 - 1})
+	}
+	return out
+}
@@ -0,0 +1,458 @@
+package pipeline
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/richardlehane/mscfb"
+
+	"elcom/internal"
+)
+
+// Excel 97-2003 workbooks are OLE2 compound files holding a BIFF8 record
+// stream named "Workbook". Only cell values are read; formats, styles and
+// formulas themselves are ignored, which is all the column inference needs.
+
+const (
+	biffFormula    = 0x0006
+	biffEOF        = 0x000A
+	biffFilePass   = 0x002F
+	biffContinue   = 0x003C
+	biffBoundSheet = 0x0085
+	biffMulRK      = 0x00BD
+	biffMergeCells = 0x00E5
+	biffSST        = 0x00FC
+	biffLabelSST   = 0x00FD
+	biffNumber     = 0x0203
+	biffLabel      = 0x0204
+	biffBoolErr    = 0x0205
+	biffString     = 0x0207
+	biffRK         = 0x027E
+	biffBOF        = 0x0809
+
+	biff8Version = 0x0600
+)
+
+// oleMagic starts every compound file, .xls and encrypted .xlsx alike.
+var oleMagic = []byte{0xD0, 0xCF, 0x11, 0xE0, 0xA1, 0xB1, 0x1A, 0xE1}
+
+var errXLSTruncated = errors.New("xls: truncated record")
+
+type biffRecord struct {
+	typ    uint16
+	offset int
+	data   []byte
+}
+
+type xlsSheet struct {
+	name string
+	rows [][]string
+}
+
+// parseXLS reads every worksheet of a BIFF8 workbook and runs the same column
+// inference as parseXLSX over its rows.
+func parseXLS(content []byte) ([]internal.ExtractionItem, error) {
+	sheets, err := readXLS(content)
+	if err != nil {
+		return nil, err
+	}
+	lineNo := 0
+	out := []internal.ExtractionItem{}
+	for _, sheet := range sheets {
+		out = append(out, sheetItems(internal.SourceXLS, sheet.name, sheet.rows, &lineNo)...)
+	}
+	return out, nil
+}
+
+func readXLS(content []byte) ([]xlsSheet, error) {
+	doc, err := mscfb.New(bytes.NewReader(content))
+	if err != nil {
+		return nil, fmt.Errorf("xls: %w", err)
+	}
+	var stream []byte
+	for entry, next := doc.Next(); next == nil && stream == nil; entry, next = doc.Next() {
+		switch entry.Name {
+		case "Workbook":
+			if stream, err = io.ReadAll(entry); err != nil {
+				return nil, fmt.Errorf("xls: %w", err)
+			}
+		case "Book":
+			return nil, errors.New("xls: BIFF5 workbooks (Excel 95 and older) are not supported")
+		}
+	}
+	if stream == nil {
+		return nil, errors.New("xls: no Workbook stream")
+	}
+
+	records, err := splitBIFFRecords(stream)
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 || records[0].typ != biffBOF || len(records[0].data) < 2 ||
+		binary.LittleEndian.Uint16(records[0].data) != biff8Version {
+		return nil, errors.New("xls: not a BIFF8 workbook")
+	}
+
+	byOffset := make(map[int]int, len(records))
+	for i, rec := range records {
+		byOffset[rec.offset] = i
+	}
+
+	type sheetRef struct {
+		name   string
+		offset int
+	}
+	var (
+		refs []sheetRef
+		sst  []string
+	)
+	for i := 1; i < len(records) && records[i].typ != biffEOF; i++ {
+		rec := records[i]
+		switch rec.typ {
+		case biffFilePass:
+			return nil, errors.New("xls: workbook is password protected")
+		case biffBoundSheet:
+			if len(rec.data) < 8 {
+				return nil, errXLSTruncated
+			}
+			// Only worksheets (dt=0) hold cells; skip charts and macro sheets.
+			if rec.data[5] != 0 {
+				continue
+			}
+			name, err := readXLString(rec.data[6:], 1)
+			if err != nil {
+				return nil, err
+			}
+			refs = append(refs, sheetRef{name: name, offset: int(binary.LittleEndian.Uint32(rec.data))})
+		case biffSST:
+			segments := [][]byte{rec.data}
+			for j := i + 1; j < len(records) && records[j].typ == biffContinue; j++ {
+				segments = append(segments, records[j].data)
+			}
+			if sst, err = readSST(segments); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	sheets := make([]xlsSheet, 0, len(refs))
+	for _, ref := range refs {
+		start, ok := byOffset[ref.offset]
+		if !ok {
+			return nil, fmt.Errorf("xls: sheet %q has no substream", ref.name)
+		}
+		rows, err := readXLSCells(records[start+1:], sst)
+		if err != nil {
+			return nil, fmt.Errorf("xls: sheet %q: %w", ref.name, err)
+		}
+		sheets = append(sheets, xlsSheet{name: ref.name, rows: rows})
+	}
+	return sheets, nil
+}
+
+func splitBIFFRecords(stream []byte) ([]biffRecord, error) {
+	var out []biffRecord
+	for pos := 0; pos+4 <= len(stream); {
+		typ := binary.LittleEndian.Uint16(stream[pos:])
+		size := int(binary.LittleEndian.Uint16(stream[pos+2:]))
+		if typ == 0 && size == 0 {
+			// Zero padding after the last substream.
+			break
+		}
+		if pos+4+size > len(stream) {
+			return nil, errXLSTruncated
+		}
+		out = append(out, biffRecord{typ: typ, offset: pos, data: stream[pos+4 : pos+4+size]})
+		pos += 4 + size
+	}
+	return out, nil
+}
+
+// readXLSCells collects the cell values of one worksheet substream into rows
+// shaped like excelize's GetRows: one slice per row up to the last used one,
+// with merged blocks filled in.
+func readXLSCells(records []biffRecord, sst []string) ([][]string, error) {
+	cells := map[int]map[int]string{}
+	var merges []cellRange
+	set := func(row, col int, value string) {
+		if value == "" {
+			return
+		}
+		if cells[row] == nil {
+			cells[row] = map[int]string{}
+		}
+		cells[row][col] = value
+	}
+
+	// A string formula result arrives in the STRING record that follows.
+	pendingRow, pendingCol := -1, -1
+	for _, rec := range records {
+		if rec.typ == biffEOF {
+			break
+		}
+		d := rec.data
+		if rec.typ != biffString && rec.typ != biffContinue {
+			pendingRow, pendingCol = -1, -1
+		}
+		switch rec.typ {
+		case biffLabelSST:
+			if len(d) < 10 {
+				return nil, errXLSTruncated
+			}
+			if idx := int(binary.LittleEndian.Uint32(d[6:])); idx < len(sst) {
+				set(cellRow(d), cellCol(d), sst[idx])
+			}
+		case biffLabel:
+			if len(d) < 6 {
+				return nil, errXLSTruncated
+			}
+			value, err := readXLString(d[6:], 2)
+			if err != nil {
+				return nil, err
+			}
+			set(cellRow(d), cellCol(d), value)
+		case biffNumber:
+			if len(d) < 14 {
+				return nil, errXLSTruncated
+			}
+			set(cellRow(d), cellCol(d), formatXLSNumber(math.Float64frombits(binary.LittleEndian.Uint64(d[6:]))))
+		case biffRK:
+			if len(d) < 10 {
+				return nil, errXLSTruncated
+			}
+			set(cellRow(d), cellCol(d), formatXLSNumber(rkValue(binary.LittleEndian.Uint32(d[6:]))))
+		case biffMulRK:
+			if len(d) < 6 {
+				return nil, errXLSTruncated
+			}
+			row, col := cellRow(d), cellCol(d)
+			for pos := 4; pos+6 <= len(d)-2; pos += 6 {
+				set(row, col, formatXLSNumber(rkValue(binary.LittleEndian.Uint32(d[pos+2:]))))
+				col++
+			}
+		case biffBoolErr:
+			if len(d) < 8 {
+				return nil, errXLSTruncated
+			}
+			if d[7] == 0 {
+				set(cellRow(d), cellCol(d), strconv.FormatBool(d[6] != 0))
+			}
+		case biffFormula:
+			if len(d) < 14 {
+				return nil, errXLSTruncated
+			}
+			row, col := cellRow(d), cellCol(d)
+			if d[12] != 0xFF || d[13] != 0xFF {
+				set(row, col, formatXLSNumber(math.Float64frombits(binary.LittleEndian.Uint64(d[6:]))))
+				continue
+			}
+			switch d[6] {
+			case 0:
+				pendingRow, pendingCol = row, col
+			case 1:
+				set(row, col, strconv.FormatBool(d[8] != 0))
+			}
+		case biffMergeCells:
+			if len(d) < 2 {
+				return nil, errXLSTruncated
+			}
+			n := int(binary.LittleEndian.Uint16(d))
+			for i := 0; i < n && 2+8*i+8 <= len(d); i++ {
+				r := d[2+8*i:]
+				merges = append(merges, cellRange{
+					row0: int(binary.LittleEndian.Uint16(r)),
+					row1: int(binary.LittleEndian.Uint16(r[2:])),
+					col0: int(binary.LittleEndian.Uint16(r[4:])),
+					col1: int(binary.LittleEndian.Uint16(r[6:])),
+				})
+			}
+		case biffString:
+			if pendingRow < 0 {
+				continue
+			}
+			value, err := readXLString(d, 2)
+			if err != nil {
+				return nil, err
+			}
+			set(pendingRow, pendingCol, value)
+			pendingRow, pendingCol = -1, -1
+		}
+	}
+
+	lastRow := -1
+	for row := range cells {
+		lastRow = max(lastRow, row)
+	}
+	rows := make([][]string, lastRow+1)
+	for row, values := range cells {
+		lastCol := -1
+		for col := range values {
+			lastCol = max(lastCol, col)
+		}
+		rows[row] = make([]string, lastCol+1)
+		for col, value := range values {
+			rows[row][col] = value
+		}
+	}
+	return fillMergedCells(rows, merges), nil
+}
+
+func cellRow(d []byte) int { return int(binary.LittleEndian.Uint16(d)) }
+func cellCol(d []byte) int { return int(binary.LittleEndian.Uint16(d[2:])) }
+
+// rkValue decodes the compressed RK number: a 30-bit integer or the high
+// bits of an IEEE double, optionally scaled by 1/100.
+func rkValue(rk uint32) float64 {
+	var v float64
+	if rk&0x02 != 0 {
+		v = float64(int32(rk) >> 2)
+	} else {
+		v = math.Float64frombits(uint64(rk&0xFFFFFFFC) << 32)
+	}
+	if rk&0x01 != 0 {
+		v /= 100
+	}
+	return v
+}
+
+func formatXLSNumber(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// readXLString reads a string whose character count takes lenSize bytes,
+// followed by an option byte and the characters.
+func readXLString(d []byte, lenSize int) (string, error) {
+	if len(d) < lenSize+1 {
+		return "", errXLSTruncated
+	}
+	n := int(d[0])
+	if lenSize == 2 {
+		n = int(binary.LittleEndian.Uint16(d))
+	}
+	wide := d[lenSize]&0x01 != 0
+	pos := lenSize + 1
+	size := n
+	if wide {
+		size *= 2
+	}
+	if pos+size > len(d) {
+		return "", errXLSTruncated
+	}
+	return decodeXLChars(d[pos:pos+size], wide), nil
+}
+
+// decodeXLChars decodes UTF-16LE, or the "compressed" form that stores only
+// the low byte of each character.
+func decodeXLChars(b []byte, wide bool) string {
+	if !wide {
+		runes := make([]rune, len(b))
+		for i, c := range b {
+			runes[i] = rune(c)
+		}
+		return string(runes)
+	}
+	units := make([]uint16, len(b)/2)
+	for i := range units {
+		units[i] = binary.LittleEndian.Uint16(b[2*i:])
+	}
+	return string(utf16.Decode(units))
+}
+
+// sstReader walks the shared string table across its CONTINUE records. A
+// string's characters may break at a record boundary, in which case the next
+// record starts with a fresh option byte saying whether they are wide.
+type sstReader struct {
+	segments [][]byte
+	seg, pos int
+}
+
+func (r *sstReader) next(n int) ([]byte, error) {
+	out := make([]byte, 0, n)
+	for n > 0 {
+		if r.pos == len(r.segments[r.seg]) {
+			if r.seg+1 == len(r.segments) {
+				return nil, errXLSTruncated
+			}
+			r.seg, r.pos = r.seg+1, 0
+		}
+		take := min(n, len(r.segments[r.seg])-r.pos)
+		out = append(out, r.segments[r.seg][r.pos:r.pos+take]...)
+		r.pos += take
+		n -= take
+	}
+	return out, nil
+}
+
+func (r *sstReader) chars(n int, wide bool) (string, error) {
+	var b strings.Builder
+	for n > 0 {
+		if r.pos == len(r.segments[r.seg]) {
+			if r.seg+1 == len(r.segments) || len(r.segments[r.seg+1]) == 0 {
+				return "", errXLSTruncated
+			}
+			r.seg, r.pos = r.seg+1, 1
+			wide = r.segments[r.seg][0]&0x01 != 0
+		}
+		size := 1
+		if wide {
+			size = 2
+		}
+		take := min(n, (len(r.segments[r.seg])-r.pos)/size)
+		if take == 0 {
+			return "", errXLSTruncated
+		}
+		b.WriteString(decodeXLChars(r.segments[r.seg][r.pos:r.pos+take*size], wide))
+		r.pos += take * size
+		n -= take
+	}
+	return b.String(), nil
+}
+
+func readSST(segments [][]byte) ([]string, error) {
+	r := &sstReader{segments: segments}
+	head, err := r.next(8)
+	if err != nil {
+		return nil, err
+	}
+	unique := int(binary.LittleEndian.Uint32(head[4:]))
+	out := make([]string, 0, min(unique, 1<<16))
+	for i := 0; i < unique; i++ {
+		h, err := r.next(3)
+		if err != nil {
+			return nil, err
+		}
+		n, flags := int(binary.LittleEndian.Uint16(h)), h[2]
+		runs, ext := 0, 0
+		if flags&0x08 != 0 {
+			b, err := r.next(2)
+			if err != nil {
+				return nil, err
+			}
+			runs = int(binary.LittleEndian.Uint16(b))
+		}
+		if flags&0x04 != 0 {
+			b, err := r.next(4)
+			if err != nil {
+				return nil, err
+			}
+			ext = int(binary.LittleEndian.Uint32(b))
+		}
+		s, err := r.chars(n, flags&0x01 != 0)
+		if err != nil {
+			return nil, err
+		}
+		// Rich-text runs and phonetic data are not needed.
+		if _, err := r.next(4*runs + ext); err != nil {
+			return nil, err
+		}
+		out = append(out, s)
+	}
+	return out, nil
+}
@@ -0,0 +1,268 @@
+package pipeline
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+	"unicode/utf16"
+)
+
+// mkXLS writes a one-sheet BIFF8 workbook inside a minimal compound file.
+// Integers become RK cells, other numbers NUMBER cells and strings go to the
+// shared string table, whose last string is split across a CONTINUE record.
+// merges become one MERGEDCELLS record.
+func mkXLS(sheet string, rows [][]any, merges ...cellRange) []byte {
+	rec := func(typ uint16, data []byte) []byte {
+		out := binary.LittleEndian.AppendUint16(nil, typ)
+		out = binary.LittleEndian.AppendUint16(out, uint16(len(data)))
+		return append(out, data...)
+	}
+	bof := func(dt uint16) []byte {
+		data := binary.LittleEndian.AppendUint16(nil, biff8Version)
+		data = binary.LittleEndian.AppendUint16(data, dt)
+		return rec(biffBOF, append(data, make([]byte, 12)...))
+	}
+	cell := func(r, c int) []byte {
+		out := binary.LittleEndian.AppendUint16(nil, uint16(r))
+		out = binary.LittleEndian.AppendUint16(out, uint16(c))
+		return binary.LittleEndian.AppendUint16(out, 0)
+	}
+	wide := func(s string) []byte {
+		var out []byte
+		for _, u := range utf16.Encode([]rune(s)) {
+			out = binary.LittleEndian.AppendUint16(out, u)
+		}
+		return out
+	}
+
+	var strs []string
+	var cells []byte
+	for r, row := range rows {
+		for c, v := range row {
+			switch v := v.(type) {
+			case string:
+				data := binary.LittleEndian.AppendUint32(cell(r, c), uint32(len(strs)))
+				cells = append(cells, rec(biffLabelSST, data)...)
+				strs = append(strs, v)
+			case int:
+				data := binary.LittleEndian.AppendUint32(cell(r, c), uint32(v)<<2|0x02)
+				cells = append(cells, rec(biffRK, data)...)
+			case float64:
+				data := binary.LittleEndian.AppendUint64(cell(r, c), math.Float64bits(v))
+				cells = append(cells, rec(biffNumber, data)...)
+			}
+		}
+	}
+
+	sst := binary.LittleEndian.AppendUint32(nil, uint32(len(strs)))
+	sst = binary.LittleEndian.AppendUint32(sst, uint32(len(strs)))
+	var cont []byte
+	for i, s := range strs {
+		units := utf16.Encode([]rune(s))
+		sst = binary.LittleEndian.AppendUint16(sst, uint16(len(units)))
+		sst = append(sst, 0x01)
+		chars := wide(s)
+		if i == len(strs)-1 && len(units) > 1 {
+			half := len(units) / 2 * 2
+			sst = append(sst, chars[:half]...)
+			cont = append([]byte{0x01}, chars[half:]...)
+			continue
+		}
+		sst = append(sst, chars...)
+	}
+
+	nameField := []byte{byte(len([]rune(sheet))), 0x01}
+	nameField = append(nameField, wide(sheet)...)
+	boundSheet := func(offset int) []byte {
+		data := binary.LittleEndian.AppendUint32(nil, uint32(offset))
+		return rec(biffBoundSheet, append(append(data, 0, 0), nameField...))
+	}
+
+	globals := bof(0x0005)
+	globals = append(globals, boundSheet(0)...)
+	globals = append(globals, rec(biffSST, sst)...)
+	if cont != nil {
+		globals = append(globals, rec(biffContinue, cont)...)
+	}
+	globals = append(globals, rec(biffEOF, nil)...)
+	globals = bytes.Replace(globals, boundSheet(0), boundSheet(len(globals)), 1)
+
+	if len(merges) > 0 {
+		data := binary.LittleEndian.AppendUint16(nil, uint16(len(merges)))
+		for _, m := range merges {
+			for _, v := range []int{m.row0, m.row1, m.col0, m.col1} {
+				data = binary.LittleEndian.AppendUint16(data, uint16(v))
+			}
+		}
+		cells = append(cells, rec(biffMergeCells, data)...)
+	}
+
+	stream := append(globals, bof(0x0010)...)
+	stream = append(stream, cells...)
+	stream = append(stream, rec(biffEOF, nil)...)
+	return mkCompoundFile("Workbook", stream)
+}
+
+// mkCompoundFile stores one stream in a version 3 compound file: sector 0
+// is the FAT, sector 1 the directory and the stream follows. Streams are
+// padded past the 4096-byte mini stream cutoff.
+func mkCompoundFile(name string, stream []byte) []byte {
+	const (
+		sector     = 512
+		freeSect   = 0xFFFFFFFF
+		endOfChain = 0xFFFFFFFE
+		fatSect    = 0xFFFFFFFD
+	)
+	size := max(len(stream), 4096)
+	stream = append(stream, make([]byte, (size+sector-1)/sector*sector-len(stream))...)
+	n := len(stream) / sector
+
+	header := make([]byte, sector)
+	copy(header, oleMagic)
+	le := binary.LittleEndian
+	le.PutUint16(header[24:], 0x003E)
+	le.PutUint16(header[26:], 3)
+	le.PutUint16(header[28:], 0xFFFE)
+	le.PutUint16(header[30:], 9)
+	le.PutUint16(header[32:], 6)
+	le.PutUint32(header[44:], 1)
+	le.PutUint32(header[48:], 1)
+	le.PutUint32(header[56:], 4096)
+	le.PutUint32(header[60:], endOfChain)
+	le.PutUint32(header[68:], endOfChain)
+	le.PutUint32(header[76:], 0)
+	for off := 80; off < sector; off += 4 {
+		le.PutUint32(header[off:], freeSect)
+	}
+
+	fat := make([]byte, sector)
+	for i := 0; i < sector/4; i++ {
+		next := uint32(freeSect)
+		switch {
+		case i == 0:
+			next = fatSect
+		case i == 1 || i == n+1:
+			next = endOfChain
+		case i < n+1:
+			next = uint32(i + 1)
+		}
+		le.PutUint32(fat[4*i:], next)
+	}
+
+	entry := func(name string, typ byte, child, start uint32, size int) []byte {
+		e := make([]byte, 128)
+		units := utf16.Encode([]rune(name))
+		for i, u := range units {
+			le.PutUint16(e[2*i:], u)
+		}
+		le.PutUint16(e[64:], uint16(2*len(units)+2))
+		e[66], e[67] = typ, 1
+		le.PutUint32(e[68:], freeSect)
+		le.PutUint32(e[72:], freeSect)
+		le.PutUint32(e[76:], child)
+		le.PutUint32(e[116:], start)
+		le.PutUint64(e[120:], uint64(size))
+		return e
+	}
+	dir := entry("Root Entry", 5, 1, endOfChain, 0)
+	dir = append(dir, entry(name, 2, freeSect, 2, size)...)
+	dir = append(dir, make([]byte, sector-len(dir))...)
+	for off := 2 * 128; off < sector; off += 128 {
+		le.PutUint32(dir[off+68:], freeSect)
+		le.PutUint32(dir[off+72:], freeSect)
+		le.PutUint32(dir[off+76:], freeSect)
+	}
+
+	out := append(header, fat...)
+	out = append(out, dir...)
+	return append(out, stream...)
+}
+
+func TestParseXLS(t *testing.T) {
+	blob := mkXLS("Заявка", [][]any{
+		{"Наименование", "Кол-во", "Ед"},
+		{"Кабель ВВГнг 3x2.5", 10, "м"},
+		{"Провод ПВС 2x1.5", 2.5, "бухта"},
+		{"Итого", "", ""},
+	})
+	items, err := parseXLS(blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 2 {
+		t.Fatalf("items=%+v", items)
+	}
+	got := fmt.Sprintf("%s/%g/%s %s/%g/%s", *items[0].NameOrCode, *items[0].Qty, *items[0].Unit, *items[1].NameOrCode, *items[1].Qty, *items[1].Unit)
+	if got != "Кабель ВВГнг 3x2.5/10/м Провод ПВС 2x1.5/2.5/бухта" {
+		t.Fatalf("got %s", got)
+	}
+	if items[0].Source != "xls" || items[0].Meta["sheet"] != "Заявка" || items[1].Meta["rowNumber"] != 3 {
+		t.Fatalf("item=%+v", items[0])
+	}
+}
+
+func TestExtractRecordsFailedAttachments(t *testing.T) {
+	xls := mkXLS("Лист1", [][]any{{"Наименование", "Количество"}, {"Автомат ABB S201 C16", 20}})
+	// An .xls that is really an .xlsx, the other way round, and neither.
+	raw := mkMultipart(
+		attachment{"spec.xls", mkXLSX([][]any{{"Наименование", "Кол-во"}, {"Провод ПВС 2x1.5", 5}})},
+		attachment{"old.xlsx", xls},
+		attachment{"broken.xls", []byte("not a spreadsheet")},
+	)
+
+	res, err := ExtractItemsFromEmailRaw(raw, ExtractOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, item := range res.Items {
+		if att, ok := item.Meta["attachment"].(string); ok {
+			names = append(names, *item.NameOrCode+"@"+att)
+		}
+	}
+	if got := strings.Join(names, ","); got != "Провод ПВС 2x1.5@spec.xls,Автомат ABB S201 C16@old.xlsx" {
+		t.Fatalf("items=%s", got)
+	}
+	if len(res.Failures) != 1 || res.Failures[0].Attachment != "broken.xls" || res.Failures[0].Error == "" {
+		t.Fatalf("failures=%+v", res.Failures)
+	}
+}
+
+type attachment struct {
+	name    string
+	content []byte
+}
+
+// mkMultipart builds a message with a short text body and attachments.
+func mkMultipart(attachments ...attachment) []byte {
+	var b bytes.Buffer
+	b.WriteString("From: customer@example.com\r\nSubject: Zayavka\r\nMIME-Version: 1.0\r\nContent-Type: multipart/mixed; boundary=b\r\n\r\n")
+	b.WriteString("--b\r\nContent-Type: text/plain; charset=utf-8\r\n\r\nСпецификация во вложении\r\n")
+	for _, att := range attachments {
+		fmt.Fprintf(&b, "--b\r\nContent-Type: application/octet-stream\r\nContent-Disposition: attachment; filename=%q\r\nContent-Transfer-Encoding: base64\r\n\r\n", att.name)
+		b.WriteString(base64.StdEncoding.EncodeToString(att.content))
+		b.WriteString("\r\n")
+	}
+	b.WriteString("--b--\r\n")
+	return b.Bytes()
+}
+
+func TestParseXLSMergedHeader(t *testing.T) {
+	// "Наименование" spans both header rows, "Количество" both sub-columns.
+	blob := mkXLS("Лист1", [][]any{
+		{"Наименование", "Количество", "", "Ед."},
+		{"", "заказ", "резерв", ""},
+		{"Лампа E27 11W", 40, 5, "шт"},
+	}, cellRange{row0: 0, row1: 1, col0: 0, col1: 0}, cellRange{row0: 0, row1: 0, col0: 1, col1: 2}, cellRange{row0: 0, row1: 1, col0: 3, col1: 3})
+	items, err := parseXLS(blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 || *items[0].Qty != 40 || *items[0].Unit != "шт" {
+		t.Fatalf("items=%+v", items)
+	}
+}
@@ -2,6 +2,8 @@ package pipeline
 
 import (
 	"bytes"
+	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/xuri/excelize/v2"
@@ -35,3 +37,64 @@ func TestParseXLSX(t *testing.T) {
 		t.Fatalf("len=%d", len(items))
 	}
 }
+
+func TestParseXLSXLetterheadAndTotals(t *testing.T) {
+	f := excelize.NewFile()
+	sheet := f.GetSheetName(0)
+	rows := [][]any{
+		{"ООО «Ромашка»"},
+		{"ИНН 7701234567, г. Москва, ул. Ленина, 1"},
+		{},
+		{"Спецификация товаров к договору № 15"},
+		{},
+		{"№ п/п", "Код товара", "Наименование", "Количество", "", "Ед. изм."},
+		{"", "", "", "по заявке", "в т.ч. срочно", ""},
+		{"1", "2", "3", "4", "5", "6"},
+		{1, "ELC0100203802", "Кабель ВВГнг 3x2.5", 150, 50, "м"},
+		{2, "", "Лампа E27 11W", 40, "", "шт"},
+		{3, "ABB-S201-C16", "", 20, "", "шт"},
+		{"Итого", "", "", 210},
+		{"Исполнитель", "", "Иванов И.И."},
+		{4, "", "Строка под подписью", 1, "", "шт"},
+	}
+	for r, row := range rows {
+		for c, v := range row {
+			cell, _ := excelize.CoordinatesToCellName(c+1, r+1)
+			_ = f.SetCellValue(sheet, cell, v)
+		}
+	}
+	_ = f.MergeCell(sheet, "A4", "F4")
+	_ = f.MergeCell(sheet, "A6", "A7")
+	_ = f.MergeCell(sheet, "B6", "B7")
+	_ = f.MergeCell(sheet, "C6", "C7")
+	_ = f.MergeCell(sheet, "D6", "E6")
+	_ = f.MergeCell(sheet, "F6", "F7")
+	buf := bytes.NewBuffer(nil)
+	_, _ = f.WriteTo(buf)
+
+	items, err := parseXLSX(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := []string{}
+	for _, item := range items {
+		parts = append(parts, fmt.Sprintf("%s/%g/%s/%s@%v", *item.NameOrCode, *item.Qty, *item.Unit, derefString(item.Code), item.Meta["rowNumber"]))
+	}
+	want := "Кабель ВВГнг 3x2.5/150/м/ELC0100203802@9, Лампа E27 11W/40/шт/@10, ABB-S201-C16/20/шт/ABB-S201-C16@11"
+	if got := strings.Join(parts, ", "); got != want {
+		t.Fatalf("got  %s\nwant %s", got, want)
+	}
+}
+
+func TestParseXLSXNumberedRowsWithoutHeader(t *testing.T) {
+	items, err := parseXLSX(mkXLSX([][]any{
+		{1, "Кабель ВВГнг 3x2.5", 150, "м"},
+		{2, "Лампа E27 11W", 40, "шт"},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 2 || *items[0].NameOrCode != "Кабель ВВГнг 3x2.5" || *items[1].Qty != 40 || *items[1].Unit != "шт" {
+		t.Fatalf("items=%+v", items)
+	}
+}
@@ -1,6 +1,8 @@
 package pipeline
 
 import (
+	"errors"
+	"math"
 	"sort"
 
 	"elcom/internal"
@@ -19,19 +21,19 @@ func NewMatcher(cfg config.Config, products []internal.ProductRecord) *Matcher {
 }
 
 func (m *Matcher) Match(item NormalizedItem) internal.MatchResult {
+	return m.adjustForMultiplicity(m.adjustForUnit(item, m.adjustForOCR(item, m.match(item))))
+}
+
+func (m *Matcher) match(item NormalizedItem) internal.MatchResult {
 	normalized := item.NormalizedNameOrCode
 	if normalized == "" {
 		normalized = util.NormalizeHeader(item.RawLine)
 	}
 
-	nameOrCode := ""
-	if item.NameOrCode != nil {
-		nameOrCode = *item.NameOrCode
-	}
-	codeCandidate := util.NormalizeCode(nameOrCode)
-
-	if util.LooksLikeCode(nameOrCode) && codeCandidate != "" {
-		byCode := m.index.ByCode[codeCandidate]
+	// Each extracted code is tried before the name: a line naming the
+	// product and its article is matched by the article.
+	for _, code := range itemCodes(item.ExtractionItem) {
+		byCode := m.index.ByCode[util.NormalizeCode(code)]
 		if len(byCode) == 1 {
 			result := internal.MatchResult{
 				Status:     internal.MatchOK,
@@ -109,6 +111,96 @@ func (m *Matcher) adjustForInvalidQty(item NormalizedItem, base internal.MatchRe
 	return base
 }
 
+// adjustForOCR sends lines recognised below OCR_MIN_CONFIDENCE to review
+// when OCR_LOW_CONFIDENCE_REVIEW is set: a misread digit still matches a
+// product, just the wrong one.
+func (m *Matcher) adjustForOCR(item NormalizedItem, base internal.MatchResult) internal.MatchResult {
+	conf, ok := item.Meta["ocrConfidence"].(float64)
+	if !ok || !m.cfg.OCRLowConfidenceReview || conf >= m.cfg.OCRMinConfidence || base.Status != internal.MatchOK {
+		return base
+	}
+	base.Status = internal.MatchReview
+	if base.Confidence > 0.7 {
+		base.Confidence = 0.7
+	}
+	return base
+}
+
+// adjustForUnit converts the requested quantity to the unit the product is
+// sold in; a line without a unit is taken to be in it already. A quantity
+// that does not convert, kilograms of a cable sold by the metre or packs of
+// a product without a pack size, goes to review with a warning.
+func (m *Matcher) adjustForUnit(item NormalizedItem, base internal.MatchResult) internal.MatchResult {
+	if base.Product == nil || base.Product.ID == nil || item.Qty == nil {
+		return base
+	}
+	product := m.index.ProductsByID[*base.Product.ID]
+	base.ConvertedQty, base.ConvertedUnit = item.Qty, item.Unit
+	if product.UnitHeader == nil {
+		return base
+	}
+	to, ok := util.LookupUnit(*product.UnitHeader)
+	if !ok {
+		return base
+	}
+	if item.Unit == nil {
+		base.ConvertedUnit = product.UnitHeader
+		return base
+	}
+	from, ok := util.LookupUnit(*item.Unit)
+	if !ok {
+		return base
+	}
+	packSize := 0.0
+	if product.PackSize != nil {
+		packSize = *product.PackSize
+	}
+	qty, err := util.ConvertQty(*item.Qty, from, to, packSize)
+	if err == nil {
+		base.ConvertedQty, base.ConvertedUnit = &qty, product.UnitHeader
+		return base
+	}
+	warning := internal.WarningUnitMismatch
+	if errors.Is(err, util.ErrPackSizeUnknown) {
+		warning = internal.WarningPackSize
+	}
+	base.ConvertedQty, base.ConvertedUnit = nil, nil
+	base.Warnings = append(base.Warnings, warning)
+	base.Status = internal.MatchReview
+	if base.Confidence > 0.7 {
+		base.Confidence = 0.7
+	}
+	return base
+}
+
+// adjustForMultiplicity rounds the converted quantity up to the product's
+// order multiplicity: 15 pieces of a product sold in tens are quoted as 20
+// and marked MULTIPLICITY, and sent to review when MATCH_MULTIPLICITY_REVIEW
+// is set.
+func (m *Matcher) adjustForMultiplicity(base internal.MatchResult) internal.MatchResult {
+	if base.Product == nil || base.Product.ID == nil || base.ConvertedQty == nil {
+		return base
+	}
+	qty := *base.ConvertedQty
+	base.OrderableQty = &qty
+	product := m.index.ProductsByID[*base.Product.ID]
+	if product.MultiplicityOrder == nil || *product.MultiplicityOrder <= 0 {
+		return base
+	}
+	mult := *product.MultiplicityOrder
+	// Rounded to 1e-6 so that 0.3 in multiples of 0.1 stays 0.3.
+	orderable := math.Round(math.Ceil(qty/mult-1e-9)*mult*1e6) / 1e6
+	if math.Abs(orderable-qty) < 1e-9 {
+		return base
+	}
+	base.OrderableQty = &orderable
+	base.Warnings = append(base.Warnings, internal.WarningMultiplicity)
+	if m.cfg.MultiplicityReview && base.Status == internal.MatchOK {
+		base.Status = internal.MatchReview
+	}
+	return base
+}
+
 func (m *Matcher) rankCandidates(query string) []internal.MatchCandidate {
 	queryTokens := util.Tokenize(query)
 	ids := map[int]struct{}{}
@@ -1,6 +1,7 @@
 package pipeline
 
 import (
+	"fmt"
 	"testing"
 
 	"elcom/internal"
@@ -25,3 +26,116 @@ func TestMatcher(t *testing.T) {
 		t.Fatalf("unexpected result: %+v", res)
 	}
 }
+
+func TestMatcherSendsLowConfidenceOCRToReview(t *testing.T) {
+	products := []internal.ProductRecord{{ID: 1, SyncUID: sp("sync-1"), Header: "Кабель ВВГнг 3x2.5", Articul: sp("ELC0100203802")}}
+	cfg, _ := config.Load()
+	cfg.OCRMinConfidence = 70
+	cfg.OCRLowConfidenceReview = true
+	m := NewMatcher(cfg, products)
+
+	qty := 2.0
+	item := func(conf float64) NormalizedItem {
+		return NormalizedItem{ExtractionItem: internal.ExtractionItem{LineNo: 1, Source: internal.SourceOCR, RawLine: "ELC0100203802 2 шт", NameOrCode: sp("ELC0100203802"), Qty: &qty, Meta: map[string]any{"ocrConfidence": conf}}, NormalizedNameOrCode: util.NormalizeHeader("ELC0100203802")}
+	}
+	if res := m.Match(item(91)); res.Status != internal.MatchOK {
+		t.Fatalf("confident line: %+v", res)
+	}
+	if res := m.Match(item(52)); res.Status != internal.MatchReview || res.Confidence > 0.7 || res.Product == nil {
+		t.Fatalf("doubtful line: %+v", res)
+	}
+
+	cfg.OCRLowConfidenceReview = false
+	if res := NewMatcher(cfg, products).Match(item(52)); res.Status != internal.MatchOK {
+		t.Fatalf("review disabled: %+v", res)
+	}
+}
+
+func TestMatcherTriesExtractedCodes(t *testing.T) {
+	products := []internal.ProductRecord{
+		{ID: 1, SyncUID: sp("sync-1"), Header: "Автоматический выключатель ABB S201 C16 1P", Articul: sp("2CDS251001R0164")},
+		{ID: 2, SyncUID: sp("sync-2"), Header: "Автоматический выключатель ABB S201 C10 1P", Articul: sp("2CDS251001R0104")},
+	}
+	cfg, _ := config.Load()
+	m := NewMatcher(cfg, products)
+
+	items := NormalizeItems(parseEmailText("Автомат ABB S201 C16 арт. 2CDS251001R0164 — 20 шт"))
+	if len(items) != 1 {
+		t.Fatalf("items=%+v", items)
+	}
+	res := m.Match(items[0])
+	if res.Status != internal.MatchOK || res.Reason != internal.ReasonCode || *res.Product.ID != 1 {
+		t.Fatalf("unexpected result: %+v", res)
+	}
+}
+
+func TestMatcherConvertsUnits(t *testing.T) {
+	products := []internal.ProductRecord{
+		{ID: 1, Header: "Кабель ВВГнг 3x2.5", Articul: sp("ELC0100203802"), UnitHeader: sp("м")},
+		{ID: 2, Header: "Саморез 4.2x19", Articul: sp("ELC0200300001"), UnitHeader: sp("шт."), PackSize: util.FloatPtr(200)},
+		{ID: 3, Header: "Хомут 3.6x200", Articul: sp("ELC0200300002"), UnitHeader: sp("шт")},
+	}
+	cfg, _ := config.Load()
+	m := NewMatcher(cfg, products)
+
+	cases := []struct {
+		line, want string
+	}{
+		{"ELC0100203802 1 км", "OK 1000 м"},
+		{"ELC0100203802 150", "OK 150 м"},
+		{"ELC0200300001 3 упаковки", "OK 600 шт."},
+		{"ELC0200300002 2 уп", "REVIEW PACK_SIZE_UNKNOWN"},
+		{"ELC0100203802 5 кг", "REVIEW UNIT_MISMATCH"},
+	}
+	for _, tc := range cases {
+		items := NormalizeItems(parseEmailText(tc.line))
+		if len(items) != 1 {
+			t.Fatalf("%s: items=%+v", tc.line, items)
+		}
+		res := m.Match(items[0])
+		got := string(res.Status)
+		if res.ConvertedQty != nil {
+			got += fmt.Sprintf(" %g %s", *res.ConvertedQty, *res.ConvertedUnit)
+		}
+		for _, w := range res.Warnings {
+			got += " " + w
+		}
+		if got != tc.want {
+			t.Errorf("%s: got %q want %q", tc.line, got, tc.want)
+		}
+	}
+}
+
+func TestMatcherRoundsUpToMultiplicity(t *testing.T) {
+	products := []internal.ProductRecord{
+		{ID: 1, Header: "Хомут 3.6x200", Articul: sp("ELC0200300002"), UnitHeader: sp("шт"), MultiplicityOrder: util.FloatPtr(10)},
+		{ID: 2, Header: "Кабель ВВГнг 3x2.5", Articul: sp("ELC0100203802"), UnitHeader: sp("м"), MultiplicityOrder: util.FloatPtr(0.1)},
+	}
+	cfg, _ := config.Load()
+
+	cases := []struct {
+		line, want string
+		review     bool
+	}{
+		{"ELC0200300002 15 шт", "OK 15 20 MULTIPLICITY", false},
+		{"ELC0200300002 15 шт", "REVIEW 15 20 MULTIPLICITY", true},
+		{"ELC0200300002 30 шт", "OK 30 30", true},
+		{"ELC0100203802 0,3 км", "OK 300 300", true},
+		{"ELC0100203802 12,25 м", "OK 12.25 12.3 MULTIPLICITY", false},
+	}
+	for _, tc := range cases {
+		cfg.MultiplicityReview = tc.review
+		items := NormalizeItems(parseEmailText(tc.line))
+		if len(items) != 1 {
+			t.Fatalf("%s: items=%+v", tc.line, items)
+		}
+		res := NewMatcher(cfg, products).Match(items[0])
+		got := fmt.Sprintf("%s %g %g", res.Status, *res.ConvertedQty, *res.OrderableQty)
+		for _, w := range res.Warnings {
+			got += " " + w
+		}
+		if got != tc.want {
+			t.Errorf("%s (review=%v): got %q want %q", tc.line, tc.review, got, tc.want)
+		}
+	}
+}
@@ -19,12 +19,24 @@ func ExtractItemsFromInput(inputType string, input string) ([]internal.Extractio
 			return nil, err
 		}
 		return parseXLSX(blob)
+	case "xls":
+		blob, err := os.ReadFile(input)
+		if err != nil {
+			return nil, err
+		}
+		return parseXLS(blob)
+	case "csv":
+		blob, err := os.ReadFile(input)
+		if err != nil {
+			return nil, err
+		}
+		return parseCSV(blob)
 	case "pdf":
 		blob, err := os.ReadFile(input)
 		if err != nil {
 			return nil, err
 		}
-		return parsePDF(blob)
+		return parsePDF(blob, nil)
 	default:
 		return nil, fmt.Errorf("unsupported input type: %s", inputType)
 	}
@@ -14,17 +14,19 @@ import (
 )
 
 type ProcessingService struct {
-	db  *storage.DB
-	cfg config.Config
+	db      *storage.DB
+	cfg     config.Config
+	extract ExtractOptions
 }
 
 func NewProcessingService(db *storage.DB, cfg config.Config) *ProcessingService {
-	return &ProcessingService{db: db, cfg: cfg}
+	return &ProcessingService{db: db, cfg: cfg, extract: NewExtractOptions(cfg)}
 }
 
 type ProcessResult struct {
-	EmailID   int
-	Processed int
+	EmailID            int
+	Processed          int
+	AttachmentFailures []AttachmentFailure
 }
 
 func (s *ProcessingService) ProcessByProviderMessageID(provider, messageID string) (ProcessResult, error) {
@@ -35,17 +37,16 @@ func (s *ProcessingService) ProcessByProviderMessageID(provider, messageID strin
 	return s.ProcessEmail(email)
 }
 
-func (s *ProcessingService) ProcessPending(limit int, provider string) (int, int, error) {
-	pending, err := s.db.ListEmailsByStatus("fetched", limit)
+// ProcessPending processes fetched emails of provider, and of mailbox when
+// it is not empty.
+func (s *ProcessingService) ProcessPending(limit int, provider, mailbox string) (int, int, error) {
+	pending, err := s.db.ListEmailsByStatus("fetched", provider, mailbox, limit)
 	if err != nil {
 		return 0, 0, err
 	}
 	processedEmails := 0
 	processedLines := 0
 	for _, email := range pending {
-		if provider != "" && email.Provider != provider {
-			continue
-		}
 		res, err := s.ProcessEmail(email)
 		if err != nil {
 			return processedEmails, processedLines, err
@@ -63,23 +64,30 @@ func (s *ProcessingService) ProcessEmail(email internal.EmailRow) (ProcessResult
 		return ProcessResult{}, err
 	}
 
-	items, subject, text, attachmentNames, err := ExtractItemsFromEmailRaw(raw)
+	extracted, err := ExtractItemsFromEmailRaw(raw, s.extract)
 	if err != nil {
 		return ProcessResult{}, err
 	}
+	details := map[string]any{}
+	if len(extracted.Failures) > 0 {
+		details["attachmentFailures"] = extracted.Failures
+	}
+	if len(extracted.Segments) > 0 {
+		details["bodySegments"] = extracted.Segments
+	}
 
-	detect := DetectQuoteRequest(firstNonEmpty(subject, email.Subject), text, "", attachmentNames)
+	detect := DetectQuoteRequest(firstNonEmpty(extracted.Subject, email.Subject), extracted.Text, "", extracted.Attachments, s.cfg.QuoteDetectThreshold)
 	if err := s.db.ClearEmailProcessing(email.ID); err != nil {
 		return ProcessResult{}, err
 	}
 
 	if !detect.IsQuote {
 		_ = s.db.UpdateEmailStatus(email.ID, "skipped")
-		_ = s.db.InsertRun(traceID(), email.ID, map[string]float64{"totalMs": float64(time.Since(start).Milliseconds())}, map[string]int{"extracted": 0, "ok": 0, "review": 0, "notFound": 0})
-		return ProcessResult{EmailID: email.ID, Processed: 0}, nil
+		_ = s.db.InsertRun(traceID(), email.ID, map[string]float64{"totalMs": float64(time.Since(start).Milliseconds())}, map[string]int{"extracted": 0, "ok": 0, "review": 0, "notFound": 0}, details)
+		return ProcessResult{EmailID: email.ID, Processed: 0, AttachmentFailures: extracted.Failures}, nil
 	}
 
-	normalized := NormalizeItems(items)
+	normalized := NormalizeItems(extracted.Items)
 	products, err := s.db.ListProducts()
 	if err != nil {
 		return ProcessResult{}, err
@@ -110,9 +118,9 @@ func (s *ProcessingService) ProcessEmail(email internal.EmailRow) (ProcessResult
 	if err := s.db.UpdateEmailStatus(email.ID, "processed"); err != nil {
 		return ProcessResult{}, err
 	}
-	_ = s.db.InsertRun(traceID(), email.ID, map[string]float64{"totalMs": float64(time.Since(start).Milliseconds())}, map[string]int{"extracted": len(normalized), "ok": okCount, "review": reviewCount, "notFound": notFoundCount})
+	_ = s.db.InsertRun(traceID(), email.ID, map[string]float64{"totalMs": float64(time.Since(start).Milliseconds())}, map[string]int{"extracted": len(normalized), "ok": okCount, "review": reviewCount, "notFound": notFoundCount}, details)
 
-	return ProcessResult{EmailID: email.ID, Processed: len(normalized)}, nil
+	return ProcessResult{EmailID: email.ID, Processed: len(normalized), AttachmentFailures: extracted.Failures}, nil
 }
 
 func traceID() string {
@@ -0,0 +1,190 @@
+package pipeline
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Replies carry the whole thread below the new text, and every reply ends
+// in a signature with addresses and company numbers. segmentBody splits a
+// plain-text body into segments so that only the customer's new lines are
+// read as items; the segments themselves are stored with the run.
+//
+// Once reply history starts it runs to the end of the body. A forwarded
+// message is not history: a manager forwarding a request with "see below"
+// wants the forwarded lines read, so they start a new content segment with
+// its own signature and history.
+
+const (
+	segmentNew       = "new"
+	segmentForwarded = "forwarded"
+	segmentSignature = "signature"
+	segmentQuoted    = "quoted"
+)
+
+// BodySegment is a run of body lines, numbered from 1, of one kind. Marker
+// names the rule that opened it.
+type BodySegment struct {
+	Kind   string `json:"kind"`
+	From   int    `json:"from"`
+	To     int    `json:"to"`
+	Marker string `json:"marker,omitempty"`
+}
+
+var (
+	forwardMarkers = []*regexp.Regexp{
+		regexp.MustCompile(`(?i)^-{2,}\s*(forwarded message|пересылаемое сообщение|переадресованное сообщение)\s*-{2,}$`),
+		regexp.MustCompile(`(?i)^(begin forwarded message|начало переадресованного сообщения):$`),
+	}
+	originalMessage = regexp.MustCompile(`(?i)^-{2,}\s*(original message|исходное сообщение)\s*-{2,}$`)
+	// attribution matches "On ... wrote:", "Иван пишет:" and the dated forms
+	// of Gmail and Yandex, "пн, 3 мар. 2025 г. в 10:15, Иван <ivan@example.ru>:".
+	attribution   = regexp.MustCompile(`(?i)^(on\s.+\swrote|.+\s(пишет|писал|написал|написала|писал\(а\)|написал\(а\))|.{0,100}\d{1,2}:\d{2},?\s.*<[^<>@\s]+@[^<>\s]+>)\s*:$`)
+	headerFrom    = regexp.MustCompile(`(?i)^(from|от|отправитель):\s*\S`)
+	headerSent    = regexp.MustCompile(`(?i)^(sent|date|отправлено|дата):\s*\S`)
+	headerFwd     = regexp.MustCompile(`(?i)^(subject|тема):\s*(fw|fwd|пересл)\b`)
+	sigDelimiter  = regexp.MustCompile(`^--\s*$`)
+	closingPhrase = regexp.MustCompile(`(?i)^((с уважением|с наилучшими пожеланиями|best regards|kind regards|regards)[,.!]?(\s+[^\d]{0,40})?|(спасибо|благодарю|thanks|thank you)[,.!]*)$`)
+	mobileFooter  = regexp.MustCompile(`(?i)^(sent from my\s|отправлено (с|из) (моего )?(iphone|ipad|android|мобильн))`)
+)
+
+func segmentBody(text string) []BodySegment {
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	var segs []BodySegment
+	add := func(kind, marker string, n int) {
+		if last := len(segs) - 1; last >= 0 && segs[last].Kind == kind && marker == "" {
+			segs[last].To = n
+			return
+		}
+		segs = append(segs, BodySegment{Kind: kind, From: n, To: n, Marker: marker})
+	}
+
+	content, state := segmentNew, segmentNew
+	seen := false // a content line was seen in the current content segment
+	for i, line := range lines {
+		t := strings.TrimSpace(line)
+		n := i + 1
+		if state == segmentQuoted {
+			add(segmentQuoted, "", n)
+			continue
+		}
+		m := quoteMarker(lines, i)
+		if isForwardMarker(t) || m == "forward_header" {
+			content, state, seen = segmentForwarded, segmentForwarded, false
+			add(segmentForwarded, "forward", n)
+			continue
+		}
+		// The From:/Date: block right under a forward marker describes the
+		// forwarded message.
+		if m == "reply_header" && content == segmentForwarded && !seen {
+			m = ""
+		}
+		if m != "" {
+			state = segmentQuoted
+			add(segmentQuoted, m, n)
+			continue
+		}
+		if strings.HasPrefix(t, ">") {
+			// Interleaved replies quote a few lines and go on below them.
+			marker := "gt_quote"
+			if len(segs) > 0 && segs[len(segs)-1].Kind == segmentQuoted {
+				marker = ""
+			}
+			add(segmentQuoted, marker, n)
+			continue
+		}
+		if state == content {
+			if m := signatureMarker(t, seen); m != "" {
+				state = segmentSignature
+				add(segmentSignature, m, n)
+				continue
+			}
+			if t != "" {
+				seen = true
+			}
+		}
+		add(state, "", n)
+	}
+	return segs
+}
+
+func isForwardMarker(line string) bool {
+	for _, re := range forwardMarkers {
+		if re.MatchString(line) {
+			return true
+		}
+	}
+	return false
+}
+
+// quoteMarker names the reply header starting at lines[i], or returns "".
+// Outlook writes a From:/Sent: block, for forwards too, told apart by
+// their FW: subject; Gmail may wrap its attribution line.
+func quoteMarker(lines []string, i int) string {
+	t := strings.TrimSpace(lines[i])
+	switch {
+	case t == "":
+		return ""
+	case originalMessage.MatchString(t):
+		return "original_message"
+	case attribution.MatchString(t):
+		return "attribution"
+	case i+1 < len(lines) && attribution.MatchString(t+" "+strings.TrimSpace(lines[i+1])) && strings.HasSuffix(strings.TrimSpace(lines[i+1]), ":"):
+		return "attribution"
+	case headerFrom.MatchString(t):
+		marker := ""
+		for j := i + 1; j < len(lines) && j <= i+5; j++ {
+			next := strings.TrimSpace(lines[j])
+			if headerSent.MatchString(next) && marker == "" {
+				marker = "reply_header"
+			}
+			if headerFwd.MatchString(next) && marker != "" {
+				return "forward_header"
+			}
+		}
+		return marker
+	}
+	return ""
+}
+
+// signatureMarker names the line that starts a signature, or returns "". A
+// bare "Спасибо" only closes a message that said something before it.
+func signatureMarker(line string, seen bool) string {
+	switch {
+	case sigDelimiter.MatchString(line):
+		return "sig_delimiter"
+	case mobileFooter.MatchString(line):
+		return "mobile_footer"
+	case seen && closingPhrase.MatchString(line):
+		return "closing"
+	}
+	return ""
+}
+
+// segmentText keeps the lines of the content segments, and of the reply
+// history when includeQuoted is set, and blanks the rest, so line numbers
+// stay those of the body and no item is joined across segments. Quoted
+// lines lose their ">" marks and the line that introduced the history is
+// left out.
+func segmentText(text string, segs []BodySegment, includeQuoted bool) string {
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	keep := make([]string, len(lines))
+	for _, s := range segs {
+		switch s.Kind {
+		case segmentNew, segmentForwarded:
+			copy(keep[s.From-1:s.To], lines[s.From-1:s.To])
+		case segmentQuoted:
+			if !includeQuoted {
+				continue
+			}
+			from := s.From
+			if s.Marker != "" && s.Marker != "gt_quote" {
+				from++
+			}
+			for i := from - 1; i < s.To; i++ {
+				keep[i] = strings.TrimLeft(strings.TrimSpace(lines[i]), "> ")
+			}
+		}
+	}
+	return strings.Join(keep, "\n")
+}
@@ -0,0 +1,87 @@
+package pipeline
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func segmentsSummary(segs []BodySegment) string {
+	parts := []string{}
+	for _, s := range segs {
+		part := fmt.Sprintf("%s %d-%d", s.Kind, s.From, s.To)
+		if s.Marker != "" {
+			part += " " + s.Marker
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, ", ")
+}
+
+func TestSegmentBody(t *testing.T) {
+	cases := []struct {
+		name, text, want string
+	}{
+		{
+			name: "gmail reply with signature",
+			text: "Добавьте, пожалуйста:\nАвтомат ABB S201 C16 20 шт\n\nС уважением, Иван Петров\nООО Ромашка, ИНН 7701234567\n+7 495 123-45-67\n\n" +
+				"пн, 3 мар. 2025 г. в 10:15, Менеджер <sales@example.ru>:\n> Кабель ВВГнг 3x2.5 150 м\n",
+			want: "new 1-3, signature 4-7 closing, quoted 8-10 attribution",
+		},
+		{
+			name: "outlook original message",
+			text: "Лампа E27 40 шт\n\n-----Original Message-----\nFrom: Иван\nSent: Monday, March 3, 2025\nКабель 150 м\n",
+			want: "new 1-2, quoted 3-7 original_message",
+		},
+		{
+			name: "outlook header block and wrapped attribution",
+			text: "Спасибо\nЛампа E27 40 шт\n--\nИван\n\nОт: Иван Петров\nОтправлено: 3 марта 2025 г.\nТема: Re: Заявка\n",
+			want: "new 1-2, signature 3-5 sig_delimiter, quoted 6-9 reply_header",
+		},
+		{
+			name: "interleaved quotes",
+			text: "> Сколько кабеля?\n150 м кабеля ВВГнг\n> А ламп?\n> Какие?\nЛампа E27 40 шт\nOn Mon, Mar 3, 2025 at 10:15 AM Sales\n<sales@example.com> wrote:\n> old\n",
+			want: "quoted 1-1 gt_quote, new 2-2, quoted 3-4 gt_quote, new 5-5, quoted 6-9 attribution",
+		},
+		{
+			name: "forwarded request",
+			text: "Посмотрите, пожалуйста\n\n---------- Forwarded message ---------\nFrom: Клиент <client@example.ru>\nDate: Mon, 3 Mar 2025\nSubject: Заявка\n\nАвтомат ABB S201 C16 20 шт\nС уважением, Клиент\n",
+			want: "new 1-2, forwarded 3-8 forward, signature 9-10 closing",
+		},
+		{
+			name: "outlook forward header",
+			text: "См. ниже\n________________________________\nFrom: Клиент\nSent: Monday\nSubject: FW: Заявка\nЛампа 40 шт\n",
+			want: "new 1-2, forwarded 3-7 forward",
+		},
+	}
+	for _, tc := range cases {
+		if got := segmentsSummary(segmentBody(tc.text)); got != tc.want {
+			t.Errorf("%s:\ngot  %s\nwant %s", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestExtractReadsOnlyNewText(t *testing.T) {
+	raw := []byte("From: customer@example.com\r\nSubject: Re: Zayavka\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n" +
+		"Добавьте: Автомат ABB S201 C16 20 шт\r\n\r\nС уважением, Иван\r\nИНН 7701234567, тел. 123-45-67\r\n\r\n" +
+		"-----Original Message-----\r\nКабель ВВГнг 3x2.5 150 м\r\n")
+
+	res, err := ExtractItemsFromEmailRaw(raw, ExtractOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Items) != 1 || *res.Items[0].Qty != 20 {
+		t.Fatalf("items=%+v", res.Items)
+	}
+	if got := segmentsSummary(res.Segments); got != "new 1-2, signature 3-5 closing, quoted 6-8 original_message" {
+		t.Fatalf("segments=%s", got)
+	}
+
+	res, err = ExtractItemsFromEmailRaw(raw, ExtractOptions{IncludeQuoted: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Items) != 2 || *res.Items[1].Qty != 150 {
+		t.Fatalf("items=%+v", res.Items)
+	}
+}
@@ -36,7 +36,7 @@ func TestSmokeEmailToXLSX(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	email, err := db.UpsertEmail("gmail", "<fixture-1@example.com>", "Заявка", "customer@example.com", "2026-02-08T00:00:00Z", "hash", rawPath, "fetched")
+	email, err := db.UpsertEmail("gmail", "<fixture-1@example.com>", "Заявка", "customer@example.com", "2026-02-08T00:00:00Z", "hash", rawPath, "", "", "fetched")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -68,4 +68,44 @@ func TestSmokeEmailToXLSX(t *testing.T) {
 	}
 }
 
+func TestProcessRecordsFailedAttachments(t *testing.T) {
+	tmp := t.TempDir()
+	db, err := storage.Open(filepath.Join(tmp, "app.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	rawPath := filepath.Join(tmp, "broken.eml")
+	raw := mkMultipart(attachment{"КП.xls", []byte("not a spreadsheet")})
+	if err := os.WriteFile(rawPath, raw, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	email, err := db.UpsertEmail("file", "<broken@example.com>", "Заявка", "customer@example.com", "2026-02-08T00:00:00Z", "hash", rawPath, "", "", "fetched")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, _ := config.Load()
+	res, err := NewProcessingService(db, cfg).ProcessEmail(email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.AttachmentFailures) != 1 {
+		t.Fatalf("failures=%+v", res.AttachmentFailures)
+	}
+	details, err := db.LatestRunDetails(email.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	failures, _ := details["attachmentFailures"].([]any)
+	if len(failures) != 1 || failures[0].(map[string]any)["attachment"] != "КП.xls" {
+		t.Fatalf("details=%v", details)
+	}
+	segments, _ := details["bodySegments"].([]any)
+	if len(segments) != 1 || segments[0].(map[string]any)["kind"] != "new" {
+		t.Fatalf("details=%v", details)
+	}
+}
+
 func strp(v string) *string { return &v }
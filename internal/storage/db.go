@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 
 	_ "modernc.org/sqlite"
 
@@ -23,7 +24,9 @@ func Open(path string) (*DB, error) {
 		return nil, err
 	}
 
-	conn, err := sql.Open("sqlite", path)
+	// busy_timeout is per connection, so it goes into the DSN to reach every
+	// pooled connection; the listener writes from several goroutines.
+	conn, err := sql.Open("sqlite", path+"?_pragma=busy_timeout(5000)")
 	if err != nil {
 		return nil, err
 	}
@@ -63,6 +66,7 @@ CREATE TABLE IF NOT EXISTS products (
   updatedAt TEXT,
   manufacturerHeader TEXT,
   multiplicityOrder REAL,
+  packSize REAL,
   raw_json TEXT NOT NULL,
   lastSeenAt TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP
 );
@@ -80,6 +84,8 @@ CREATE TABLE IF NOT EXISTS emails (
   hash TEXT NOT NULL,
   status TEXT NOT NULL DEFAULT 'fetched',
   rawRef TEXT NOT NULL,
+  sourceRef TEXT NOT NULL DEFAULT '',
+  mailbox TEXT NOT NULL DEFAULT '',
   createdAt TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP,
   updatedAt TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP,
   UNIQUE(provider, messageId)
@@ -92,6 +98,8 @@ CREATE TABLE IF NOT EXISTS extractions (
   source TEXT NOT NULL,
   rawLine TEXT NOT NULL,
   parsedNameOrCode TEXT,
+  parsedName TEXT,
+  parsedCode TEXT,
   parsedQty REAL,
   parsedUnit TEXT,
   parsedJson TEXT NOT NULL,
@@ -109,6 +117,10 @@ CREATE TABLE IF NOT EXISTS matches (
   productId INTEGER,
   productSyncUid TEXT,
   candidatesJson TEXT NOT NULL,
+  convertedQty REAL,
+  convertedUnit TEXT,
+  orderableQty REAL,
+  warnings TEXT NOT NULL DEFAULT '',
   createdAt TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP,
   FOREIGN KEY(extractionId) REFERENCES extractions(id)
 );
@@ -119,10 +131,44 @@ CREATE TABLE IF NOT EXISTS runs (
   emailId INTEGER,
   timingsJson TEXT NOT NULL,
   countsJson TEXT NOT NULL,
+  detailsJson TEXT NOT NULL DEFAULT '{}',
   createdAt TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP,
   FOREIGN KEY(emailId) REFERENCES emails(id)
 );
 
+CREATE TABLE IF NOT EXISTS replies (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  emailId INTEGER NOT NULL UNIQUE,
+  status TEXT NOT NULL,
+  recipient TEXT NOT NULL DEFAULT '',
+  subject TEXT NOT NULL DEFAULT '',
+  messageId TEXT NOT NULL DEFAULT '',
+  attachmentRef TEXT NOT NULL DEFAULT '',
+  error TEXT NOT NULL DEFAULT '',
+  sentAt TEXT NOT NULL DEFAULT '',
+  createdAt TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  updatedAt TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  FOREIGN KEY(emailId) REFERENCES emails(id)
+);
+
+CREATE TABLE IF NOT EXISTS writebacks (
+  emailId INTEGER PRIMARY KEY,
+  outcome TEXT NOT NULL,
+  status TEXT NOT NULL,
+  emailStatus TEXT NOT NULL DEFAULT '',
+  attempts INTEGER NOT NULL DEFAULT 0,
+  lastError TEXT NOT NULL DEFAULT '',
+  updatedAt TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  FOREIGN KEY(emailId) REFERENCES emails(id)
+);
+
+CREATE TABLE IF NOT EXISTS uidls (
+  account TEXT NOT NULL,
+  uidl TEXT NOT NULL,
+  createdAt TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY(account, uidl)
+);
+
 CREATE TABLE IF NOT EXISTS metadata (
   key TEXT PRIMARY KEY,
   value TEXT NOT NULL,
@@ -130,7 +176,76 @@ CREATE TABLE IF NOT EXISTS metadata (
 );
 `
 
-	_, err := d.conn.Exec(schema)
+	if _, err := d.conn.Exec(schema); err != nil {
+		return err
+	}
+	return d.migrate()
+}
+
+// migrate adds columns introduced after the first release to existing
+// databases; CREATE TABLE IF NOT EXISTS leaves old tables untouched.
+func (d *DB) migrate() error {
+	if err := d.addColumnIfMissing("emails", "sourceRef", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	if err := d.addColumnIfMissing("emails", "mailbox", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	if err := d.addColumnIfMissing("runs", "detailsJson", "TEXT NOT NULL DEFAULT '{}'"); err != nil {
+		return err
+	}
+	if err := d.addColumnIfMissing("writebacks", "emailStatus", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	if err := d.addColumnIfMissing("extractions", "parsedName", "TEXT"); err != nil {
+		return err
+	}
+	if err := d.addColumnIfMissing("extractions", "parsedCode", "TEXT"); err != nil {
+		return err
+	}
+	if err := d.addColumnIfMissing("products", "packSize", "REAL"); err != nil {
+		return err
+	}
+	if err := d.addColumnIfMissing("matches", "convertedQty", "REAL"); err != nil {
+		return err
+	}
+	if err := d.addColumnIfMissing("matches", "convertedUnit", "TEXT"); err != nil {
+		return err
+	}
+	if err := d.addColumnIfMissing("matches", "orderableQty", "REAL"); err != nil {
+		return err
+	}
+	return d.addColumnIfMissing("matches", "warnings", "TEXT NOT NULL DEFAULT ''")
+}
+
+func (d *DB) addColumnIfMissing(table, column, decl string) error {
+	rows, err := d.conn.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			cid       int
+			name      string
+			colType   string
+			notNull   int
+			dfltValue sql.NullString
+			pk        int
+		)
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &dfltValue, &pk); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	_ = rows.Close()
+
+	_, err = d.conn.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, decl))
 	return err
 }
 
@@ -145,8 +260,8 @@ func (d *DB) UpsertProducts(products []internal.ProductRecord) error {
 INSERT INTO products (
   id, syncUid, header, articul, unitHeader,
   flat_elcom, flat_manufacturer, flat_raec, flat_pc, flat_etm,
-  analogCodes, updatedAt, manufacturerHeader, multiplicityOrder, raw_json, lastSeenAt
-) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+  analogCodes, updatedAt, manufacturerHeader, multiplicityOrder, packSize, raw_json, lastSeenAt
+) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
 ON CONFLICT(id) DO UPDATE SET
   syncUid=excluded.syncUid,
   header=excluded.header,
@@ -161,6 +276,7 @@ ON CONFLICT(id) DO UPDATE SET
   updatedAt=excluded.updatedAt,
   manufacturerHeader=excluded.manufacturerHeader,
   multiplicityOrder=excluded.multiplicityOrder,
+  packSize=excluded.packSize,
   raw_json=excluded.raw_json,
   lastSeenAt=CURRENT_TIMESTAMP
 `)
@@ -174,7 +290,7 @@ ON CONFLICT(id) DO UPDATE SET
 		if _, err := stmt.Exec(
 			p.ID, p.SyncUID, p.Header, p.Articul, p.UnitHeader,
 			p.FlatCodes.Elcom, p.FlatCodes.Manufacturer, p.FlatCodes.Raec, p.FlatCodes.PC, p.FlatCodes.Etm,
-			string(analogJSON), p.UpdatedAt, p.ManufacturerHeader, p.MultiplicityOrder, p.RawJSON,
+			string(analogJSON), p.UpdatedAt, p.ManufacturerHeader, p.MultiplicityOrder, p.PackSize, p.RawJSON,
 		); err != nil {
 			return err
 		}
@@ -187,7 +303,7 @@ func (d *DB) ListProducts() ([]internal.ProductRecord, error) {
 	rows, err := d.conn.Query(`
 SELECT id, syncUid, header, articul, unitHeader,
        flat_elcom, flat_manufacturer, flat_raec, flat_pc, flat_etm,
-       analogCodes, updatedAt, manufacturerHeader, multiplicityOrder, raw_json
+       analogCodes, updatedAt, manufacturerHeader, multiplicityOrder, packSize, raw_json
 FROM products`)
 	if err != nil {
 		return nil, err
@@ -201,7 +317,7 @@ FROM products`)
 		if err := rows.Scan(
 			&p.ID, &p.SyncUID, &p.Header, &p.Articul, &p.UnitHeader,
 			&p.FlatCodes.Elcom, &p.FlatCodes.Manufacturer, &p.FlatCodes.Raec, &p.FlatCodes.PC, &p.FlatCodes.Etm,
-			&analogJSON, &p.UpdatedAt, &p.ManufacturerHeader, &p.MultiplicityOrder, &p.RawJSON,
+			&analogJSON, &p.UpdatedAt, &p.ManufacturerHeader, &p.MultiplicityOrder, &p.PackSize, &p.RawJSON,
 		); err != nil {
 			return nil, err
 		}
@@ -212,18 +328,23 @@ FROM products`)
 	return out, rows.Err()
 }
 
-func (d *DB) UpsertEmail(provider, messageID, subject, sender, receivedAt, hash, rawRef, status string) (internal.EmailRow, error) {
+// UpsertEmail stores email metadata idempotently. The same message seen in
+// a second mailbox of one provider moves to that mailbox together with its
+// sourceRef, so write-back always targets where it was last seen.
+func (d *DB) UpsertEmail(provider, messageID, subject, sender, receivedAt, hash, rawRef, sourceRef, mailbox, status string) (internal.EmailRow, error) {
 	_, err := d.conn.Exec(`
-INSERT INTO emails (provider, messageId, subject, sender, receivedAt, hash, status, rawRef)
-VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+INSERT INTO emails (provider, messageId, subject, sender, receivedAt, hash, status, rawRef, sourceRef, mailbox)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 ON CONFLICT(provider, messageId) DO UPDATE SET
   subject=excluded.subject,
   sender=excluded.sender,
   receivedAt=excluded.receivedAt,
   hash=excluded.hash,
   rawRef=excluded.rawRef,
+  sourceRef=CASE WHEN excluded.sourceRef <> '' THEN excluded.sourceRef ELSE emails.sourceRef END,
+  mailbox=CASE WHEN excluded.mailbox <> '' THEN excluded.mailbox ELSE emails.mailbox END,
   updatedAt=CURRENT_TIMESTAMP
-`, provider, messageID, subject, sender, receivedAt, hash, status, rawRef)
+`, provider, messageID, subject, sender, receivedAt, hash, status, rawRef, sourceRef, mailbox)
 	if err != nil {
 		return internal.EmailRow{}, err
 	}
@@ -241,10 +362,10 @@ ON CONFLICT(provider, messageId) DO UPDATE SET
 func (d *DB) GetEmailByProviderMessageID(provider, messageID string) (*internal.EmailRow, error) {
 	var row internal.EmailRow
 	err := d.conn.QueryRow(`
-SELECT id, provider, messageId, subject, sender, receivedAt, hash, status, rawRef
+SELECT id, provider, messageId, subject, sender, receivedAt, hash, status, rawRef, mailbox
 FROM emails WHERE provider = ? AND messageId = ?
 `, provider, messageID).Scan(
-		&row.ID, &row.Provider, &row.MessageID, &row.Subject, &row.Sender, &row.ReceivedAt, &row.Hash, &row.Status, &row.RawRef,
+		&row.ID, &row.Provider, &row.MessageID, &row.Subject, &row.Sender, &row.ReceivedAt, &row.Hash, &row.Status, &row.RawRef, &row.Mailbox,
 	)
 	if errors.Is(err, sql.ErrNoRows) {
 		return nil, nil
@@ -258,10 +379,10 @@ FROM emails WHERE provider = ? AND messageId = ?
 func (d *DB) GetEmailByID(id int) (*internal.EmailRow, error) {
 	var row internal.EmailRow
 	err := d.conn.QueryRow(`
-SELECT id, provider, messageId, subject, sender, receivedAt, hash, status, rawRef
+SELECT id, provider, messageId, subject, sender, receivedAt, hash, status, rawRef, mailbox
 FROM emails WHERE id = ?
 `, id).Scan(
-		&row.ID, &row.Provider, &row.MessageID, &row.Subject, &row.Sender, &row.ReceivedAt, &row.Hash, &row.Status, &row.RawRef,
+		&row.ID, &row.Provider, &row.MessageID, &row.Subject, &row.Sender, &row.ReceivedAt, &row.Hash, &row.Status, &row.RawRef, &row.Mailbox,
 	)
 	if errors.Is(err, sql.ErrNoRows) {
 		return nil, nil
@@ -272,11 +393,15 @@ FROM emails WHERE id = ?
 	return &row, nil
 }
 
-func (d *DB) ListEmailsByStatus(status string, limit int) ([]internal.EmailRow, error) {
+// ListEmailsByStatus returns emails in status, oldest first. Empty provider
+// or mailbox match any.
+func (d *DB) ListEmailsByStatus(status, provider, mailbox string, limit int) ([]internal.EmailRow, error) {
 	rows, err := d.conn.Query(`
-SELECT id, provider, messageId, subject, sender, receivedAt, hash, status, rawRef
-FROM emails WHERE status = ? ORDER BY receivedAt ASC LIMIT ?
-`, status, limit)
+SELECT id, provider, messageId, subject, sender, receivedAt, hash, status, rawRef, mailbox
+FROM emails
+WHERE status = ? AND (? = '' OR provider = ?) AND (? = '' OR mailbox = ?)
+ORDER BY receivedAt ASC LIMIT ?
+`, status, provider, provider, mailbox, mailbox, limit)
 	if err != nil {
 		return nil, err
 	}
@@ -285,7 +410,7 @@ FROM emails WHERE status = ? ORDER BY receivedAt ASC LIMIT ?
 	var out []internal.EmailRow
 	for rows.Next() {
 		var row internal.EmailRow
-		if err := rows.Scan(&row.ID, &row.Provider, &row.MessageID, &row.Subject, &row.Sender, &row.ReceivedAt, &row.Hash, &row.Status, &row.RawRef); err != nil {
+		if err := rows.Scan(&row.ID, &row.Provider, &row.MessageID, &row.Subject, &row.Sender, &row.ReceivedAt, &row.Hash, &row.Status, &row.RawRef, &row.Mailbox); err != nil {
 			return nil, err
 		}
 		out = append(out, row)
@@ -335,9 +460,9 @@ func (d *DB) ClearEmailProcessing(emailID int) error {
 func (d *DB) InsertExtraction(emailID int, item internal.ExtractionItem) (int64, error) {
 	metaJSON, _ := json.Marshal(item.Meta)
 	result, err := d.conn.Exec(`
-INSERT INTO extractions (emailId, lineNo, source, rawLine, parsedNameOrCode, parsedQty, parsedUnit, parsedJson)
-VALUES (?, ?, ?, ?, ?, ?, ?, ?)
-`, emailID, item.LineNo, string(item.Source), item.RawLine, item.NameOrCode, item.Qty, item.Unit, string(metaJSON))
+INSERT INTO extractions (emailId, lineNo, source, rawLine, parsedNameOrCode, parsedName, parsedCode, parsedQty, parsedUnit, parsedJson)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+`, emailID, item.LineNo, string(item.Source), item.RawLine, item.NameOrCode, item.Name, item.Code, item.Qty, item.Unit, string(metaJSON))
 	if err != nil {
 		return 0, err
 	}
@@ -354,16 +479,191 @@ func (d *DB) InsertMatch(extractionID int64, result internal.MatchResult) error
 	}
 
 	_, err := d.conn.Exec(`
-INSERT INTO matches (extractionId, status, confidence, reason, productId, productSyncUid, candidatesJson)
-VALUES (?, ?, ?, ?, ?, ?, ?)
-`, extractionID, string(result.Status), result.Confidence, string(result.Reason), productID, productSyncUID, string(candidatesJSON))
+INSERT INTO matches (extractionId, status, confidence, reason, productId, productSyncUid, candidatesJson, convertedQty, convertedUnit, orderableQty, warnings)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+`, extractionID, string(result.Status), result.Confidence, string(result.Reason), productID, productSyncUID, string(candidatesJSON),
+		result.ConvertedQty, result.ConvertedUnit, result.OrderableQty, strings.Join(result.Warnings, ","))
 	return err
 }
 
-func (d *DB) InsertRun(traceID string, emailID int, timings map[string]float64, counts map[string]int) error {
+// InsertRun records one processing run. details holds what went wrong or
+// was decided along the way, such as attachments that failed to parse.
+func (d *DB) InsertRun(traceID string, emailID int, timings map[string]float64, counts map[string]int, details map[string]any) error {
 	timingsJSON, _ := json.Marshal(timings)
 	countsJSON, _ := json.Marshal(counts)
-	_, err := d.conn.Exec(`INSERT INTO runs (traceId, emailId, timingsJson, countsJson) VALUES (?, ?, ?, ?)`, traceID, emailID, string(timingsJSON), string(countsJSON))
+	if details == nil {
+		details = map[string]any{}
+	}
+	detailsJSON, err := json.Marshal(details)
+	if err != nil {
+		return err
+	}
+	_, err = d.conn.Exec(`INSERT INTO runs (traceId, emailId, timingsJson, countsJson, detailsJson) VALUES (?, ?, ?, ?, ?)`, traceID, emailID, string(timingsJSON), string(countsJSON), string(detailsJSON))
+	return err
+}
+
+// LatestRunDetails returns the details of the last run of an email, or nil
+// when it was never processed.
+func (d *DB) LatestRunDetails(emailID int) (map[string]any, error) {
+	var raw string
+	err := d.conn.QueryRow(`SELECT detailsJson FROM runs WHERE emailId = ? ORDER BY id DESC LIMIT 1`, emailID).Scan(&raw)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	details := map[string]any{}
+	if err := json.Unmarshal([]byte(raw), &details); err != nil {
+		return nil, err
+	}
+	return details, nil
+}
+
+func (d *DB) UpsertReply(reply internal.ReplyRow) error {
+	_, err := d.conn.Exec(`
+INSERT INTO replies (emailId, status, recipient, subject, messageId, attachmentRef, error, sentAt)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT(emailId) DO UPDATE SET
+  status=excluded.status,
+  recipient=excluded.recipient,
+  subject=excluded.subject,
+  messageId=excluded.messageId,
+  attachmentRef=excluded.attachmentRef,
+  error=excluded.error,
+  sentAt=excluded.sentAt,
+  updatedAt=CURRENT_TIMESTAMP
+`, reply.EmailID, reply.Status, reply.Recipient, reply.Subject, reply.MessageID, reply.AttachmentRef, reply.Error, reply.SentAt)
+	return err
+}
+
+func (d *DB) GetReply(emailID int) (*internal.ReplyRow, error) {
+	var row internal.ReplyRow
+	err := d.conn.QueryRow(`
+SELECT id, emailId, status, recipient, subject, messageId, attachmentRef, error, sentAt
+FROM replies WHERE emailId = ?
+`, emailID).Scan(&row.ID, &row.EmailID, &row.Status, &row.Recipient, &row.Subject, &row.MessageID, &row.AttachmentRef, &row.Error, &row.SentAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &row, nil
+}
+
+func (d *DB) ListRepliesByStatus(status string, limit int) ([]internal.ReplyRow, error) {
+	rows, err := d.conn.Query(`
+SELECT id, emailId, status, recipient, subject, messageId, attachmentRef, error, sentAt
+FROM replies WHERE status = ? ORDER BY id ASC LIMIT ?
+`, status, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []internal.ReplyRow
+	for rows.Next() {
+		var row internal.ReplyRow
+		if err := rows.Scan(&row.ID, &row.EmailID, &row.Status, &row.Recipient, &row.Subject, &row.MessageID, &row.AttachmentRef, &row.Error, &row.SentAt); err != nil {
+			return nil, err
+		}
+		out = append(out, row)
+	}
+	return out, rows.Err()
+}
+
+// ListWritebackCandidates returns finished emails of provider (and mailbox,
+// unless empty) that carry a source reference and have no write-back yet, a
+// pending one, or one made for another email status.
+func (d *DB) ListWritebackCandidates(provider, mailbox string, limit int) ([]internal.WritebackCandidate, error) {
+	rows, err := d.conn.Query(`
+SELECT
+  e.id, e.sourceRef, e.status,
+  EXISTS (
+    SELECT 1 FROM extractions x JOIN matches m ON m.extractionId = x.id
+    WHERE x.emailId = e.id AND m.status = 'REVIEW'
+  ),
+  w.outcome, w.status, w.emailStatus, w.attempts, w.lastError
+FROM emails e
+LEFT JOIN writebacks w ON w.emailId = e.id
+WHERE e.provider = ?
+  AND (? = '' OR e.mailbox = ?)
+  AND e.sourceRef <> ''
+  AND e.status IN ('processed', 'exported', 'skipped')
+  AND (w.emailId IS NULL OR w.status = 'pending' OR w.emailStatus <> e.status)
+ORDER BY e.id ASC
+LIMIT ?
+`, provider, mailbox, mailbox, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []internal.WritebackCandidate
+	for rows.Next() {
+		var c internal.WritebackCandidate
+		var outcome, status, emailStatus, lastError sql.NullString
+		var attempts sql.NullInt64
+		if err := rows.Scan(&c.EmailID, &c.SourceRef, &c.EmailStatus, &c.HasReview, &outcome, &status, &emailStatus, &attempts, &lastError); err != nil {
+			return nil, err
+		}
+		if status.Valid {
+			c.Writeback = &internal.WritebackRow{
+				EmailID:     c.EmailID,
+				Outcome:     outcome.String,
+				Status:      status.String,
+				EmailStatus: emailStatus.String,
+				Attempts:    int(attempts.Int64),
+				LastError:   lastError.String,
+			}
+		}
+		out = append(out, c)
+	}
+	return out, rows.Err()
+}
+
+func (d *DB) UpsertWriteback(row internal.WritebackRow) error {
+	_, err := d.conn.Exec(`
+INSERT INTO writebacks (emailId, outcome, status, emailStatus, attempts, lastError) VALUES (?, ?, ?, ?, ?, ?)
+ON CONFLICT(emailId) DO UPDATE SET
+  outcome=excluded.outcome,
+  status=excluded.status,
+  emailStatus=excluded.emailStatus,
+  attempts=excluded.attempts,
+  lastError=excluded.lastError,
+  updatedAt=CURRENT_TIMESTAMP
+`, row.EmailID, row.Outcome, row.Status, row.EmailStatus, row.Attempts, row.LastError)
+	return err
+}
+
+// ListUIDLs returns the POP3 UIDLs already ingested for account.
+func (d *DB) ListUIDLs(account string) (map[string]bool, error) {
+	rows, err := d.conn.Query(`SELECT uidl FROM uidls WHERE account = ?`, account)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := map[string]bool{}
+	for rows.Next() {
+		var uidl string
+		if err := rows.Scan(&uidl); err != nil {
+			return nil, err
+		}
+		out[uidl] = true
+	}
+	return out, rows.Err()
+}
+
+func (d *DB) AddUIDL(account, uidl string) error {
+	_, err := d.conn.Exec(`INSERT INTO uidls (account, uidl) VALUES (?, ?) ON CONFLICT(account, uidl) DO NOTHING`, account, uidl)
+	return err
+}
+
+// DeleteUIDL forgets a UIDL once the server no longer lists it.
+func (d *DB) DeleteUIDL(account, uidl string) error {
+	_, err := d.conn.Exec(`DELETE FROM uidls WHERE account = ? AND uidl = ?`, account, uidl)
 	return err
 }
 
@@ -394,11 +694,16 @@ SELECT
   e.source,
   e.rawLine,
   e.parsedNameOrCode,
+  e.parsedCode,
   e.parsedQty,
   e.parsedUnit,
+  m.convertedQty,
+  m.convertedUnit,
+  m.orderableQty,
   m.status,
   m.confidence,
   m.reason,
+  m.warnings,
   p.id,
   p.syncUid,
   p.header,
@@ -432,11 +737,16 @@ ORDER BY
 			&row.Source,
 			&row.RawLine,
 			&row.ParsedNameOrCode,
+			&row.ParsedCode,
 			&row.ParsedQty,
 			&row.ParsedUnit,
+			&row.ConvertedQty,
+			&row.ConvertedUnit,
+			&row.OrderableQty,
 			&row.MatchStatus,
 			&row.Confidence,
 			&row.MatchReason,
+			&row.MatchWarnings,
 			&row.ProductID,
 			&row.ProductSyncUID,
 			&row.ProductHeader,
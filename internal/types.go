@@ -5,8 +5,14 @@ type ItemSource string
 const (
 	SourceEmailText      ItemSource = "email_text"
 	SourceEmailHTMLTable ItemSource = "email_html_table"
+	SourceEmailHTMLText  ItemSource = "email_html_text"
 	SourceXLSX           ItemSource = "xlsx"
+	SourceXLS            ItemSource = "xls"
+	SourceCSV            ItemSource = "csv"
+	SourceDocTable       ItemSource = "doc_table"
 	SourcePDF            ItemSource = "pdf"
+	SourcePDFTable       ItemSource = "pdf_table"
+	SourceOCR            ItemSource = "ocr"
 )
 
 type ExtractionItem struct {
@@ -14,9 +20,14 @@ type ExtractionItem struct {
 	Source     ItemSource
 	RawLine    string
 	NameOrCode *string
-	Qty        *float64
-	Unit       *string
-	Meta       map[string]any
+	// Name is the free-text name without a labelled article; Code is the
+	// article found after "арт."/"код", in a code column or by its shape.
+	// NameOrCode is Name, or Code when the line has no name.
+	Name *string
+	Code *string
+	Qty  *float64
+	Unit *string
+	Meta map[string]any
 }
 
 type MatchStatus string
@@ -32,6 +43,14 @@ const (
 	ReasonHeader MatchReason = "HEADER"
 	ReasonFuzzy  MatchReason = "FUZZY"
 	ReasonNone   MatchReason = "NONE"
+
+	// WarningUnitMismatch and WarningPackSize mark a match whose unit the
+	// requested quantity could not be converted to.
+	WarningUnitMismatch = "UNIT_MISMATCH"
+	WarningPackSize     = "PACK_SIZE_UNKNOWN"
+	// WarningMultiplicity marks a quantity rounded up to the order
+	// multiplicity of the product.
+	WarningMultiplicity = "MULTIPLICITY"
 )
 
 type ProductFlatCodes struct {
@@ -50,10 +69,13 @@ type ProductRecord struct {
 	UnitHeader         *string
 	ManufacturerHeader *string
 	MultiplicityOrder  *float64
-	AnalogCodes        []string
-	FlatCodes          ProductFlatCodes
-	UpdatedAt          *string
-	RawJSON            string
+	// PackSize is how many units (UnitHeader, or шт/м for pack units) one
+	// pack holds, when the catalog says.
+	PackSize    *float64
+	AnalogCodes []string
+	FlatCodes   ProductFlatCodes
+	UpdatedAt   *string
+	RawJSON     string
 }
 
 type MatchCandidate struct {
@@ -78,6 +100,14 @@ type MatchResult struct {
 	Reason     MatchReason      `json:"reason"`
 	Product    *MatchProduct    `json:"product"`
 	Candidates []MatchCandidate `json:"candidates"`
+	// ConvertedQty is the requested quantity in ConvertedUnit, the unit the
+	// product is sold in, when there is a product and it converts.
+	ConvertedQty  *float64 `json:"convertedQty,omitempty"`
+	ConvertedUnit *string  `json:"convertedUnit,omitempty"`
+	// OrderableQty is ConvertedQty rounded up to the product's order
+	// multiplicity.
+	OrderableQty *float64 `json:"orderableQty,omitempty"`
+	Warnings     []string `json:"warnings,omitempty"`
 }
 
 type EmailRow struct {
@@ -90,6 +120,9 @@ type EmailRow struct {
 	Hash       string
 	Status     string
 	RawRef     string
+	// Mailbox is the listener mailbox the email was fetched from; empty for
+	// one-off CLI fetches and inbound SMTP.
+	Mailbox string
 }
 
 type FetchedMailMessage struct {
@@ -99,6 +132,42 @@ type FetchedMailMessage struct {
 	From       string
 	ReceivedAt string
 	Raw        []byte
+	// SourceRef locates the message in the source mailbox for outcome
+	// write-back (Gmail message id, IMAP mailbox/UID); empty if unsupported.
+	SourceRef string
+	Mailbox   string
+}
+
+type ReplyRow struct {
+	ID            int
+	EmailID       int
+	Status        string
+	Recipient     string
+	Subject       string
+	MessageID     string
+	AttachmentRef string
+	Error         string
+	SentAt        string
+}
+
+type WritebackRow struct {
+	EmailID int
+	Outcome string
+	Status  string
+	// EmailStatus is the status of the email the outcome was written for.
+	EmailStatus string
+	Attempts    int
+	LastError   string
+}
+
+// WritebackCandidate is a finished email whose outcome may still need to be
+// written back to the source mailbox.
+type WritebackCandidate struct {
+	EmailID     int
+	SourceRef   string
+	EmailStatus string
+	HasReview   bool
+	Writeback   *WritebackRow
 }
 
 type MatchExportRow struct {
@@ -106,11 +175,16 @@ type MatchExportRow struct {
 	Source           string
 	RawLine          string
 	ParsedNameOrCode *string
+	ParsedCode       *string
 	ParsedQty        *float64
 	ParsedUnit       *string
+	ConvertedQty     *float64
+	ConvertedUnit    *string
+	OrderableQty     *float64
 	MatchStatus      string
 	Confidence       float64
 	MatchReason      string
+	MatchWarnings    string
 	ProductID        *int
 	ProductSyncUID   *string
 	ProductHeader    *string
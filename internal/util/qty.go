@@ -2,75 +2,273 @@ package util
 
 import (
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Requests say "две бухты по 100 м", "10 упаковок по 50 шт", "5-6 шт" or
+// "x3" as often as "20 шт". ParseQty reads these forms, in this order of
+// preference: "N [packs] по M [unit]", a range, the last number with a unit
+// or pack word, an "x3" multiplier, and finally the last bare number. Qty is
+// the whole quantity in Unit; the packs it was given in are kept apart.
+
+// numberWords are the Russian number words a quantity may be written in.
+var numberWords = map[string]float64{
+	"ноль": 0, "один": 1, "одна": 1, "одно": 1, "одну": 1, "два": 2, "две": 2, "три": 3, "четыре": 4,
+	"пять": 5, "шесть": 6, "семь": 7, "восемь": 8, "девять": 9, "десять": 10,
+	"одиннадцать": 11, "двенадцать": 12, "тринадцать": 13, "четырнадцать": 14, "пятнадцать": 15,
+	"шестнадцать": 16, "семнадцать": 17, "восемнадцать": 18, "девятнадцать": 19,
+	"двадцать": 20, "тридцать": 30, "сорок": 40, "пятьдесят": 50, "шестьдесят": 60,
+	"семьдесят": 70, "восемьдесят": 80, "девяносто": 90,
+	"сто": 100, "двести": 200, "триста": 300, "четыреста": 400, "пятьсот": 500,
+	"шестьсот": 600, "семьсот": 700, "восемьсот": 800, "девятьсот": 900,
+	"тысяча": 1000, "тысячи": 1000, "тысяч": 1000, "полтора": 1.5, "полторы": 1.5,
+}
+
+const (
+	unitAlternatives = `штук[аи]?|шт\.?|pcs|pc|км|кв\.?\s?м|м2|м²|куб\.?\s?м|м3|м³|метр(?:а|ов)?|м\.?|kg|кг|тонн(?:а|ы)?|т\.?|литр(?:а|ов)?|л\.?|пар[аы]?|уп\.?|компл(?:ект(?:а|ов)?)?\.?`
+	packAlternatives = `бухт[аыуе]?|катуш(?:ка|ки|ку|ек)|барабан(?:а|ов|ы)?|рулон(?:а|ов|ы)?|пач(?:ка|ки|ку|ек)|упаков(?:ка|ки|ку|ок)`
+	digitNumber      = `\d{1,3}(?:[\s.,]\d{3})+|\d+(?:[.,]\d+)?`
 )
 
 var (
-	unitPattern   = regexp.MustCompile(`(?i)(шт|штук|pcs|pc|м\.?|метр|kg|кг|уп\.?|компл\.?)`)
-	numberPattern = regexp.MustCompile(`(?i)(?:^|[^0-9.,])(\d{1,3}(?:[\s.,]\d{3})+|\d+(?:[.,]\d+)?)`)
+	// qtyStart is the character before a quantity: not part of a word or
+	// a longer number such as "3x2.5".
+	qtyStart    = `(?:^|[^\p{L}\p{N}.,])`
+	qtyNumber   = `(` + digitNumber + `|` + numberWordPattern() + `)`
+	multiplied  = regexp.MustCompile(`(?i)` + qtyStart + qtyNumber + `\s*(?:(` + packAlternatives + `)\s*)?\s+по\s+` + qtyNumber + `(?:\s*(` + unitAlternatives + `))?`)
+	dashRange   = regexp.MustCompile(`(?i)` + qtyStart + qtyNumber + `\s*[-–—]\s*` + qtyNumber + `\s*(` + unitAlternatives + `|` + packAlternatives + `)`)
+	fromToRange = regexp.MustCompile(`(?i)` + qtyStart + `от\s+` + qtyNumber + `\s+до\s+` + qtyNumber + `(?:\s*(` + unitAlternatives + `|` + packAlternatives + `))?`)
+	withUnit    = regexp.MustCompile(`(?i)` + qtyStart + qtyNumber + `\s*(` + packAlternatives + `|` + unitAlternatives + `)`)
+	// timesSuffix is only taken at the end of the line: "3 х 2,5 - 100" is a
+	// cable section followed by the quantity.
+	timesSuffix = regexp.MustCompile(`(?i)(?:^|\s)([xх×*]\s?(\d+))\s*$`)
+	packPattern = regexp.MustCompile(`(?i)^(?:` + packAlternatives + `)$`)
+
+	numberPattern = regexp.MustCompile(`(?i)(?:^|[^0-9.,])(` + digitNumber + `)`)
 )
 
+func numberWordPattern() string {
+	words := make([]string, 0, len(numberWords))
+	for w := range numberWords {
+		words = append(words, w)
+	}
+	// Longer words first, so that "пятьсот" is not read as "пять".
+	sort.Slice(words, func(i, j int) bool {
+		if len(words[i]) != len(words[j]) {
+			return len(words[i]) > len(words[j])
+		}
+		return words[i] < words[j]
+	})
+	alt := strings.Join(words, "|")
+	return `(?:` + alt + `)(?:\s+(?:` + alt + `))*`
+}
+
+// Packaging is how a quantity was packed: Count packs (бухта, упаковка...)
+// of Size units each. Size is nil when the request does not say it, as in
+// "3 бухты", and Unit is empty for "2 по 100 м".
+type Packaging struct {
+	Unit  string   `json:"unit"`
+	Count float64  `json:"count"`
+	Size  *float64 `json:"size,omitempty"`
+}
+
 type ParsedQty struct {
 	Qty    *float64
 	Unit   *string
 	QtyRaw *string
+	// Min and Max bound a range such as "5-6 шт"; Qty is then Max.
+	Min       *float64
+	Max       *float64
+	Packaging *Packaging
 }
 
 func ParseQty(input string) ParsedQty {
 	line := strings.ReplaceAll(input, "\u00A0", " ")
 
-	qtyRaw := ""
-	qtyToken := ""
-
-	withUnit := regexp.MustCompile(`(?i)(?:^|[^0-9.,])(\d{1,3}(?:[\s.,]\d{3})+|\d+(?:[.,]\d+)?)\s*(шт|штук|pcs|pc|м\.?|метр|kg|кг|уп\.?|компл\.?)`)
-	wm := withUnit.FindAllStringSubmatch(line, -1)
-	if len(wm) > 0 {
-		last := wm[len(wm)-1]
-		qtyRaw = strings.TrimSpace(last[1] + " " + last[2])
-		qtyToken = strings.TrimSpace(last[1])
-	} else {
-		nm := numberPattern.FindAllStringSubmatch(line, -1)
-		if len(nm) > 0 {
-			last := nm[len(nm)-1]
-			qtyRaw = strings.TrimSpace(last[1])
-			qtyToken = strings.TrimSpace(last[1])
+	if m := lastMatch(multiplied, line, 4, 1, 3); m != nil {
+		count, size := parseNumber(m[1]), parseNumber(m[3])
+		if count != nil && size != nil {
+			pack := &Packaging{Count: *count, Size: size}
+			if m[2] != "" {
+				pack.Unit = normalizeUnit(m[2])
+			}
+			out := ParsedQty{Qty: FloatPtr(*count * *size), QtyRaw: StringPtr(m[0]), Packaging: pack}
+			if m[4] != "" {
+				out.Unit = StringPtr(normalizeUnit(m[4]))
+			}
+			return out
+		}
+	}
+	for _, re := range []*regexp.Regexp{dashRange, fromToRange} {
+		m := lastMatch(re, line, 3, 1, 2)
+		if m == nil {
+			continue
+		}
+		lo, hi := parseNumber(m[1]), parseNumber(m[2])
+		if lo == nil || hi == nil || *lo > *hi {
+			continue
+		}
+		out := ParsedQty{Qty: hi, QtyRaw: StringPtr(m[0]), Min: lo, Max: hi}
+		if m[3] != "" {
+			out.Unit = StringPtr(normalizeUnit(m[3]))
+		}
+		return out
+	}
+	if m := lastMatch(withUnit, line, 2, 1); m != nil {
+		if qty := parseNumber(m[1]); qty != nil {
+			unit := normalizeUnit(m[2])
+			out := ParsedQty{Qty: qty, Unit: &unit, QtyRaw: StringPtr(m[0])}
+			if IsPackUnit(unit) {
+				out.Packaging = &Packaging{Unit: unit, Count: *qty}
+			}
+			return out
+		}
+	}
+	if m := lastMatch(timesSuffix, line, -1); m != nil {
+		if qty := parseNumber(m[2]); qty != nil {
+			return ParsedQty{Qty: qty, QtyRaw: StringPtr(m[1])}
+		}
+	}
+	if nm := numberPattern.FindAllStringSubmatch(line, -1); len(nm) > 0 {
+		raw := strings.TrimSpace(nm[len(nm)-1][1])
+		if qty := parseNumber(raw); qty != nil {
+			return ParsedQty{Qty: qty, QtyRaw: &raw}
 		}
 	}
+	return ParsedQty{}
+}
 
-	var qtyPtr *float64
-	if qtyToken != "" {
-		norm := normalizeNumericToken(qtyToken)
-		if parsed, err := strconv.ParseFloat(norm, 64); err == nil {
-			qtyPtr = FloatPtr(parsed)
+// lastMatch returns the submatches of the last match of re that does not
+// run into a word: the unit in group unitGroup, or the match when there is
+// none, must not be followed by a letter or digit ("10 мм" has no unit "м"),
+// and a bare number (unitGroup -1) not by a decimal point. A number word in
+// one of numberGroups must end the word ("Стол" is not "сто л") and, as in
+// writing, takes a unit spelled out rather than a one-letter one. m[0] is the
+// quantity text up to the end of the unit, without the character before it.
+func lastMatch(re *regexp.Regexp, line string, unitGroup int, numberGroups ...int) []string {
+	var last []string
+	for _, idx := range re.FindAllStringSubmatchIndex(line, -1) {
+		end := idx[1]
+		if unitGroup >= 0 && idx[2*unitGroup] >= 0 {
+			end = idx[2*unitGroup+1]
+		}
+		next, _ := utf8.DecodeRuneInString(line[end:])
+		if end < len(line) && (unicode.IsLetter(next) || unicode.IsDigit(next) || (next == '.' && unitGroup < 0)) {
+			continue
 		}
+		if !numberWordsFit(line, idx, unitGroup, numberGroups) {
+			continue
+		}
+		m := make([]string, len(idx)/2)
+		for g := range m {
+			if idx[2*g] >= 0 {
+				m[g] = line[idx[2*g]:idx[2*g+1]]
+			}
+		}
+		m[0] = strings.TrimLeftFunc(line[idx[0]:end], func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) })
+		last = m
 	}
+	return last
+}
 
-	var unitPtr *string
-	if um := unitPattern.FindStringSubmatch(line); len(um) > 1 {
-		u := normalizeUnit(um[1])
-		unitPtr = &u
+// numberWordsFit checks the number words of a match against the rules of
+// lastMatch; the last of numberGroups is the number the unit belongs to.
+func numberWordsFit(line string, idx []int, unitGroup int, numberGroups []int) bool {
+	for i, g := range numberGroups {
+		start, end := idx[2*g], idx[2*g+1]
+		if start < 0 {
+			continue
+		}
+		if r, _ := utf8.DecodeRuneInString(line[start:]); !unicode.IsLetter(r) {
+			continue
+		}
+		if next, _ := utf8.DecodeRuneInString(line[end:]); end < len(line) && (unicode.IsLetter(next) || unicode.IsDigit(next)) {
+			return false
+		}
+		if i == len(numberGroups)-1 && unitGroup >= 0 && idx[2*unitGroup] >= 0 {
+			unit := strings.TrimSuffix(line[idx[2*unitGroup]:idx[2*unitGroup+1]], ".")
+			if utf8.RuneCountInString(unit) == 1 {
+				return false
+			}
+		}
 	}
+	return true
+}
 
-	var qtyRawPtr *string
-	if qtyRaw != "" {
-		qtyRawPtr = &qtyRaw
+// parseNumber reads digits or Russian number words.
+func parseNumber(token string) *float64 {
+	token = strings.TrimSpace(token)
+	if token == "" {
+		return nil
+	}
+	if r, _ := utf8.DecodeRuneInString(token); unicode.IsDigit(r) {
+		v, err := strconv.ParseFloat(normalizeNumericToken(token), 64)
+		if err != nil {
+			return nil
+		}
+		return &v
 	}
+	total, cur := 0.0, 0.0
+	for _, w := range strings.Fields(strings.ToLower(token)) {
+		v, ok := numberWords[w]
+		if !ok {
+			return nil
+		}
+		if v == 1000 {
+			total += max(cur, 1) * 1000
+			cur = 0
+			continue
+		}
+		cur += v
+	}
+	return FloatPtr(total + cur)
+}
 
-	return ParsedQty{Qty: qtyPtr, Unit: unitPtr, QtyRaw: qtyRawPtr}
+// IsPackUnit reports whether unit, as ParseQty returns it, counts packs
+// rather than pieces or measure.
+func IsPackUnit(unit string) bool {
+	return unit == "уп" || packPattern.MatchString(unit)
 }
 
 func normalizeUnit(unit string) string {
-	u := strings.ToLower(strings.TrimSpace(unit))
-	switch u {
-	case "шт", "штук", "pcs", "pc":
+	u := strings.ToLower(strings.Join(strings.Fields(unit), ""))
+	switch {
+	case u == "шт" || u == "шт." || strings.HasPrefix(u, "штук") || u == "pcs" || u == "pc":
 		return "шт"
-	case "м", "м.", "метр":
+	case u == "м" || u == "м." || strings.HasPrefix(u, "метр"):
 		return "м"
-	case "kg", "кг":
+	case u == "м2" || u == "м²" || strings.HasPrefix(u, "кв"):
+		return "м2"
+	case u == "м3" || u == "м³" || strings.HasPrefix(u, "куб"):
+		return "м3"
+	case u == "kg" || u == "кг":
 		return "кг"
-	case "уп", "уп.":
+	case u == "т" || u == "т." || strings.HasPrefix(u, "тонн"):
+		return "т"
+	case u == "л" || u == "л." || strings.HasPrefix(u, "литр"):
+		return "л"
+	case strings.HasPrefix(u, "пар"):
+		return "пар"
+	case u == "уп" || u == "уп.":
 		return "уп"
+	case strings.HasPrefix(u, "компл"):
+		return "компл"
+	case strings.HasPrefix(u, "бухт"):
+		return "бухта"
+	case strings.HasPrefix(u, "катуш"):
+		return "катушка"
+	case strings.HasPrefix(u, "барабан"):
+		return "барабан"
+	case strings.HasPrefix(u, "рулон"):
+		return "рулон"
+	case strings.HasPrefix(u, "пач"):
+		return "пачка"
+	case strings.HasPrefix(u, "упаков"):
+		return "упаковка"
 	default:
 		return u
 	}
@@ -1,6 +1,9 @@
 package util
 
-import "testing"
+import (
+	"fmt"
+	"testing"
+)
 
 func TestParseQty(t *testing.T) {
 	cases := []struct {
@@ -27,3 +30,57 @@ func TestParseQty(t *testing.T) {
 		})
 	}
 }
+
+func TestParseQtyGrammar(t *testing.T) {
+	cases := []struct {
+		input string
+		// want is "qty unit [raw]", then min-max and packaging when set.
+		want string
+	}{
+		{"Кабель ВВГнг 3x2.5 две бухты по 100 м", "200 м [две бухты по 100 м] pack=бухта 2x100"},
+		{"Саморез 10 упаковок по 50 шт", "500 шт [10 упаковок по 50 шт] pack=упаковка 10x50"},
+		{"Провод ПуГВ 2 по 100 м", "200 м [2 по 100 м] pack= 2x100"},
+		{"Лампа E27 5-6 шт", "6 шт [5-6 шт] range=5-6"},
+		{"Хомут от 10 до 20 уп", "20 уп [от 10 до 20 уп] range=10-20"},
+		{"Кабель 1 км", "1 км [1 км]"},
+		{"Перчатки десять пар", "10 пар [десять пар]"},
+		{"Розетка двадцать пять штук", "25 шт [двадцать пять штук]"},
+		{"Краска 3 л, грунт", "3 л [3 л]"},
+		{"Песок 2,5 т", "2.5 т [2,5 т]"},
+		{"Плитка 12 м2", "12 м2 [12 м2]"},
+		{"Бетон 4 м³", "4 м3 [4 м³]"},
+		{"Кабель 3 бухты", "3 бухта [3 бухты] pack=бухта 3x?"},
+		{"Лампа E27 x3", "3 - [x3]"},
+		{"Труба 20 мм 40", "40 - [40]"},
+		{"Автомат ABB S201 C16", "16 - [16]"},
+		{"Стол письменный", "nil"},
+		{"Сто т", "nil"},
+		{"Кабель сто метров", "100 м [сто метров]"},
+		{"Кабель ВВГнг 3 х 2,5 - 100", "100 - [100]"},
+		{"Кабель 4 x 16 50", "50 - [50]"},
+	}
+	for _, tc := range cases {
+		p := ParseQty(tc.input)
+		got := "nil"
+		if p.Qty != nil {
+			unit := "-"
+			if p.Unit != nil {
+				unit = *p.Unit
+			}
+			got = fmt.Sprintf("%g %s [%s]", *p.Qty, unit, *p.QtyRaw)
+		}
+		if p.Min != nil {
+			got += fmt.Sprintf(" range=%g-%g", *p.Min, *p.Max)
+		}
+		if pk := p.Packaging; pk != nil {
+			size := "?"
+			if pk.Size != nil {
+				size = fmt.Sprint(*pk.Size)
+			}
+			got += fmt.Sprintf(" pack=%s %gx%s", pk.Unit, pk.Count, size)
+		}
+		if got != tc.want {
+			t.Errorf("%q: got %q want %q", tc.input, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package util
+
+import (
+	"errors"
+	"strings"
+)
+
+// A request in "км" for a product sold by the metre, or in "упаковки" for
+// one sold by the piece, needs its quantity converted before it is quoted.
+// Units are grouped by dimension; within a dimension a unit is Factor base
+// units. Packs convert to the product's unit only through its pack size.
+
+type Dimension string
+
+const (
+	DimensionCount     Dimension = "count"
+	DimensionLength    Dimension = "length"
+	DimensionMass      Dimension = "mass"
+	DimensionArea      Dimension = "area"
+	DimensionVolume    Dimension = "volume"
+	DimensionPackaging Dimension = "packaging"
+)
+
+// Unit is a known unit: its canonical name, dimension and size in the base
+// unit of the dimension (шт, м, кг, м2, л; a pair is its own base).
+type Unit struct {
+	Name      string
+	Dimension Dimension
+	Base      string
+	Factor    float64
+}
+
+var (
+	ErrUnitMismatch    = errors.New("units of different dimensions")
+	ErrPackSizeUnknown = errors.New("pack size unknown")
+)
+
+var units = map[string]Unit{
+	"шт":       {"шт", DimensionCount, "шт", 1},
+	"компл":    {"компл", DimensionCount, "шт", 1},
+	"пар":      {"пар", DimensionCount, "пар", 1},
+	"м":        {"м", DimensionLength, "м", 1},
+	"км":       {"км", DimensionLength, "м", 1000},
+	"мм":       {"мм", DimensionLength, "м", 0.001},
+	"кг":       {"кг", DimensionMass, "кг", 1},
+	"г":        {"г", DimensionMass, "кг", 0.001},
+	"т":        {"т", DimensionMass, "кг", 1000},
+	"м2":       {"м2", DimensionArea, "м2", 1},
+	"л":        {"л", DimensionVolume, "л", 1},
+	"м3":       {"м3", DimensionVolume, "л", 1000},
+	"упаковка": {"упаковка", DimensionPackaging, "упаковка", 1},
+	"бухта":    {"бухта", DimensionPackaging, "бухта", 1},
+	"катушка":  {"катушка", DimensionPackaging, "катушка", 1},
+	"барабан":  {"барабан", DimensionPackaging, "барабан", 1},
+	"рулон":    {"рулон", DimensionPackaging, "рулон", 1},
+	"пачка":    {"пачка", DimensionPackaging, "пачка", 1},
+}
+
+// unitAliases are spellings of catalog unit headers that ParseQty does not
+// produce itself.
+var unitAliases = map[string]string{
+	"штука": "шт", "штуки": "шт", "ед": "шт", "комплект": "компл", "к-т": "компл", "кмп": "компл",
+	"пог.м": "м", "п.м": "м", "м.п": "м", "мп": "м", "тн": "т", "тонна": "т", "грамм": "г",
+	"кв.м": "м2", "куб.м": "м3", "уп": "упаковка", "упак": "упаковка",
+}
+
+// LookupUnit resolves a parsed unit or a catalog unit header such as
+// "шт.", "упак" or "пог. м".
+func LookupUnit(name string) (Unit, bool) {
+	u := strings.TrimSuffix(strings.ToLower(strings.Join(strings.Fields(name), "")), ".")
+	if alias, ok := unitAliases[u]; ok {
+		u = alias
+	}
+	if _, ok := units[u]; !ok {
+		u = normalizeUnit(u)
+		if alias, ok := unitAliases[u]; ok {
+			u = alias
+		}
+	}
+	unit, ok := units[u]
+	return unit, ok
+}
+
+// ConvertQty converts qty from one unit to another. Between a pack and a
+// unit of another dimension it takes packSize, the content of one pack in
+// base units of the other side (шт, м, кг...), 0 when unknown.
+func ConvertQty(qty float64, from, to Unit, packSize float64) (float64, error) {
+	fromPack, toPack := from.Dimension == DimensionPackaging, to.Dimension == DimensionPackaging
+	switch {
+	case from.Base == to.Base:
+		return qty * from.Factor / to.Factor, nil
+	case fromPack == toPack:
+		return 0, ErrUnitMismatch
+	case packSize <= 0:
+		return 0, ErrPackSizeUnknown
+	case fromPack:
+		return qty * packSize / to.Factor, nil
+	default:
+		return qty * from.Factor / packSize, nil
+	}
+}
@@ -0,0 +1,41 @@
+package util
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestConvertQty(t *testing.T) {
+	cases := []struct {
+		qty      float64
+		from, to string
+		packSize float64
+		want     float64
+		err      error
+	}{
+		{qty: 1, from: "км", to: "м", want: 1000},
+		{qty: 1500, from: "кг", to: "т", want: 1.5},
+		{qty: 2, from: "упаковка", to: "шт.", packSize: 50, want: 100},
+		{qty: 300, from: "шт", to: "упак", packSize: 100, want: 3},
+		{qty: 1, from: "км", to: "бухта", packSize: 200, want: 5},
+		{qty: 5, from: "компл", to: "шт", want: 5},
+		{qty: 2, from: "м3", to: "л", want: 2000},
+		{qty: 2, from: "уп", to: "шт", err: ErrPackSizeUnknown},
+		{qty: 10, from: "кг", to: "пог. м", err: ErrUnitMismatch},
+		{qty: 2, from: "бухта", to: "барабан", packSize: 100, err: ErrUnitMismatch},
+	}
+	for _, tc := range cases {
+		from, ok := LookupUnit(tc.from)
+		if !ok {
+			t.Fatalf("unknown unit %q", tc.from)
+		}
+		to, ok := LookupUnit(tc.to)
+		if !ok {
+			t.Fatalf("unknown unit %q", tc.to)
+		}
+		got, err := ConvertQty(tc.qty, from, to, tc.packSize)
+		if !errors.Is(err, tc.err) || (err == nil && got != tc.want) {
+			t.Errorf("%g %s -> %s: got %g, %v; want %g, %v", tc.qty, tc.from, tc.to, got, err, tc.want, tc.err)
+		}
+	}
+}